@@ -1,4 +1,4 @@
-module groupsync
+module github.com/Lvzhenqian/library/groupsync
 
 go 1.19
 
@@ -20,6 +20,9 @@ type Group[T any] struct {
 
 	wg   *sync.WaitGroup
 	pool *ants.Pool
+	// receivers 等待所有 receiver 把结果写入 collector
+	receivers *sync.WaitGroup
+	mu        sync.Mutex
 }
 
 func NewGroup[T any](collector *[]T, opt ...Options) (*Group[T], error) {
@@ -27,6 +30,7 @@ func NewGroup[T any](collector *[]T, opt ...Options) (*Group[T], error) {
 	group := &Group[T]{
 		collector: collector,
 		wg:        new(sync.WaitGroup),
+		receivers: new(sync.WaitGroup),
 	}
 	option := &Option{
 		worker:   10,
@@ -51,9 +55,13 @@ func NewGroup[T any](collector *[]T, opt ...Options) (*Group[T], error) {
 
 func (g *Group[T]) startReceiver(opt *Option) {
 	for i := 0; i < opt.receiver; i++ {
+		g.receivers.Add(1)
 		g.pool.Submit(func() {
+			defer g.receivers.Done()
 			for s := range g.channel {
+				g.mu.Lock()
 				*g.collector = append(*g.collector, s)
+				g.mu.Unlock()
 			}
 		})
 	}
@@ -61,16 +69,22 @@ func (g *Group[T]) startReceiver(opt *Option) {
 
 func (g *Group[T]) Go(fn func() T) error {
 	g.wg.Add(1)
-	return g.pool.Submit(func() {
+	err := g.pool.Submit(func() {
 		g.channel <- fn()
 		g.wg.Done()
 	})
+	if err != nil {
+		// 没有提交成功的任务不会执行，否则 Wait 会一直等待
+		g.wg.Done()
+	}
+	return err
 }
 
 func (g *Group[T]) Wait() {
 	defer g.pool.Release()
 	g.wg.Wait()
 	close(g.channel)
+	g.receivers.Wait()
 
 	return
 }
@@ -28,3 +28,30 @@ func TestGroup_Go(t *testing.T) {
 
 	t.Log(data)
 }
+
+func TestGroup_GoClosedPool(t *testing.T) {
+	data := make([]int, 0)
+	g, err := NewGroup(&data, WithLimit(2), WithReceivers(1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	g.Go(func() int { return 1 })
+	g.pool.Release()
+	if err := g.Go(func() int { return 2 }); err == nil {
+		t.Fatal("submit to a closed pool should fail")
+	}
+
+	done := make(chan struct{})
+	go func() {
+		g.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Wait blocked after a rejected submit")
+	}
+	if len(data) != 1 || data[0] != 1 {
+		t.Errorf("want only the submitted result, got %v", data)
+	}
+}
@@ -0,0 +1,351 @@
+package ssh
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+	terminal "golang.org/x/term"
+	"net"
+	"os"
+	"strings"
+	"time"
+)
+
+type authKind int
+
+const (
+	authAgent authKind = iota
+	authKeyFile
+	authKeyboardInteractive
+	authPassword
+)
+
+// DefaultIdentityFiles KeyFileAuth 未指定文件时按顺序尝试的私钥，不存在的文件会被跳过
+var DefaultIdentityFiles = []string{
+	"~/.ssh/id_ed25519",
+	"~/.ssh/id_ecdsa",
+	"~/.ssh/id_rsa",
+}
+
+// AuthMethod 认证方式，通过 AgentAuth/KeyFileAuth/CertificateAuth/KeyboardInteractiveAuth/PasswordAuth 创建
+type AuthMethod struct {
+	kind      authKind
+	files     []string
+	certs     []string
+	password  string
+	challenge ssh.KeyboardInteractiveChallenge
+}
+
+// AgentAuth 使用 SSH_AUTH_SOCK 指向的 ssh-agent 中的私钥，没有 agent 时跳过
+func AgentAuth() AuthMethod {
+	return AuthMethod{kind: authAgent}
+}
+
+// KeyFileAuth 使用私钥文件认证，参数也可以直接是 PEM 格式的私钥内容。
+// 不传参数时使用 DefaultIdentityFiles
+func KeyFileAuth(files ...string) AuthMethod {
+	return AuthMethod{kind: authKeyFile, files: files}
+}
+
+// CertificateAuth 使用 SSH 证书认证，cert 为证书文件（如 id_ed25519-cert.pub）或 authorized_keys 格式的证书内容，
+// 和 keys 中公钥相同的私钥配对，keys 为空时使用 DefaultIdentityFiles
+func CertificateAuth(cert string, keys ...string) AuthMethod {
+	return AuthMethod{kind: authKeyFile, files: keys, certs: []string{cert}}
+}
+
+// KeyboardInteractiveAuth keyboard-interactive 认证，适用于 OTP 等服务端提问的场景
+func KeyboardInteractiveAuth(challenge ssh.KeyboardInteractiveChallenge) AuthMethod {
+	return AuthMethod{kind: authKeyboardInteractive, challenge: challenge}
+}
+
+// PasswordAuth 密码认证
+func PasswordAuth(password string) AuthMethod {
+	return AuthMethod{kind: authPassword, password: password}
+}
+
+// TerminalChallenge 在终端上逐个显示服务端的问题并读取回答，echo 为 false 的问题不回显
+func TerminalChallenge(name, instruction string, questions []string, echos []bool) ([]string, error) {
+	if name != "" {
+		fmt.Fprintln(os.Stderr, name)
+	}
+	if instruction != "" {
+		fmt.Fprintln(os.Stderr, instruction)
+	}
+	answers := make([]string, len(questions))
+	for i, q := range questions {
+		fmt.Fprint(os.Stderr, q)
+		if echos[i] {
+			var answer string
+			if _, err := fmt.Fscanln(os.Stdin, &answer); err != nil {
+				return nil, err
+			}
+			answers[i] = answer
+			continue
+		}
+		answer, err := terminal.ReadPassword(int(os.Stdin.Fd()))
+		fmt.Fprintln(os.Stderr)
+		if err != nil {
+			return nil, err
+		}
+		answers[i] = string(answer)
+	}
+	return answers, nil
+}
+
+// TerminalPassphrase 在终端上读取私钥密码，可用作 AuthConfig.Passphrase
+func TerminalPassphrase(file string) ([]byte, error) {
+	fmt.Fprintf(os.Stderr, "Enter passphrase for key '%s': ", file)
+	defer fmt.Fprintln(os.Stderr)
+	return terminal.ReadPassword(int(os.Stdin.Fd()))
+}
+
+// methods 返回认证链，未配置 Methods 时按 Password/PrivateKey 推断，都没有则使用 agent 和默认私钥
+func (conf *AuthConfig) methods() []AuthMethod {
+	if len(conf.Methods) > 0 {
+		return conf.Methods
+	}
+	methods := make([]AuthMethod, 0, 2)
+	switch {
+	case conf.Certificate != "":
+		keys := make([]string, 0, 1)
+		if conf.PrivateKey != "" {
+			keys = append(keys, conf.PrivateKey)
+		}
+		methods = append(methods, CertificateAuth(conf.Certificate, keys...))
+	case conf.PrivateKey != "":
+		methods = append(methods, KeyFileAuth(conf.PrivateKey))
+	}
+	if conf.Password != "" {
+		methods = append(methods, PasswordAuth(conf.Password))
+	}
+	if len(methods) == 0 {
+		methods = append(methods, AgentAuth(), KeyFileAuth())
+	}
+	return methods
+}
+
+// identity 一个私钥，加密的私钥在前面的私钥都认证失败、轮到它时才通过 Passphrase 回调解密
+type identity struct {
+	name    string
+	content []byte
+	signer  ssh.Signer
+	// certs 和私钥文件同名的 -cert.pub 证书
+	certs []*ssh.Certificate
+}
+
+// parseCertificate 解析用户证书，参数可以是文件名或证书内容
+func parseCertificate(f string) (*ssh.Certificate, error) {
+	content := []byte(f)
+	if !strings.Contains(f, "-cert-v01@openssh.com ") {
+		var err error
+		if content, err = os.ReadFile(localRealPath(f)); err != nil {
+			return nil, fmt.Errorf("open certificate %s error: %w", f, err)
+		}
+	}
+	key, _, _, _, err := ssh.ParseAuthorizedKey(content)
+	if err != nil {
+		return nil, fmt.Errorf("parse certificate %s error: %w", f, err)
+	}
+	cert, ok := key.(*ssh.Certificate)
+	if !ok || cert.CertType != ssh.UserCert {
+		return nil, fmt.Errorf("parse certificate %s error: not a user certificate", f)
+	}
+	return cert, nil
+}
+
+// certSigners 为 signer 配对公钥相同的证书，证书在前，服务端不接受证书时再尝试私钥本身
+func certSigners(signer ssh.Signer, certs []*ssh.Certificate) ([]ssh.Signer, error) {
+	signers := make([]ssh.Signer, 0, len(certs)+1)
+	pub := signer.PublicKey().Marshal()
+	for _, cert := range certs {
+		if !bytes.Equal(cert.Key.Marshal(), pub) {
+			continue
+		}
+		certSigner, err := ssh.NewCertSigner(cert, signer)
+		if err != nil {
+			return nil, fmt.Errorf("certificate %s error: %w", ssh.FingerprintSHA256(cert.Key), err)
+		}
+		signers = append(signers, certSigner)
+	}
+	return append(signers, signer), nil
+}
+
+func loadIdentities(files []string) ([]*identity, error) {
+	explicit := len(files) > 0
+	if !explicit {
+		files = DefaultIdentityFiles
+	}
+	ret := make([]*identity, 0, len(files))
+	for _, f := range files {
+		id := &identity{name: f}
+		if strings.Contains(f, "PRIVATE KEY-----") {
+			id.name, id.content = "private key", []byte(f)
+		} else {
+			content, readErr := os.ReadFile(localRealPath(f))
+			switch {
+			case readErr == nil:
+				id.content = content
+			case !explicit && errors.Is(readErr, os.ErrNotExist):
+				continue
+			default:
+				return nil, fmt.Errorf("open private key %s error: %w", f, readErr)
+			}
+			cert, certErr := parseCertificate(f + "-cert.pub")
+			switch {
+			case certErr == nil:
+				id.certs = append(id.certs, cert)
+			case !errors.Is(certErr, os.ErrNotExist):
+				return nil, certErr
+			}
+		}
+
+		signer, parseErr := ssh.ParsePrivateKey(id.content)
+		var missing *ssh.PassphraseMissingError
+		switch {
+		case parseErr == nil:
+			id.signer = signer
+		case errors.As(parseErr, &missing):
+		default:
+			return nil, fmt.Errorf("parse private key %s error: %w", id.name, parseErr)
+		}
+		ret = append(ret, id)
+	}
+	return ret, nil
+}
+
+// authChain 把认证链转换为 ssh.AuthMethod。
+// golang.org/x/crypto/ssh 每种认证方式只会尝试一次，所以 agent 和所有私钥合并为一个可重试的 publickey 方法，
+// 放在链中第一次出现公钥认证的位置，每次尝试 agent 或其中一个私钥。返回的 release 在握手完成后调用，用于关闭 agent 连接
+func (conf *AuthConfig) authChain() ([]ssh.AuthMethod, func(), error) {
+	var (
+		auth      = make([]ssh.AuthMethod, 0)
+		sources   = make([]func() ([]ssh.Signer, error), 0)
+		publicKey = -1
+		agentConn net.Conn
+	)
+	release := func() {
+		if agentConn != nil {
+			agentConn.Close()
+		}
+	}
+	addSource := func(source func() ([]ssh.Signer, error)) {
+		if publicKey < 0 {
+			publicKey = len(auth)
+			auth = append(auth, nil)
+		}
+		sources = append(sources, source)
+	}
+
+	for _, m := range conf.methods() {
+		switch m.kind {
+		case authAgent:
+			addSource(func() ([]ssh.Signer, error) {
+				sock, ok := os.LookupEnv("SSH_AUTH_SOCK")
+				if !ok || sock == "" || agentConn != nil {
+					return nil, nil
+				}
+				conn, err := net.Dial("unix", sock)
+				if err != nil {
+					return nil, nil
+				}
+				agentConn = conn
+				return agent.NewClient(conn).Signers()
+			})
+		case authKeyFile:
+			identities, err := loadIdentities(m.files)
+			if err != nil {
+				return nil, release, err
+			}
+			certs := make([]*ssh.Certificate, 0, len(m.certs))
+			for _, f := range m.certs {
+				cert, certErr := parseCertificate(f)
+				if certErr != nil {
+					return nil, release, certErr
+				}
+				certs = append(certs, cert)
+			}
+			for _, id := range identities {
+				id := id
+				addSource(func() ([]ssh.Signer, error) {
+					if id.signer == nil && !conf.decrypt(id) {
+						return nil, nil
+					}
+					return certSigners(id.signer, append(id.certs[:len(id.certs):len(id.certs)], certs...))
+				})
+			}
+		case authKeyboardInteractive:
+			auth = append(auth, ssh.KeyboardInteractive(m.challenge))
+		case authPassword:
+			auth = append(auth, ssh.Password(m.password))
+		}
+	}
+
+	if publicKey >= 0 {
+		next := 0
+		publicKeys := ssh.PublicKeysCallback(func() ([]ssh.Signer, error) {
+			if next >= len(sources) {
+				return nil, nil
+			}
+			next++
+			return sources[next-1]()
+		})
+		// maxTries 为 0 表示无限重试，没有私钥时也只尝试一次
+		tries := len(sources)
+		if tries == 0 {
+			tries = 1
+		}
+		auth[publicKey] = ssh.RetryableAuthMethod(publicKeys, tries)
+	}
+	return auth, release, nil
+}
+
+// decrypt 通过 Passphrase 回调解密私钥。和 OpenSSH 一样，没有回调、读取密码失败或密码不正确时
+// 跳过这个私钥继续尝试后面的认证方式，不中断握手
+func (conf *AuthConfig) decrypt(id *identity) bool {
+	if conf.Passphrase == nil {
+		return false
+	}
+	passphrase, err := conf.Passphrase(id.name)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "skip private key %s: read passphrase error: %v\n", id.name, err)
+		return false
+	}
+	signer, err := ssh.ParsePrivateKeyWithPassphrase(id.content, passphrase)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "skip private key %s: decrypt error: %v\n", id.name, err)
+		return false
+	}
+	id.signer = signer
+	return true
+}
+
+// authConfig 生成 ssh.ClientConfig，release 需要在握手结束后调用
+func authConfig(conf *AuthConfig) (*ssh.ClientConfig, func(), error) {
+	auth, release, err := conf.authChain()
+	if err != nil {
+		return nil, release, err
+	}
+	hostKeyCallback, hostKeyErr := conf.HostKey.callback()
+	if hostKeyErr != nil {
+		return nil, release, hostKeyErr
+	}
+	algos, algoErr := conf.algorithms()
+	if algoErr != nil {
+		return nil, release, algoErr
+	}
+
+	return &ssh.ClientConfig{
+		Config: ssh.Config{
+			KeyExchanges: algos.KeyExchanges,
+			Ciphers:      algos.Ciphers,
+			MACs:         algos.MACs,
+		},
+		User:              conf.Username,
+		Auth:              auth,
+		HostKeyCallback:   hostKeyCallback,
+		HostKeyAlgorithms: algos.HostKeyAlgorithms,
+		Timeout:           time.Duration(conf.ConnectTimeout) * time.Second,
+	}, release, nil
+}
@@ -0,0 +1,209 @@
+package ssh
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"github.com/Lvzhenqian/library/ssh/sshtest"
+	"golang.org/x/crypto/ssh"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func writeTestKey(t *testing.T, passphrase string) string {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	block := &pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)}
+	if passphrase != "" {
+		//lint:ignore SA1019 只有旧版 PEM 加密可以在测试中直接生成
+		block, err = x509.EncryptPEMBlock(rand.Reader, block.Type, block.Bytes, []byte(passphrase), x509.PEMCipherAES128)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	file := filepath.Join(t.TempDir(), "id_rsa")
+	if err := os.WriteFile(file, pem.EncodeToMemory(block), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return file
+}
+
+func TestAuthConfig_methods(t *testing.T) {
+	conf := &AuthConfig{Password: "secret", PrivateKey: "~/.ssh/id_rsa"}
+	methods := conf.methods()
+	if len(methods) != 2 || methods[0].kind != authKeyFile || methods[1].kind != authPassword {
+		t.Errorf("unexpected chain: %+v", methods)
+	}
+
+	methods = (&AuthConfig{}).methods()
+	if len(methods) != 2 || methods[0].kind != authAgent || methods[1].kind != authKeyFile {
+		t.Errorf("unexpected default chain: %+v", methods)
+	}
+}
+
+func TestLoadIdentities_passphrase(t *testing.T) {
+	file := writeTestKey(t, "123456")
+	asked := 0
+	conf := &AuthConfig{
+		Methods: []AuthMethod{KeyFileAuth(file), PasswordAuth("secret")},
+		Passphrase: func(name string) ([]byte, error) {
+			asked++
+			if name != file {
+				t.Errorf("passphrase asked for %s", name)
+			}
+			return []byte("123456"), nil
+		},
+	}
+	auth, release, err := conf.authChain()
+	defer release()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(auth) != 2 {
+		t.Fatalf("want 2 auth methods, got %d", len(auth))
+	}
+	if asked != 0 {
+		t.Error("passphrase should be asked lazily")
+	}
+
+	identities, err := loadIdentities([]string{file})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if identities[0].signer != nil {
+		t.Error("encrypted key should not be decrypted while loading")
+	}
+}
+
+func TestAuthConfig_WrongPassphrase(t *testing.T) {
+	// 服务端同时接受公钥认证，客户端才会尝试私钥
+	conf := newTestServer(t, sshtest.WithAuthorizedKey("tester", newTestCA(t).PublicKey()))
+	encrypted := writeTestKey(t, "123456")
+	conf.Methods = []AuthMethod{KeyFileAuth(encrypted), PasswordAuth(conf.Password)}
+	conf.Passphrase = func(string) ([]byte, error) {
+		return []byte("wrong"), nil
+	}
+	// 解密失败时跳过私钥，和 OpenSSH 一样继续尝试密码认证
+	cli := newTestClient(t, conf)
+	if err := cli.Run("true", io.Discard, io.Discard); err != nil {
+		t.Fatal(err)
+	}
+
+	conf.Passphrase = func(string) ([]byte, error) {
+		return nil, errors.New("canceled")
+	}
+	newTestClient(t, conf)
+}
+
+func TestAuthConfig_PassphraseOnDemand(t *testing.T) {
+	plain := writeTestKey(t, "")
+	content, err := os.ReadFile(plain)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signer, err := ssh.ParsePrivateKey(content)
+	if err != nil {
+		t.Fatal(err)
+	}
+	conf := newTestServer(t, sshtest.WithAuthorizedKey("tester", signer.PublicKey()))
+	conf.Password = ""
+	asked := 0
+	conf.Methods = []AuthMethod{KeyFileAuth(plain, writeTestKey(t, "123456"))}
+	conf.Passphrase = func(string) ([]byte, error) {
+		asked++
+		return []byte("123456"), nil
+	}
+	newTestClient(t, conf)
+	if asked != 0 {
+		t.Errorf("passphrase asked %d times although the first key was accepted", asked)
+	}
+}
+
+func TestLoadIdentities_missing(t *testing.T) {
+	_, err := loadIdentities([]string{filepath.Join(t.TempDir(), "id_none")})
+	if !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("want not exist error, got %v", err)
+	}
+
+	plain := writeTestKey(t, "")
+	content, _ := os.ReadFile(plain)
+	identities, err := loadIdentities([]string{string(content)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if identities[0].signer == nil {
+		t.Error("inline private key should be parsed")
+	}
+}
+
+func newTestCA(t *testing.T) ssh.Signer {
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signer, err := ssh.NewSignerFromKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signer
+}
+
+func signTestCert(t *testing.T, ca ssh.Signer, key ssh.PublicKey, certType uint32, principals ...string) *ssh.Certificate {
+	cert := &ssh.Certificate{
+		Key:             key,
+		CertType:        certType,
+		ValidPrincipals: principals,
+		ValidBefore:     ssh.CertTimeInfinity,
+	}
+	if err := cert.SignCert(rand.Reader, ca); err != nil {
+		t.Fatal(err)
+	}
+	return cert
+}
+
+func TestLoadIdentities_certificate(t *testing.T) {
+	file := writeTestKey(t, "")
+	content, _ := os.ReadFile(file)
+	signer, err := ssh.ParsePrivateKey(content)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ca := newTestCA(t)
+	cert := signTestCert(t, ca, signer.PublicKey(), ssh.UserCert, "tester")
+	if err := os.WriteFile(file+"-cert.pub", ssh.MarshalAuthorizedKey(cert), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	identities, err := loadIdentities([]string{file})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(identities[0].certs) != 1 {
+		t.Fatalf("want -cert.pub loaded, got %d certs", len(identities[0].certs))
+	}
+	other := signTestCert(t, ca, newTestPublicKey(t), ssh.UserCert, "tester")
+	signers, err := certSigners(identities[0].signer, append(identities[0].certs, other))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(signers) != 2 {
+		t.Fatalf("want certificate and key signers, got %d", len(signers))
+	}
+	if _, ok := signers[0].PublicKey().(*ssh.Certificate); !ok {
+		t.Error("certificate should be tried before the bare key")
+	}
+
+	if _, err := parseCertificate(string(ssh.MarshalAuthorizedKey(signer.PublicKey()))); err == nil {
+		t.Error("want error for a bare public key")
+	}
+	if _, err := parseCertificate(string(ssh.MarshalAuthorizedKey(cert))); err != nil {
+		t.Errorf("inline certificate: %v", err)
+	}
+}
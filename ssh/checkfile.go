@@ -0,0 +1,103 @@
+package ssh
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// sftp 协议 v3 的包类型，check-file 扩展见 draft-ietf-secsh-filexfer-extensions-00
+const (
+	sftpInit          = 1
+	sftpVersion       = 2
+	sftpStatus        = 101
+	sftpExtended      = 200
+	sftpExtendedReply = 201
+
+	sftpProtocolVersion = 3
+	// maxSftpPacket 只用来读 VERSION 和 check-file 的回复，不会很大
+	maxSftpPacket = 256 * 1024
+)
+
+func writeSftpPacket(w io.Writer, typ byte, data []byte) error {
+	b := binary.BigEndian.AppendUint32(make([]byte, 0, len(data)+5), uint32(len(data)+1))
+	b = append(append(b, typ), data...)
+	_, err := w.Write(b)
+	return err
+}
+
+func readSftpPacket(r io.Reader) (byte, []byte, error) {
+	header := make([]byte, 4)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return 0, nil, err
+	}
+	length := binary.BigEndian.Uint32(header)
+	if length == 0 || length > maxSftpPacket {
+		return 0, nil, fmt.Errorf("sftp: invalid packet length %d", length)
+	}
+	data := make([]byte, length)
+	if _, err := io.ReadFull(r, data); err != nil {
+		return 0, nil, err
+	}
+	return data[0], data[1:], nil
+}
+
+func appendSftpString(b []byte, s string) []byte {
+	return append(binary.BigEndian.AppendUint32(b, uint32(len(s))), s...)
+}
+
+func readSftpString(b []byte) (string, []byte, bool) {
+	if len(b) < 4 {
+		return "", nil, false
+	}
+	n := binary.BigEndian.Uint32(b)
+	if uint64(len(b)-4) < uint64(n) {
+		return "", nil, false
+	}
+	return string(b[4 : 4+n]), b[4+n:], true
+}
+
+// sftpCheckFile 通过 check-file-name 扩展让服务端计算整个文件的 SHA-256，只传输 hash 本身。
+// rw 为刚打开的 sftp 子系统，调用方需要先确认服务端支持 check-file
+func sftpCheckFile(rw io.ReadWriter, name string) (string, error) {
+	if err := writeSftpPacket(rw, sftpInit, binary.BigEndian.AppendUint32(nil, sftpProtocolVersion)); err != nil {
+		return "", err
+	}
+	typ, _, err := readSftpPacket(rw)
+	if err != nil {
+		return "", err
+	}
+	if typ != sftpVersion {
+		return "", fmt.Errorf("sftp: unexpected packet type %d, want version", typ)
+	}
+
+	req := binary.BigEndian.AppendUint32(nil, 1)
+	req = appendSftpString(req, "check-file-name")
+	req = appendSftpString(req, name)
+	req = appendSftpString(req, "sha256")
+	// 起始位置和长度都为 0 表示整个文件，block size 为 0 表示只返回一个 hash
+	req = binary.BigEndian.AppendUint64(req, 0)
+	req = binary.BigEndian.AppendUint64(req, 0)
+	req = binary.BigEndian.AppendUint32(req, 0)
+	if err := writeSftpPacket(rw, sftpExtended, req); err != nil {
+		return "", err
+	}
+	typ, data, err := readSftpPacket(rw)
+	if err != nil {
+		return "", err
+	}
+	switch {
+	case typ == sftpStatus:
+		return "", errors.New("sftp: check-file failed")
+	case typ != sftpExtendedReply || len(data) < 4:
+		return "", fmt.Errorf("sftp: unexpected packet type %d, want extended reply", typ)
+	}
+	algo, hash, ok := readSftpString(data[4:])
+	if !ok || algo != "sha256" || len(hash) != sha256.Size {
+		return "", fmt.Errorf("sftp: check-file returned %q hash", algo)
+	}
+	return hex.EncodeToString(hash), nil
+}
@@ -0,0 +1,55 @@
+package ssh
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"net"
+	"strings"
+	"testing"
+)
+
+// serveCheckFile 模拟支持 check-file 的服务端，reply 为 nil 时回复 SSH_FXP_STATUS
+func serveCheckFile(t *testing.T, conn net.Conn, want string, reply []byte) {
+	defer conn.Close()
+	if typ, data, err := readSftpPacket(conn); err != nil || typ != sftpInit || binary.BigEndian.Uint32(data) != sftpProtocolVersion {
+		t.Errorf("init = %d, %v", typ, err)
+		return
+	}
+	version := binary.BigEndian.AppendUint32(nil, sftpProtocolVersion)
+	version = appendSftpString(appendSftpString(version, "check-file"), "")
+	writeSftpPacket(conn, sftpVersion, version)
+
+	typ, data, err := readSftpPacket(conn)
+	if err != nil || typ != sftpExtended {
+		t.Errorf("request = %d, %v", typ, err)
+		return
+	}
+	ext, rest, _ := readSftpString(data[4:])
+	name, rest, _ := readSftpString(rest)
+	algo, rest, _ := readSftpString(rest)
+	if ext != "check-file-name" || name != want || algo != "sha256" || len(rest) != 20 {
+		t.Errorf("request = %s %s %s %d", ext, name, algo, len(rest))
+	}
+	if reply == nil {
+		writeSftpPacket(conn, sftpStatus, binary.BigEndian.AppendUint32(data[:4:4], 4))
+		return
+	}
+	writeSftpPacket(conn, sftpExtendedReply, append(appendSftpString(data[:4:4], "sha256"), reply...))
+}
+
+func TestSftpCheckFile(t *testing.T) {
+	sum := sha256.Sum256([]byte("hello"))
+	client, server := net.Pipe()
+	go serveCheckFile(t, server, "/data/a.bin", sum[:])
+	got, err := sftpCheckFile(client, "/data/a.bin")
+	if err != nil || got != hex.EncodeToString(sum[:]) {
+		t.Errorf("sftpCheckFile = %s, %v", got, err)
+	}
+
+	client, server = net.Pipe()
+	go serveCheckFile(t, server, "/data/missing", nil)
+	if _, err := sftpCheckFile(client, "/data/missing"); err == nil || !strings.Contains(err.Error(), "check-file failed") {
+		t.Errorf("want check-file error, got %v", err)
+	}
+}
@@ -0,0 +1,239 @@
+package ssh
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"golang.org/x/crypto/ssh"
+	"net"
+	"regexp"
+	"strings"
+	"sync"
+)
+
+type CryptoProfile int
+
+const (
+	// CryptoDefault 使用 golang.org/x/crypto/ssh 的默认算法
+	CryptoDefault CryptoProfile = iota
+	// CryptoModern 只使用目前推荐的算法，适用于加固过的主机
+	CryptoModern
+	// CryptoCompatible 在 CryptoModern 的基础上增加 SHA1、CBC 和 ssh-rsa，兼容较旧的 OpenSSH
+	CryptoCompatible
+	// CryptoLegacy 在 CryptoCompatible 的基础上增加 diffie-hellman-group1-sha1、3des-cbc 和 ssh-dss，
+	// 只用于无法升级的老旧交换机等设备
+	CryptoLegacy
+)
+
+func (p CryptoProfile) String() string {
+	switch p {
+	case CryptoDefault:
+		return "default"
+	case CryptoModern:
+		return "modern"
+	case CryptoCompatible:
+		return "compatible"
+	case CryptoLegacy:
+		return "legacy"
+	default:
+		return fmt.Sprintf("CryptoProfile(%d)", int(p))
+	}
+}
+
+// Algorithms SSH 协商使用的算法，按优先顺序排列，为空的列表使用 CryptoProfile 的设置
+type Algorithms struct {
+	KeyExchanges      []string
+	Ciphers           []string
+	MACs              []string
+	HostKeyAlgorithms []string
+}
+
+var (
+	modernAlgorithms = Algorithms{
+		KeyExchanges: []string{
+			"curve25519-sha256", "curve25519-sha256@libssh.org",
+			"ecdh-sha2-nistp256", "ecdh-sha2-nistp384", "ecdh-sha2-nistp521",
+			"diffie-hellman-group14-sha256",
+		},
+		Ciphers: []string{
+			"aes128-gcm@openssh.com", "chacha20-poly1305@openssh.com",
+			"aes256-ctr", "aes192-ctr", "aes128-ctr",
+		},
+		MACs: []string{"hmac-sha2-256-etm@openssh.com", "hmac-sha2-256"},
+		HostKeyAlgorithms: []string{
+			ssh.CertAlgoED25519v01, ssh.CertAlgoECDSA256v01, ssh.CertAlgoECDSA384v01, ssh.CertAlgoECDSA521v01,
+			ssh.CertAlgoRSASHA512v01, ssh.CertAlgoRSASHA256v01,
+			ssh.KeyAlgoED25519, ssh.KeyAlgoECDSA256, ssh.KeyAlgoECDSA384, ssh.KeyAlgoECDSA521,
+			ssh.KeyAlgoRSASHA512, ssh.KeyAlgoRSASHA256,
+		},
+	}
+	compatibleAlgorithms = Algorithms{
+		KeyExchanges:      extend(modernAlgorithms.KeyExchanges, "diffie-hellman-group-exchange-sha256", "diffie-hellman-group14-sha1"),
+		Ciphers:           extend(modernAlgorithms.Ciphers, "aes128-cbc"),
+		MACs:              extend(modernAlgorithms.MACs, "hmac-sha1"),
+		HostKeyAlgorithms: extend(modernAlgorithms.HostKeyAlgorithms, ssh.CertAlgoRSAv01, ssh.KeyAlgoRSA),
+	}
+	legacyAlgorithms = Algorithms{
+		KeyExchanges:      extend(compatibleAlgorithms.KeyExchanges, "diffie-hellman-group-exchange-sha1", "diffie-hellman-group1-sha1"),
+		Ciphers:           extend(compatibleAlgorithms.Ciphers, "3des-cbc"),
+		MACs:              extend(compatibleAlgorithms.MACs, "hmac-sha1-96"),
+		HostKeyAlgorithms: extend(compatibleAlgorithms.HostKeyAlgorithms, ssh.CertAlgoDSAv01, ssh.KeyAlgoDSA),
+	}
+)
+
+// extend 返回 base 追加 more 后的新列表，不修改 base
+func extend(base []string, more ...string) []string {
+	return append(append(make([]string, 0, len(base)+len(more)), base...), more...)
+}
+
+// ProfileAlgorithms 返回 profile 使用的算法，CryptoDefault 返回空列表
+func ProfileAlgorithms(profile CryptoProfile) (Algorithms, error) {
+	switch profile {
+	case CryptoDefault:
+		return Algorithms{}, nil
+	case CryptoModern:
+		return modernAlgorithms, nil
+	case CryptoCompatible:
+		return compatibleAlgorithms, nil
+	case CryptoLegacy:
+		return legacyAlgorithms, nil
+	default:
+		return Algorithms{}, fmt.Errorf("unknown crypto profile %d", int(profile))
+	}
+}
+
+// algorithms Crypto 对应的算法，再用 Algorithms 中不为空的列表覆盖
+func (conf *AuthConfig) algorithms() (Algorithms, error) {
+	algos, err := ProfileAlgorithms(conf.Crypto)
+	if err != nil {
+		return algos, err
+	}
+	override := func(dst *[]string, src []string) {
+		if len(src) > 0 {
+			*dst = src
+		}
+	}
+	override(&algos.KeyExchanges, conf.Algorithms.KeyExchanges)
+	override(&algos.Ciphers, conf.Algorithms.Ciphers)
+	override(&algos.MACs, conf.Algorithms.MACs)
+	override(&algos.HostKeyAlgorithms, conf.Algorithms.HostKeyAlgorithms)
+	return algos, nil
+}
+
+// AlgorithmError 和服务端协商算法失败，Server 为服务端支持的全部算法，可以据此选择 AuthConfig.Crypto 或设置 Algorithms
+type AlgorithmError struct {
+	Host string
+	// What 协商失败的类别，如 key exchange、host key、client to server cipher
+	What string
+	// Client 客户端提供的 What 类别的算法
+	Client []string
+	Server Algorithms
+	err    error
+}
+
+func (e *AlgorithmError) Error() string {
+	return fmt.Sprintf("no common algorithm for %s with %s: client offered %v; server offers key exchanges %v, host keys %v, ciphers %v, MACs %v",
+		e.What, e.Host, e.Client, e.Server.KeyExchanges, e.Server.HostKeyAlgorithms, e.Server.Ciphers, e.Server.MACs)
+}
+
+func (e *AlgorithmError) Unwrap() error {
+	return e.err
+}
+
+var noCommonAlgorithm = regexp.MustCompile(`no common algorithm for ([^;]+); client offered: \[([^\]]*)\]`)
+
+// kexSniffer 记录服务端发送的版本号和第一个数据包（明文的 KEXINIT），协商失败时用于列出服务端的算法
+type kexSniffer struct {
+	net.Conn
+	mu  sync.Mutex
+	buf []byte
+	off bool
+}
+
+// maxSniff 最多记录的字节数，KEXINIT 通常只有 1～2KB
+const maxSniff = 64 * 1024
+
+func (s *kexSniffer) Read(p []byte) (int, error) {
+	n, err := s.Conn.Read(p)
+	s.mu.Lock()
+	if !s.off {
+		s.buf = append(s.buf, p[:n]...)
+		s.off = len(s.buf) >= maxSniff
+	}
+	s.mu.Unlock()
+	return n, err
+}
+
+func (s *kexSniffer) stop() []byte {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	buf := s.buf
+	s.buf, s.off = nil, true
+	return buf
+}
+
+// parseKexInit 从服务端的输出中解析 KEXINIT，跳过版本号之前的 banner
+func parseKexInit(buf []byte) (Algorithms, bool) {
+	for {
+		i := bytes.IndexByte(buf, '\n')
+		if i < 0 {
+			return Algorithms{}, false
+		}
+		line := buf[:i]
+		buf = buf[i+1:]
+		if bytes.HasPrefix(line, []byte("SSH-")) {
+			break
+		}
+	}
+	if len(buf) < 5 {
+		return Algorithms{}, false
+	}
+	length, padding := binary.BigEndian.Uint32(buf), int(buf[4])
+	if uint64(len(buf)) < 4+uint64(length) || int(length) < padding+1 {
+		return Algorithms{}, false
+	}
+	var msg struct {
+		Cookie                  [16]byte `sshtype:"20"`
+		KexAlgos                []string
+		ServerHostKeyAlgos      []string
+		CiphersClientServer     []string
+		CiphersServerClient     []string
+		MACsClientServer        []string
+		MACsServerClient        []string
+		CompressionClientServer []string
+		CompressionServerClient []string
+		LanguagesClientServer   []string
+		LanguagesServerClient   []string
+		FirstKexFollows         bool
+		Reserved                uint32
+	}
+	if err := ssh.Unmarshal(buf[5:4+int(length)-padding], &msg); err != nil {
+		return Algorithms{}, false
+	}
+	return Algorithms{
+		KeyExchanges:      msg.KexAlgos,
+		Ciphers:           msg.CiphersClientServer,
+		MACs:              msg.MACsClientServer,
+		HostKeyAlgorithms: msg.ServerHostKeyAlgos,
+	}, true
+}
+
+// handshake 在 conn 上建立 SSH 连接，算法协商失败时返回 *AlgorithmError
+func handshake(conn net.Conn, addr string, config *ssh.ClientConfig) (*ssh.Client, error) {
+	sniffer := &kexSniffer{Conn: conn}
+	c, chans, reqs, err := ssh.NewClientConn(sniffer, addr, config)
+	buf := sniffer.stop()
+	if err != nil {
+		conn.Close()
+		m := noCommonAlgorithm.FindStringSubmatch(err.Error())
+		if m == nil {
+			return nil, err
+		}
+		server, ok := parseKexInit(buf)
+		if !ok {
+			return nil, err
+		}
+		return nil, &AlgorithmError{Host: addr, What: m[1], Client: strings.Fields(m[2]), Server: server, err: err}
+	}
+	return ssh.NewClient(c, chans, reqs), nil
+}
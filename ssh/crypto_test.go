@@ -0,0 +1,113 @@
+package ssh
+
+import (
+	"encoding/binary"
+	"errors"
+	"github.com/Lvzhenqian/library/ssh/sshtest"
+	"golang.org/x/crypto/ssh"
+	"io"
+	"reflect"
+	"testing"
+)
+
+func TestAuthConfig_algorithms(t *testing.T) {
+	algos, err := (&AuthConfig{}).algorithms()
+	if err != nil || !reflect.DeepEqual(algos, Algorithms{}) {
+		t.Errorf("default profile should leave algorithms empty: %+v, %v", algos, err)
+	}
+
+	conf := &AuthConfig{Crypto: CryptoLegacy, Algorithms: Algorithms{Ciphers: []string{"aes128-cbc"}}}
+	if algos, err = conf.algorithms(); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(algos.Ciphers, []string{"aes128-cbc"}) || !reflect.DeepEqual(algos.KeyExchanges, legacyAlgorithms.KeyExchanges) {
+		t.Errorf("unexpected algorithms: %+v", algos)
+	}
+	if len(modernAlgorithms.Ciphers) != 5 || len(compatibleAlgorithms.Ciphers) != 6 {
+		t.Error("profiles should not share backing arrays")
+	}
+
+	if _, err := (&AuthConfig{Crypto: CryptoProfile(9)}).algorithms(); err == nil {
+		t.Error("want error for an unknown profile")
+	}
+}
+
+func TestParseKexInit(t *testing.T) {
+	payload := ssh.Marshal(struct {
+		Cookie                  [16]byte `sshtype:"20"`
+		KexAlgos                []string
+		ServerHostKeyAlgos      []string
+		CiphersClientServer     []string
+		CiphersServerClient     []string
+		MACsClientServer        []string
+		MACsServerClient        []string
+		CompressionClientServer []string
+		CompressionServerClient []string
+		LanguagesClientServer   []string
+		LanguagesServerClient   []string
+		FirstKexFollows         bool
+		Reserved                uint32
+	}{
+		KexAlgos:                []string{"diffie-hellman-group1-sha1"},
+		ServerHostKeyAlgos:      []string{"ssh-rsa"},
+		CiphersClientServer:     []string{"aes128-cbc", "3des-cbc"},
+		CiphersServerClient:     []string{"aes128-cbc", "3des-cbc"},
+		MACsClientServer:        []string{"hmac-sha1"},
+		MACsServerClient:        []string{"hmac-sha1"},
+		CompressionClientServer: []string{"none"},
+		CompressionServerClient: []string{"none"},
+	})
+	padding := 8 - (len(payload)+5)%8 + 4
+	packet := make([]byte, 5, 5+len(payload)+padding)
+	binary.BigEndian.PutUint32(packet, uint32(1+len(payload)+padding))
+	packet[4] = byte(padding)
+	packet = append(append(packet, payload...), make([]byte, padding)...)
+	buf := append([]byte("Welcome to switch\r\nSSH-2.0-Cisco-1.25\r\n"), packet...)
+
+	algos, ok := parseKexInit(buf)
+	if !ok {
+		t.Fatal("failed to parse KEXINIT")
+	}
+	want := Algorithms{
+		KeyExchanges:      []string{"diffie-hellman-group1-sha1"},
+		Ciphers:           []string{"aes128-cbc", "3des-cbc"},
+		MACs:              []string{"hmac-sha1"},
+		HostKeyAlgorithms: []string{"ssh-rsa"},
+	}
+	if !reflect.DeepEqual(algos, want) {
+		t.Errorf("got %+v", algos)
+	}
+	if _, ok := parseKexInit(buf[:len(buf)-padding-1]); ok {
+		t.Error("truncated packet should not parse")
+	}
+}
+
+func TestClientType_Algorithms(t *testing.T) {
+	conf := newTestServer(t, sshtest.WithServerConfig(func(c *ssh.ServerConfig) {
+		c.KeyExchanges = []string{"diffie-hellman-group1-sha1"}
+		c.Ciphers = []string{"aes128-cbc"}
+	}))
+
+	_, err := NewClient(conf)
+	var algoErr *AlgorithmError
+	if !errors.As(err, &algoErr) {
+		t.Fatalf("want AlgorithmError, got %v", err)
+	}
+	if algoErr.What != "key exchange" || !reflect.DeepEqual(algoErr.Server.KeyExchanges, []string{"diffie-hellman-group1-sha1"}) ||
+		!reflect.DeepEqual(algoErr.Server.Ciphers, []string{"aes128-cbc"}) {
+		t.Errorf("unexpected error: %v", algoErr)
+	}
+
+	conf.Crypto = CryptoModern
+	conf.Algorithms = Algorithms{KeyExchanges: []string{"diffie-hellman-group1-sha1"}}
+	if _, err := NewClient(conf); !errors.As(err, &algoErr) || algoErr.What != "client to server cipher" {
+		t.Errorf("want cipher negotiation error, got %v", err)
+	}
+
+	conf.Crypto = CryptoLegacy
+	conf.Algorithms = Algorithms{}
+	cli := newTestClient(t, conf)
+	if err := cli.Run("true", io.Discard, io.Discard); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,377 @@
+package ssh
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"golang.org/x/crypto/ssh"
+	"io"
+	"os"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ErrSudoPassword sudo 密码错误或需要密码但没有提供
+var ErrSudoPassword = errors.New("sudo: incorrect password")
+
+// Result 远程命令的执行结果
+type Result struct {
+	Cmd string
+	// ExitCode 退出码，被信号终止时为 128+信号值，和 shell 的 $? 一致
+	ExitCode int
+	// Signal 远程进程被信号终止时的信号名
+	Signal string
+	// Stdout/Stderr 没有通过 WithStdout/WithStderr 指定输出时捕获的内容
+	Stdout   []byte
+	Stderr   []byte
+	Duration time.Duration
+}
+
+// Success 退出码为 0
+func (r *Result) Success() bool {
+	return r.ExitCode == 0 && r.Signal == ""
+}
+
+// envName 合法的环境变量名，export 时变量名原样拼接到命令中
+var envName = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+type envVar struct {
+	key, value string
+}
+
+type command struct {
+	stdin      io.Reader
+	stdout     io.Writer
+	stderr     io.Writer
+	env        []envVar
+	dir        string
+	pty        bool
+	term       string
+	height     int
+	width      int
+	sudo       bool
+	sudoUser   string
+	sudoPasswd string
+	// err 选项不合法，Exec 直接返回
+	err error
+}
+
+type CommandOption func(*command)
+
+// WithStdin 远程命令的标准输入
+func WithStdin(r io.Reader) CommandOption {
+	return func(c *command) {
+		c.stdin = r
+	}
+}
+
+// WithStdout 实时输出远程命令的标准输出，设置后 Result.Stdout 为空
+func WithStdout(w io.Writer) CommandOption {
+	return func(c *command) {
+		c.stdout = w
+	}
+}
+
+// WithStderr 实时输出远程命令的标准错误，设置后 Result.Stderr 为空
+func WithStderr(w io.Writer) CommandOption {
+	return func(c *command) {
+		c.stderr = w
+	}
+}
+
+// WithEnv 设置环境变量，优先使用 Setenv 请求，服务端未通过 AcceptEnv 允许时改为在命令前 export。
+// key 只能包含字母、数字和下划线且不以数字开头，否则 Exec 返回错误
+func WithEnv(key, value string) CommandOption {
+	return func(c *command) {
+		if !envName.MatchString(key) {
+			if c.err == nil {
+				c.err = fmt.Errorf("invalid environment variable name %q", key)
+			}
+			return
+		}
+		c.env = append(c.env, envVar{key: key, value: value})
+	}
+}
+
+// WithDir 命令的工作目录
+func WithDir(dir string) CommandOption {
+	return func(c *command) {
+		c.dir = dir
+	}
+}
+
+// WithPty 为命令申请伪终端，term 为空时使用 $TERM。使用伪终端时 stderr 会合并到 stdout
+func WithPty(term string, height, width int) CommandOption {
+	return func(c *command) {
+		c.pty = true
+		c.term = term
+		c.height = height
+		c.width = width
+	}
+}
+
+// WithSudo 使用 sudo 以 root 身份执行，sudo 提示输入密码时自动填入 password
+func WithSudo(password string) CommandOption {
+	return WithSudoUser("", password)
+}
+
+// WithSudoUser 使用 sudo 以 user 身份执行
+func WithSudoUser(user, password string) CommandOption {
+	return func(c *command) {
+		c.sudo = true
+		c.sudoUser = user
+		c.sudoPasswd = password
+	}
+}
+
+// shellQuote 使用单引号转义，用于拼接到远程 shell 命令中
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
+
+func randomMarker(prefix string) string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return prefix + hex.EncodeToString(b)
+}
+
+// script 拼接最终执行的命令，exports 为需要通过 export 设置的环境变量
+func (c *command) script(cmd string, exports []envVar, prompt, ready string) string {
+	var b strings.Builder
+	for _, e := range exports {
+		fmt.Fprintf(&b, "export %s=%s; ", e.key, shellQuote(e.value))
+	}
+	if c.dir != "" {
+		fmt.Fprintf(&b, "cd %s && ", shellQuote(c.dir))
+	}
+	b.WriteString(cmd)
+	if !c.sudo {
+		return b.String()
+	}
+
+	sudo := "sudo -S -p " + shellQuote(prompt)
+	if c.sudoUser != "" {
+		sudo += " -u " + shellQuote(c.sudoUser)
+	}
+	// sudo 认证通过后先输出 ready 标记，之后才把调用方的 stdin 交给命令
+	return fmt.Sprintf("%s -- sh -c %s", sudo, shellQuote("echo "+ready+" >&2; "+b.String()))
+}
+
+// sudoFilter 从输出中识别 sudo 的密码提示和 ready 标记，并把它们从输出中去掉
+type sudoFilter struct {
+	mu       sync.Mutex
+	w        io.Writer
+	buf      []byte
+	prompt   string
+	ready    string
+	onPrompt func() error
+	onReady  func()
+	done     bool
+}
+
+func (f *sudoFilter) Write(p []byte) (int, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.done {
+		return f.w.Write(p)
+	}
+	f.buf = append(f.buf, p...)
+	for {
+		if i := bytes.Index(f.buf, []byte(f.prompt)); i >= 0 {
+			if _, err := f.w.Write(f.buf[:i]); err != nil {
+				return 0, err
+			}
+			f.buf = f.buf[i+len(f.prompt):]
+			if err := f.onPrompt(); err != nil {
+				return 0, err
+			}
+			continue
+		}
+		if i := bytes.Index(f.buf, []byte(f.ready)); i >= 0 {
+			if _, err := f.w.Write(f.buf[:i]); err != nil {
+				return 0, err
+			}
+			rest := bytes.TrimLeft(f.buf[i+len(f.ready):], "\r\n")
+			f.buf = nil
+			f.done = true
+			f.onReady()
+			if _, err := f.w.Write(rest); err != nil {
+				return 0, err
+			}
+			return len(p), nil
+		}
+		break
+	}
+	// 保留可能是标记前缀的部分，其余直接输出
+	keep := 0
+	for _, marker := range []string{f.prompt, f.ready} {
+		for n := len(marker) - 1; n > keep; n-- {
+			if bytes.HasSuffix(f.buf, []byte(marker[:n])) {
+				keep = n
+				break
+			}
+		}
+	}
+	if _, err := f.w.Write(f.buf[:len(f.buf)-keep]); err != nil {
+		return 0, err
+	}
+	f.buf = append([]byte(nil), f.buf[len(f.buf)-keep:]...)
+	return len(p), nil
+}
+
+func (f *sudoFilter) Flush() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	_, err := f.w.Write(f.buf)
+	f.buf = nil
+	return err
+}
+
+// Exec 执行远程命令并返回结果。命令正常结束时即使退出码非 0 也不返回错误，通过 Result.ExitCode 判断；
+// ctx 取消时的处理同 RunContext，返回 *RunError
+func (c *ClientType) Exec(ctx context.Context, cmd string, option ...CommandOption) (*Result, error) {
+	opt := &command{}
+	for _, o := range option {
+		o(opt)
+	}
+	if opt.err != nil {
+		return nil, opt.err
+	}
+	result := &Result{Cmd: cmd}
+	start := time.Now()
+	defer func() {
+		result.Duration = time.Since(start)
+	}()
+
+	session, sessionErr := c.newSessionContext(ctx)
+	if sessionErr != nil {
+		if ctxErr := ctx.Err(); ctxErr != nil && errors.Is(sessionErr, ctxErr) {
+			return nil, canceledError(cmd, ctxErr)
+		}
+		return nil, sessionErr
+	}
+	defer c.closeSession(session)
+
+	exports := make([]envVar, 0)
+	for _, e := range opt.env {
+		// sudo 默认会重置环境变量，只能在命令中 export
+		if opt.sudo || session.Setenv(e.key, e.value) != nil {
+			exports = append(exports, e)
+		}
+	}
+
+	if opt.pty {
+		term := opt.term
+		if term == "" {
+			term = os.Getenv("TERM")
+		}
+		if term == "" {
+			term = "xterm"
+		}
+		height, width := opt.height, opt.width
+		if height <= 0 || width <= 0 {
+			height, width = 24, 80
+		}
+		if err := session.RequestPty(term, height, width, ssh.TerminalModes{ssh.ECHO: 0}); err != nil {
+			return nil, fmt.Errorf("session.RequestPty error: %w", err)
+		}
+	}
+
+	var stdoutBuf, stderrBuf bytes.Buffer
+	stdout, stderr := opt.stdout, opt.stderr
+	if stdout == nil {
+		stdout = &stdoutBuf
+	}
+	if stderr == nil {
+		stderr = &stderrBuf
+	}
+
+	var (
+		stdin   io.WriteCloser
+		filter  *sudoFilter
+		sudoErr error
+	)
+	if opt.sudo || opt.stdin != nil {
+		var pipeErr error
+		stdin, pipeErr = session.StdinPipe()
+		if pipeErr != nil {
+			return nil, pipeErr
+		}
+	}
+	copyStdin := func() {
+		if opt.stdin != nil {
+			io.Copy(stdin, opt.stdin)
+		}
+		stdin.Close()
+	}
+
+	if opt.sudo {
+		prompts := 0
+		filter = &sudoFilter{
+			prompt: randomMarker("sudo-prompt-"),
+			ready:  randomMarker("sudo-ready-"),
+			onPrompt: func() error {
+				prompts++
+				if prompts > 1 || opt.sudoPasswd == "" {
+					sudoErr = ErrSudoPassword
+					session.Close()
+					return sudoErr
+				}
+				_, err := io.WriteString(stdin, opt.sudoPasswd+"\n")
+				return err
+			},
+			onReady: func() {
+				go copyStdin()
+			},
+		}
+		// 使用伪终端时 sudo 的输出都在 stdout 中
+		if opt.pty {
+			filter.w, stdout = stdout, filter
+		} else {
+			filter.w, stderr = stderr, filter
+		}
+	}
+	session.Stdout = stdout
+	session.Stderr = stderr
+
+	var prompt, ready string
+	if filter != nil {
+		prompt, ready = filter.prompt, filter.ready
+	}
+	script := opt.script(cmd, exports, prompt, ready)
+	if err := session.Start(script); err != nil {
+		return nil, err
+	}
+	if stdin != nil && !opt.sudo {
+		go copyStdin()
+	}
+
+	waitErr := waitContext(ctx, session, cmd, c.killGrace)
+	if filter != nil {
+		filter.Flush()
+	}
+	result.Stdout = stdoutBuf.Bytes()
+	result.Stderr = stderrBuf.Bytes()
+	if sudoErr != nil {
+		return result, sudoErr
+	}
+
+	var runErr *RunError
+	switch {
+	case waitErr == nil:
+	case errors.As(waitErr, &runErr):
+		result.ExitCode = runErr.ExitStatus
+		result.Signal = runErr.Signal
+		if runErr.Canceled || (runErr.ExitStatus < 0 && runErr.Signal == "") {
+			return result, waitErr
+		}
+	default:
+		return result, waitErr
+	}
+	return result, nil
+}
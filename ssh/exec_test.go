@@ -0,0 +1,77 @@
+package ssh
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestCommand_script(t *testing.T) {
+	c := &command{dir: "/tmp/it's"}
+	got := c.script("ls", []envVar{{key: "LANG", value: "C"}}, "", "")
+	want := `export LANG='C'; cd '/tmp/it'\''s' && ls`
+	if got != want {
+		t.Errorf("script = %s, want %s", got, want)
+	}
+
+	c = &command{sudo: true, sudoUser: "app"}
+	got = c.script("id", nil, "P", "R")
+	want = `sudo -S -p 'P' -u 'app' -- sh -c 'echo R >&2; id'`
+	if got != want {
+		t.Errorf("script = %s, want %s", got, want)
+	}
+}
+
+func TestClientType_ExecEnv(t *testing.T) {
+	cli := newTestClient(t, newTestServer(t))
+	result, err := cli.Exec(context.Background(), "echo $GREETING", WithEnv("GREETING", "it's; ok"))
+	if err != nil || string(result.Stdout) != "it's; ok\n" {
+		t.Fatalf("Exec = %+v, %v", result, err)
+	}
+
+	pwned := filepath.Join(t.TempDir(), "pwned")
+	for _, key := range []string{"X=1; touch " + pwned + "; Y", "1ABC", "", "A-B"} {
+		if _, err := cli.Exec(context.Background(), "true", WithEnv(key, "v"), WithSudo("")); err == nil || !strings.Contains(err.Error(), "invalid environment variable") {
+			t.Errorf("WithEnv(%q): want invalid name error, got %v", key, err)
+		}
+	}
+	if _, err := os.Stat(pwned); !os.IsNotExist(err) {
+		t.Error("environment variable name was executed by the shell")
+	}
+}
+
+func TestSudoFilter(t *testing.T) {
+	var (
+		out     bytes.Buffer
+		prompts int
+		ready   bool
+	)
+	f := &sudoFilter{
+		w:      &out,
+		prompt: "sudo-prompt-1234",
+		ready:  "sudo-ready-5678",
+		onPrompt: func() error {
+			prompts++
+			return nil
+		},
+		onReady: func() {
+			ready = true
+		},
+	}
+	// 标记被拆分到多次写入中
+	for _, chunk := range []string{"hello sudo-pro", "mpt-1234", "sudo-rea", "dy-5678\nworld", " done"} {
+		if _, err := f.Write([]byte(chunk)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	f.Flush()
+	if prompts != 1 || !ready {
+		t.Errorf("prompts = %d, ready = %v", prompts, ready)
+	}
+	if out.String() != "hello world done" {
+		t.Errorf("output = %q", out.String())
+	}
+}
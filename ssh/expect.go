@@ -0,0 +1,272 @@
+package ssh
+
+import (
+	"errors"
+	"fmt"
+	"golang.org/x/crypto/ssh"
+	"io"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultExpectTimeout 默认等待匹配的时间
+const DefaultExpectTimeout = 30 * time.Second
+
+// expectMaxBuffer 未匹配的输出最多保留的字节数，超过时丢弃最早的部分
+const expectMaxBuffer = 1 << 20
+
+// ErrExpectTimeout 等待超时，没有任何 pattern 匹配
+var ErrExpectTimeout = errors.New("expect timeout")
+
+// Match 一次匹配的结果
+type Match struct {
+	// Index 匹配的是第几个 pattern
+	Index int
+	// Groups Groups[0] 为匹配的文本，后面依次为各个分组
+	Groups []string
+	// Before 匹配之前还没有被读取的输出
+	Before string
+}
+
+type expectConfig struct {
+	timeout time.Duration
+	log     io.Writer
+	term    string
+	height  int
+	width   int
+}
+
+type ExpectOption func(*expectConfig)
+
+// WithExpectTimeout Expect 默认的等待时间
+func WithExpectTimeout(timeout time.Duration) ExpectOption {
+	return func(c *expectConfig) {
+		c.timeout = timeout
+	}
+}
+
+// WithExpectLog 把终端的全部输出写入 w 作为交互记录，终端回显的输入也在其中
+func WithExpectLog(w io.Writer) ExpectOption {
+	return func(c *expectConfig) {
+		c.log = w
+	}
+}
+
+// WithExpectPty 伪终端的类型和大小，默认为 xterm 24x80
+func WithExpectPty(term string, height, width int) ExpectOption {
+	return func(c *expectConfig) {
+		c.term = term
+		c.height = height
+		c.width = width
+	}
+}
+
+// ExpectSession 伪终端上的脚本化交互：Send 发送输入，Expect 等待输出匹配正则，可以并发调用 Send 和 Expect
+type ExpectSession struct {
+	w       io.Writer
+	timeout time.Duration
+	log     io.Writer
+
+	mu  sync.Mutex
+	buf []byte
+	// changed 有新输出或输出结束时关闭并替换
+	changed chan struct{}
+	eof     bool
+	readErr error
+
+	wait      func() error
+	close     func() error
+	closeOnce sync.Once
+	closeErr  error
+}
+
+func newExpectSession(r io.Reader, w io.Writer, opt *expectConfig) *ExpectSession {
+	e := &ExpectSession{
+		w:       w,
+		timeout: opt.timeout,
+		log:     opt.log,
+		changed: make(chan struct{}),
+		wait:    func() error { return nil },
+		close:   func() error { return nil },
+	}
+	go e.read(r)
+	return e
+}
+
+func (e *ExpectSession) read(r io.Reader) {
+	buf := make([]byte, 32*1024)
+	for {
+		n, err := r.Read(buf)
+		e.mu.Lock()
+		if n > 0 {
+			if e.log != nil {
+				e.log.Write(buf[:n])
+			}
+			e.buf = append(e.buf, buf[:n]...)
+			if over := len(e.buf) - expectMaxBuffer; over > 0 {
+				e.buf = append(e.buf[:0], e.buf[over:]...)
+			}
+		}
+		if err != nil {
+			e.eof = true
+			if err != io.EOF {
+				e.readErr = err
+			}
+		}
+		close(e.changed)
+		e.changed = make(chan struct{})
+		e.mu.Unlock()
+		if err != nil {
+			return
+		}
+	}
+}
+
+// Send 发送输入，不追加换行
+func (e *ExpectSession) Send(s string) error {
+	_, err := io.WriteString(e.w, s)
+	return err
+}
+
+// SendLine 发送一行输入
+func (e *ExpectSession) SendLine(s string) error {
+	return e.Send(s + "\n")
+}
+
+// Expect 使用默认的超时时间等待任意一个 pattern 匹配，见 ExpectTimeout
+func (e *ExpectSession) Expect(patterns ...*regexp.Regexp) (*Match, error) {
+	return e.ExpectTimeout(e.timeout, patterns...)
+}
+
+// ExpectTimeout 等待任意一个 pattern 匹配，多个 pattern 都匹配时取在输出中最早出现的。
+// 匹配成功后丢弃到匹配结尾为止的输出；超时返回 ErrExpectTimeout，输出结束仍未匹配返回 io.EOF
+func (e *ExpectSession) ExpectTimeout(timeout time.Duration, patterns ...*regexp.Regexp) (*Match, error) {
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	for {
+		e.mu.Lock()
+		match := e.match(patterns)
+		eof, readErr, changed, tail := e.eof, e.readErr, e.changed, e.tail()
+		e.mu.Unlock()
+		if match != nil {
+			return match, nil
+		}
+		if eof {
+			if readErr != nil {
+				return nil, readErr
+			}
+			return nil, fmt.Errorf("expect %s: %w, output: %q", patternList(patterns), io.EOF, tail)
+		}
+		select {
+		case <-changed:
+		case <-timer.C:
+			return nil, fmt.Errorf("expect %s: %w, output: %q", patternList(patterns), ErrExpectTimeout, tail)
+		}
+	}
+}
+
+// match 调用方持有 mu
+func (e *ExpectSession) match(patterns []*regexp.Regexp) *Match {
+	var best *Match
+	bestStart, bestEnd := -1, -1
+	for i, pattern := range patterns {
+		loc := pattern.FindSubmatchIndex(e.buf)
+		if loc == nil || (bestStart >= 0 && loc[0] >= bestStart) {
+			continue
+		}
+		groups := make([]string, len(loc)/2)
+		for g := range groups {
+			if loc[2*g] >= 0 {
+				groups[g] = string(e.buf[loc[2*g]:loc[2*g+1]])
+			}
+		}
+		best = &Match{Index: i, Groups: groups, Before: string(e.buf[:loc[0]])}
+		bestStart, bestEnd = loc[0], loc[1]
+	}
+	if best != nil {
+		e.buf = append(e.buf[:0], e.buf[bestEnd:]...)
+	}
+	return best
+}
+
+// tail 错误信息中附带的最后一部分输出
+func (e *ExpectSession) tail() string {
+	const max = 256
+	if len(e.buf) > max {
+		return string(e.buf[len(e.buf)-max:])
+	}
+	return string(e.buf)
+}
+
+func patternList(patterns []*regexp.Regexp) string {
+	list := make([]string, 0, len(patterns))
+	for _, p := range patterns {
+		list = append(list, fmt.Sprintf("%q", p.String()))
+	}
+	return "[" + strings.Join(list, ", ") + "]"
+}
+
+// Wait 等待远程命令结束，失败时返回 *RunError
+func (e *ExpectSession) Wait() error {
+	return e.wait()
+}
+
+// Close 关闭 session，远程命令还没有结束时会被终止
+func (e *ExpectSession) Close() error {
+	e.closeOnce.Do(func() {
+		e.closeErr = e.close()
+	})
+	return e.closeErr
+}
+
+// Expect 在伪终端上执行 cmd 用于脚本化交互，cmd 为空时启动登录 shell。使用完需要 Close
+func (c *ClientType) Expect(cmd string, option ...ExpectOption) (*ExpectSession, error) {
+	opt := &expectConfig{timeout: DefaultExpectTimeout, term: "xterm", height: 24, width: 80}
+	for _, o := range option {
+		o(opt)
+	}
+	session, err := c.newSession()
+	if err != nil {
+		return nil, err
+	}
+	if err := session.RequestPty(opt.term, opt.height, opt.width, ssh.TerminalModes{}); err != nil {
+		c.closeSession(session)
+		return nil, fmt.Errorf("session.RequestPty error: %w", err)
+	}
+	stdin, err := session.StdinPipe()
+	if err != nil {
+		c.closeSession(session)
+		return nil, err
+	}
+	stdout, err := session.StdoutPipe()
+	if err != nil {
+		c.closeSession(session)
+		return nil, err
+	}
+	if cmd == "" {
+		err = session.Shell()
+	} else {
+		err = session.Start(cmd)
+	}
+	if err != nil {
+		c.closeSession(session)
+		return nil, err
+	}
+
+	e := newExpectSession(stdout, stdin, opt)
+	var waitOnce sync.Once
+	var waitErr error
+	e.wait = func() error {
+		waitOnce.Do(func() {
+			waitErr = newRunError(cmd, session.Wait(), nil, "", false)
+		})
+		return waitErr
+	}
+	e.close = func() error {
+		stdin.Close()
+		return c.closeSession(session)
+	}
+	return e, nil
+}
@@ -0,0 +1,106 @@
+package ssh
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"io"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+)
+
+// fakeDevice 模拟需要登录的交互式设备
+func fakeDevice(in io.Reader, out io.WriteCloser) {
+	defer out.Close()
+	scanner := bufio.NewScanner(in)
+	io.WriteString(out, "Username: ")
+	if !scanner.Scan() {
+		return
+	}
+	io.WriteString(out, "Password: ")
+	if !scanner.Scan() || scanner.Text() != "secret" {
+		io.WriteString(out, "\r\n% Login invalid\r\n")
+		return
+	}
+	io.WriteString(out, "\r\nrouter1#")
+	for scanner.Scan() {
+		switch scanner.Text() {
+		case "show version":
+			io.WriteString(out, "\r\nCisco IOS Software, Version 15.2(4)M7\r\nrouter1#")
+		case "exit":
+			return
+		}
+	}
+}
+
+func newFakeExpect(t *testing.T, option ...ExpectOption) *ExpectSession {
+	inR, inW := io.Pipe()
+	outR, outW := io.Pipe()
+	go fakeDevice(inR, outW)
+	opt := &expectConfig{timeout: time.Second}
+	for _, o := range option {
+		o(opt)
+	}
+	e := newExpectSession(outR, inW, opt)
+	t.Cleanup(func() { inW.Close() })
+	return e
+}
+
+func TestExpectSession(t *testing.T) {
+	var transcript bytes.Buffer
+	e := newFakeExpect(t, WithExpectLog(&transcript))
+	prompt := regexp.MustCompile(`(\w+)#$`)
+
+	if _, err := e.Expect(regexp.MustCompile(`Username: $`)); err != nil {
+		t.Fatal(err)
+	}
+	e.SendLine("admin")
+	if _, err := e.Expect(regexp.MustCompile(`Password: $`)); err != nil {
+		t.Fatal(err)
+	}
+	e.SendLine("secret")
+	m, err := e.Expect(regexp.MustCompile(`Login invalid`), prompt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.Index != 1 || m.Groups[1] != "router1" {
+		t.Errorf("match = %+v", m)
+	}
+
+	e.SendLine("show version")
+	m, err = e.Expect(regexp.MustCompile(`Version ([\d.()A-Z]+)`))
+	if err != nil || m.Groups[1] != "15.2(4)M7" || !strings.Contains(m.Before, "Cisco IOS") {
+		t.Fatalf("match = %+v, %v", m, err)
+	}
+	// 匹配结尾之后的提示符还在缓冲区中
+	if _, err := e.Expect(prompt); err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = e.ExpectTimeout(20*time.Millisecond, regexp.MustCompile(`never`))
+	if !errors.Is(err, ErrExpectTimeout) {
+		t.Errorf("err = %v", err)
+	}
+
+	e.SendLine("exit")
+	if _, err := e.Expect(regexp.MustCompile(`never`)); !errors.Is(err, io.EOF) {
+		t.Errorf("err = %v", err)
+	}
+	if !strings.Contains(transcript.String(), "Username: Password: \r\nrouter1#") {
+		t.Errorf("transcript = %q", transcript.String())
+	}
+}
+
+func TestExpectSession_Earliest(t *testing.T) {
+	e := newExpectSession(strings.NewReader("b then a"), io.Discard, &expectConfig{timeout: time.Second})
+	m, err := e.Expect(regexp.MustCompile("a"), regexp.MustCompile("b"))
+	if err != nil || m.Index != 1 {
+		t.Fatalf("match = %+v, %v", m, err)
+	}
+	m, err = e.Expect(regexp.MustCompile("a"), regexp.MustCompile("b"))
+	if err != nil || m.Index != 0 || m.Before != " then " {
+		t.Fatalf("match = %+v, %v", m, err)
+	}
+}
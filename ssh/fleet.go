@@ -0,0 +1,261 @@
+package ssh
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
+)
+
+// DefaultFleetWorkers Fleet 默认同时操作的主机数量
+const DefaultFleetWorkers = 10
+
+// Host 主机清单中的一台主机
+type Host struct {
+	// Name 输出前缀和结果中使用的名字，为空时使用 Address
+	Name string
+	AuthConfig
+}
+
+func (h *Host) name() string {
+	if h.Name != "" {
+		return h.Name
+	}
+	return h.Address
+}
+
+// Fleet 在多台主机上并发执行命令或上传文件
+type Fleet struct {
+	hosts   []Host
+	workers int
+	stdout  io.Writer
+	stderr  io.Writer
+	options []Option
+}
+
+type FleetOption func(*Fleet)
+
+// NewFleet 使用主机清单创建 Fleet
+func NewFleet(hosts []Host, option ...FleetOption) *Fleet {
+	f := &Fleet{hosts: hosts, workers: DefaultFleetWorkers}
+	for _, opt := range option {
+		opt(f)
+	}
+	return f
+}
+
+// WithFleetWorkers 同时操作的主机数量
+func WithFleetWorkers(n int) FleetOption {
+	return func(f *Fleet) {
+		if n > 0 {
+			f.workers = n
+		}
+	}
+}
+
+// WithFleetOutput 实时输出每台主机的 stdout/stderr，每行以 "主机名 | " 开头
+func WithFleetOutput(stdout, stderr io.Writer) FleetOption {
+	return func(f *Fleet) {
+		f.stdout = stdout
+		f.stderr = stderr
+	}
+}
+
+// WithFleetClientOptions 连接每台主机时使用的 Option
+func WithFleetClientOptions(option ...Option) FleetOption {
+	return func(f *Fleet) {
+		f.options = append(f.options, option...)
+	}
+}
+
+// HostResult 单台主机的执行结果
+type HostResult struct {
+	Host     string
+	Result   *Result
+	Err      error
+	Duration time.Duration
+	index    int
+}
+
+// Success 连接、执行都成功且退出码为 0
+func (r *HostResult) Success() bool {
+	return r.Err == nil && (r.Result == nil || r.Result.Success())
+}
+
+// FleetResult 按主机清单顺序排列的结果
+type FleetResult []HostResult
+
+// Failed 返回失败的主机
+func (r FleetResult) Failed() FleetResult {
+	ret := make(FleetResult, 0)
+	for _, h := range r {
+		if !h.Success() {
+			ret = append(ret, h)
+		}
+	}
+	return ret
+}
+
+// Err 汇总所有失败主机的错误，全部成功时返回 nil
+func (r FleetResult) Err() error {
+	failed := r.Failed()
+	if len(failed) == 0 {
+		return nil
+	}
+	msg := make([]string, 0, len(failed))
+	for _, h := range failed {
+		err := h.Err
+		if err == nil {
+			err = fmt.Errorf("exit status %d", h.Result.ExitCode)
+		}
+		msg = append(msg, fmt.Sprintf("%s: %v", h.Host, err))
+	}
+	return fmt.Errorf("%d/%d hosts failed:\n%s", len(failed), len(r), strings.Join(msg, "\n"))
+}
+
+// Table 以表格形式输出每台主机的结果
+func (r FleetResult) Table(w io.Writer) error {
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "HOST\tSTATUS\tEXIT\tDURATION\tERROR")
+	for _, h := range r {
+		status, exit, errMsg := "ok", "-", ""
+		if h.Result != nil {
+			exit = fmt.Sprint(h.Result.ExitCode)
+		}
+		if !h.Success() {
+			status = "failed"
+		}
+		if h.Err != nil {
+			errMsg = strings.ReplaceAll(h.Err.Error(), "\n", " ")
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\n", h.Host, status, exit, h.Duration.Round(time.Millisecond), errMsg)
+	}
+	return tw.Flush()
+}
+
+// prefixWriter 按行输出并在每行前加上主机名，多个主机共用一把锁避免输出交错
+type prefixWriter struct {
+	mu     *sync.Mutex
+	w      io.Writer
+	prefix string
+	buf    []byte
+}
+
+func (p *prefixWriter) Write(b []byte) (int, error) {
+	p.buf = append(p.buf, b...)
+	for {
+		i := bytes.IndexByte(p.buf, '\n')
+		if i < 0 {
+			break
+		}
+		if err := p.writeLine(p.buf[:i+1]); err != nil {
+			return 0, err
+		}
+		p.buf = p.buf[i+1:]
+	}
+	return len(b), nil
+}
+
+func (p *prefixWriter) writeLine(line []byte) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	_, err := fmt.Fprintf(p.w, "%s | %s", p.prefix, line)
+	return err
+}
+
+// Flush 输出最后一行不以换行结尾的内容
+func (p *prefixWriter) Flush() error {
+	if len(p.buf) == 0 {
+		return nil
+	}
+	err := p.writeLine(append(p.buf, '\n'))
+	p.buf = nil
+	return err
+}
+
+// Each 在每台主机上建立连接并调用 fn，某台主机失败不会影响其他主机。
+// ctx 结束后尚未开始的主机直接返回 ctx.Err()
+func (f *Fleet) Each(ctx context.Context, fn func(ctx context.Context, host *Host, cli Client) (*Result, error)) FleetResult {
+	results := runGroup(len(f.hosts), f.workers, func(i int) HostResult {
+		host := &f.hosts[i]
+		start := time.Now()
+		result, runErr := f.each(ctx, host, fn)
+		return HostResult{
+			Host:     host.name(),
+			Result:   result,
+			Err:      runErr,
+			Duration: time.Since(start),
+			index:    i,
+		}
+	}, func(i int, err error) HostResult {
+		return HostResult{Host: f.hosts[i].name(), Err: err, index: i}
+	})
+
+	sort.Slice(results, func(a, b int) bool {
+		return results[a].index < results[b].index
+	})
+	return results
+}
+
+func (f *Fleet) each(ctx context.Context, host *Host, fn func(ctx context.Context, host *Host, cli Client) (*Result, error)) (*Result, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	auth := host.AuthConfig
+	cli, err := NewClient(&auth, f.options...)
+	if err != nil {
+		return nil, err
+	}
+	defer cli.Close()
+	return fn(ctx, host, cli)
+}
+
+// Run 在所有主机上执行命令，设置了 WithFleetOutput 时实时输出带主机名前缀的结果，
+// 否则输出保存在每台主机的 Result 中
+func (f *Fleet) Run(ctx context.Context, cmd string, option ...CommandOption) FleetResult {
+	var stdoutMu, stderrMu sync.Mutex
+	if f.stdout != nil && f.stderr != nil && f.stdout == f.stderr {
+		// 同一个 writer 共用一把锁
+		return f.run(ctx, cmd, &stdoutMu, &stdoutMu, option)
+	}
+	return f.run(ctx, cmd, &stdoutMu, &stderrMu, option)
+}
+
+func (f *Fleet) run(ctx context.Context, cmd string, stdoutMu, stderrMu *sync.Mutex, option []CommandOption) FleetResult {
+	return f.Each(ctx, func(ctx context.Context, host *Host, cli Client) (*Result, error) {
+		opts := append([]CommandOption{}, option...)
+		var writers []*prefixWriter
+		if f.stdout != nil {
+			w := &prefixWriter{mu: stdoutMu, w: f.stdout, prefix: host.name()}
+			writers = append(writers, w)
+			opts = append(opts, WithStdout(w))
+		}
+		if f.stderr != nil {
+			w := &prefixWriter{mu: stderrMu, w: f.stderr, prefix: host.name()}
+			writers = append(writers, w)
+			opts = append(opts, WithStderr(w))
+		}
+		defer func() {
+			for _, w := range writers {
+				w.Flush()
+			}
+		}()
+		return cli.Exec(ctx, cmd, opts...)
+	})
+}
+
+// Push 把本地文件或目录上传到所有主机，ctx 结束后不再开始新的主机和文件
+func (f *Fleet) Push(ctx context.Context, src, dst string, option ...TransferOption) FleetResult {
+	return f.Each(ctx, func(ctx context.Context, host *Host, cli Client) (*Result, error) {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		opts := append([]TransferOption{}, option...)
+		return nil, cli.Push(src, dst, append(opts, WithTransferContext(ctx))...)
+	})
+}
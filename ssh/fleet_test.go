@@ -0,0 +1,103 @@
+package ssh
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestPrefixWriter(t *testing.T) {
+	var (
+		out bytes.Buffer
+		mu  sync.Mutex
+	)
+	w := &prefixWriter{mu: &mu, w: &out, prefix: "web-1"}
+	w.Write([]byte("hello\nwor"))
+	w.Write([]byte("ld\nlast"))
+	w.Flush()
+	want := "web-1 | hello\nweb-1 | world\nweb-1 | last\n"
+	if out.String() != want {
+		t.Errorf("output = %q, want %q", out.String(), want)
+	}
+}
+
+func TestFleet_collectFailures(t *testing.T) {
+	hosts := make([]Host, 0)
+	for _, name := range []string{"a", "b", "c", "d"} {
+		hosts = append(hosts, Host{
+			Name: name,
+			AuthConfig: AuthConfig{
+				Username: "root",
+				Password: "secret",
+				NetworkConfig: NetworkConfig{
+					Network:        "tcp",
+					Address:        "127.0.0.1:1",
+					ConnectTimeout: 1,
+				},
+			},
+		})
+	}
+	results := NewFleet(hosts, WithFleetWorkers(2)).Run(context.Background(), "hostname")
+	if len(results) != len(hosts) {
+		t.Fatalf("want %d results, got %d", len(hosts), len(results))
+	}
+	for i, r := range results {
+		if r.Host != hosts[i].Name {
+			t.Errorf("result %d is %s, want %s", i, r.Host, hosts[i].Name)
+		}
+		if r.Err == nil {
+			t.Errorf("%s should fail to connect", r.Host)
+		}
+	}
+	if err := results.Err(); err == nil || !strings.Contains(err.Error(), "4/4 hosts failed") {
+		t.Errorf("unexpected aggregated error: %v", err)
+	}
+
+	var table bytes.Buffer
+	results.Table(&table)
+	if lines := strings.Count(table.String(), "\n"); lines != len(hosts)+1 {
+		t.Errorf("table has %d lines:\n%s", lines, table.String())
+	}
+}
+
+func TestFleet_Push(t *testing.T) {
+	hosts := make([]Host, 0)
+	for _, name := range []string{"a", "b"} {
+		hosts = append(hosts, Host{Name: name, AuthConfig: *newTestServer(t)})
+	}
+	fleet := NewFleet(hosts, WithFleetWorkers(2))
+	src, dir := filepath.Join(t.TempDir(), "a.txt"), t.TempDir()
+	if err := os.WriteFile(src, []byte("hello"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	// 本地文件不存在时每台主机返回错误而不是 panic
+	for _, r := range fleet.Push(context.Background(), src+".missing", filepath.Join(dir, "x.txt")) {
+		if !errors.Is(r.Err, os.ErrNotExist) {
+			t.Errorf("%s: want not exist error, got %v", r.Host, r.Err)
+		}
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	for _, r := range fleet.Push(ctx, src, filepath.Join(dir, "cancelled.txt")) {
+		if !errors.Is(r.Err, context.Canceled) {
+			t.Errorf("%s: want context canceled, got %v", r.Host, r.Err)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(dir, "cancelled.txt")); !os.IsNotExist(err) {
+		t.Errorf("cancelled push should not write: %v", err)
+	}
+
+	if err := fleet.Push(context.Background(), src, filepath.Join(dir, "b.txt")).Err(); err != nil {
+		t.Fatal(err)
+	}
+	if b, err := os.ReadFile(filepath.Join(dir, "b.txt")); err != nil || string(b) != "hello" {
+		t.Errorf("pushed file: %q, %v", b, err)
+	}
+}
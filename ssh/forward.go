@@ -0,0 +1,183 @@
+package ssh
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"time"
+)
+
+// RemoteForward 远程端口转发（ssh -R），在远程服务器上监听 Remote，把连接转发到本地的 Local。
+// 配置了 WithReconnect 时，断线重连后会在新连接上重新监听
+func (c *ClientType) RemoteForward(Remote, Local NetworkConfig, option ...TunnelOption) (*Tunnel, error) {
+	current := c.conn()
+	listener, err := current.Listen(Remote.Network, Remote.Address)
+	if err != nil {
+		return nil, fmt.Errorf("remote listen %s error: %w", Remote.Address, err)
+	}
+
+	relisten := func() (net.Listener, error) {
+		for c.reconnect.enabled && !c.isClosed() {
+			if cli := c.conn(); cli != current && c.State() == StateConnected {
+				current = cli
+				return cli.Listen(Remote.Network, Remote.Address)
+			}
+			select {
+			case <-c.failed:
+				return nil, fmt.Errorf("remote listener closed: %w", c.failure())
+			case <-c.closed:
+			case <-time.After(time.Second):
+			}
+		}
+		return nil, errors.New("remote listener closed")
+	}
+	dial := func(net.Conn) (net.Conn, string, error) {
+		localConn, dialErr := net.DialTimeout(Local.Network, Local.Address, connectTimeout(Local))
+		if dialErr != nil {
+			return nil, Local.Address, fmt.Errorf("dial %s error: %w", Local.Address, dialErr)
+		}
+		return localConn, Local.Address, nil
+	}
+
+	t := newTunnel(context.Background(), listener, relisten, dial, option...)
+	c.addTunnel(t)
+	return t, nil
+}
+
+// DynamicForward 动态端口转发（ssh -D），在本地 Local 启动 SOCKS5 代理，所有连接都通过 ssh 连接发出
+func (c *ClientType) DynamicForward(Local NetworkConfig, option ...TunnelOption) (*Tunnel, error) {
+	listener, err := net.Listen(Local.Network, Local.Address)
+	if err != nil {
+		return nil, err
+	}
+	t := newTunnel(context.Background(), listener, nil, func(conn net.Conn) (net.Conn, string, error) {
+		target, handshakeErr := socks5Handshake(conn)
+		if handshakeErr != nil {
+			return nil, "", fmt.Errorf("socks5 handshake error: %w", handshakeErr)
+		}
+		remoteConn, dialErr := c.conn().Dial("tcp", target)
+		if dialErr != nil {
+			socks5Reply(conn, socks5HostUnreachable)
+			return nil, target, fmt.Errorf("dial %s error: %w", target, dialErr)
+		}
+		if replyErr := socks5Reply(conn, socks5Succeeded); replyErr != nil {
+			remoteConn.Close()
+			return nil, target, replyErr
+		}
+		return remoteConn, target, nil
+	}, option...)
+	c.addTunnel(t)
+	return t, nil
+}
+
+func connectTimeout(conf NetworkConfig) time.Duration {
+	if conf.ConnectTimeout > 0 {
+		return time.Duration(conf.ConnectTimeout) * time.Second
+	}
+	return 30 * time.Second
+}
+
+// SOCKS5 (RFC 1928)
+const (
+	socks5Version = 0x05
+
+	socks5NoAuth       = 0x00
+	socks5NoAcceptable = 0xff
+
+	socks5Connect = 0x01
+
+	socks5IPv4   = 0x01
+	socks5Domain = 0x03
+	socks5IPv6   = 0x04
+
+	socks5Succeeded            = 0x00
+	socks5HostUnreachable      = 0x04
+	socks5CommandNotSupported  = 0x07
+	socks5AddrTypeNotSupported = 0x08
+)
+
+const socks5HandshakeTimeout = 10 * time.Second
+
+// socks5Handshake 完成方法协商并读取 CONNECT 请求，返回目标地址 host:port。
+// 只支持无认证和 CONNECT 命令
+func socks5Handshake(conn net.Conn) (string, error) {
+	conn.SetDeadline(time.Now().Add(socks5HandshakeTimeout))
+	defer conn.SetDeadline(time.Time{})
+
+	header := make([]byte, 2)
+	if _, err := io.ReadFull(conn, header); err != nil {
+		return "", err
+	}
+	if header[0] != socks5Version {
+		return "", fmt.Errorf("socks: unsupported version %d", header[0])
+	}
+	methods := make([]byte, header[1])
+	if _, err := io.ReadFull(conn, methods); err != nil {
+		return "", err
+	}
+	accepted := false
+	for _, m := range methods {
+		if m == socks5NoAuth {
+			accepted = true
+		}
+	}
+	if !accepted {
+		conn.Write([]byte{socks5Version, socks5NoAcceptable})
+		return "", errors.New("socks: no acceptable auth method")
+	}
+	if _, err := conn.Write([]byte{socks5Version, socks5NoAuth}); err != nil {
+		return "", err
+	}
+
+	request := make([]byte, 4)
+	if _, err := io.ReadFull(conn, request); err != nil {
+		return "", err
+	}
+	if request[1] != socks5Connect {
+		socks5Reply(conn, socks5CommandNotSupported)
+		return "", fmt.Errorf("socks: unsupported command %d", request[1])
+	}
+
+	var host string
+	switch request[3] {
+	case socks5IPv4, socks5IPv6:
+		size := net.IPv4len
+		if request[3] == socks5IPv6 {
+			size = net.IPv6len
+		}
+		ip := make([]byte, size)
+		if _, err := io.ReadFull(conn, ip); err != nil {
+			return "", err
+		}
+		host = net.IP(ip).String()
+	case socks5Domain:
+		length := make([]byte, 1)
+		if _, err := io.ReadFull(conn, length); err != nil {
+			return "", err
+		}
+		domain := make([]byte, length[0])
+		if _, err := io.ReadFull(conn, domain); err != nil {
+			return "", err
+		}
+		host = string(domain)
+	default:
+		socks5Reply(conn, socks5AddrTypeNotSupported)
+		return "", fmt.Errorf("socks: unsupported address type %d", request[3])
+	}
+
+	port := make([]byte, 2)
+	if _, err := io.ReadFull(conn, port); err != nil {
+		return "", err
+	}
+	return net.JoinHostPort(host, strconv.Itoa(int(binary.BigEndian.Uint16(port)))), nil
+}
+
+// socks5Reply 绑定地址固定返回 0.0.0.0:0
+func socks5Reply(conn net.Conn, code byte) error {
+	_, err := conn.Write([]byte{socks5Version, code, 0x00, socks5IPv4, 0, 0, 0, 0, 0, 0})
+	return err
+}
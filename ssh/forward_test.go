@@ -0,0 +1,251 @@
+package ssh
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"github.com/Lvzhenqian/library/ssh/sshtest"
+	"golang.org/x/crypto/ssh"
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSocks5Handshake(t *testing.T) {
+	client, server := net.Pipe()
+	defer client.Close()
+	defer server.Close()
+
+	result := make(chan string, 1)
+	go func() {
+		target, err := socks5Handshake(server)
+		if err != nil {
+			t.Error(err)
+		}
+		result <- target
+	}()
+
+	client.Write([]byte{socks5Version, 1, socks5NoAuth})
+	reply := make([]byte, 2)
+	io.ReadFull(client, reply)
+	if !bytes.Equal(reply, []byte{socks5Version, socks5NoAuth}) {
+		t.Fatalf("method reply = %v", reply)
+	}
+	domain := "example.com"
+	request := append([]byte{socks5Version, socks5Connect, 0x00, socks5Domain, byte(len(domain))}, domain...)
+	client.Write(append(request, 0x01, 0xbb))
+
+	if target := <-result; target != "example.com:443" {
+		t.Errorf("target = %s", target)
+	}
+}
+
+// echoServer 把收到的数据原样返回，读到 EOF 后关闭连接
+func echoServer(t *testing.T) string {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { listener.Close() })
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				io.Copy(conn, conn)
+				conn.Close()
+			}()
+		}
+	}()
+	return listener.Addr().String()
+}
+
+func newEchoTunnel(t *testing.T, ctx context.Context, option ...TunnelOption) *Tunnel {
+	target := echoServer(t)
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return newTunnel(ctx, listener, nil, func(net.Conn) (net.Conn, string, error) {
+		conn, err := net.Dial("tcp", target)
+		return conn, target, err
+	}, option...)
+}
+
+func TestTunnel_Close(t *testing.T) {
+	tunnel := newEchoTunnel(t, context.Background())
+
+	conn, err := net.Dial("tcp", tunnel.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.Write([]byte("ping"))
+	buf := make([]byte, 4)
+	if _, err := io.ReadFull(conn, buf); err != nil || string(buf) != "ping" {
+		t.Fatalf("echo = %q, %v", buf, err)
+	}
+	if tunnel.Active() != 1 {
+		t.Errorf("Active() = %d", tunnel.Active())
+	}
+
+	tunnel.Close()
+	if err := tunnel.Wait(); err != nil {
+		t.Errorf("Wait() = %v", err)
+	}
+	if tunnel.Active() != 0 {
+		t.Errorf("Active() after close = %d", tunnel.Active())
+	}
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	if _, err := conn.Read(buf); err == nil {
+		t.Error("forwarded connection should be closed")
+	}
+}
+
+func TestTunnel_HalfClose(t *testing.T) {
+	tunnel := newEchoTunnel(t, context.Background())
+	defer tunnel.Close()
+
+	conn, err := net.Dial("tcp", tunnel.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	payload := bytes.Repeat([]byte("x"), 1<<20)
+	go func() {
+		conn.Write(payload)
+		conn.(*net.TCPConn).CloseWrite()
+	}()
+	// 关闭写方向后仍然能收到全部回复
+	got, err := io.ReadAll(conn)
+	if err != nil || len(got) != len(payload) {
+		t.Fatalf("read %d bytes, %v", len(got), err)
+	}
+
+	var closed TunnelEvent
+	for event := range tunnel.Events() {
+		if event.Type == ConnClosed {
+			closed = event
+			break
+		}
+	}
+	if closed.Conn.Sent != int64(len(payload)) || closed.Conn.Received != int64(len(payload)) {
+		t.Errorf("stats = %+v", closed.Conn)
+	}
+	if sent, received := tunnel.Bytes(); sent != int64(len(payload)) || received != int64(len(payload)) {
+		t.Errorf("Bytes() = %d, %d", sent, received)
+	}
+}
+
+func TestTunnel_MaxConns(t *testing.T) {
+	tunnel := newEchoTunnel(t, context.Background(), WithMaxConns(1))
+	defer tunnel.Close()
+
+	first, err := net.Dial("tcp", tunnel.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer first.Close()
+	first.Write([]byte("a"))
+	buf := make([]byte, 1)
+	if _, err := io.ReadFull(first, buf); err != nil {
+		t.Fatal(err)
+	}
+
+	// 第二个连接在第一个结束前不会被转发
+	second, err := net.Dial("tcp", tunnel.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer second.Close()
+	second.Write([]byte("b"))
+	second.SetReadDeadline(time.Now().Add(200 * time.Millisecond))
+	if _, err := second.Read(buf); err == nil {
+		t.Fatal("second connection should wait")
+	}
+	if tunnel.Active() != 1 {
+		t.Errorf("Active() = %d", tunnel.Active())
+	}
+
+	first.Close()
+	second.SetReadDeadline(time.Now().Add(5 * time.Second))
+	if _, err := io.ReadFull(second, buf); err != nil || buf[0] != 'b' {
+		t.Fatalf("second = %q, %v", buf, err)
+	}
+}
+
+func TestTunnel_Context(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	tunnel := newEchoTunnel(t, ctx)
+	cancel()
+	if err := tunnel.Wait(); err != context.Canceled {
+		t.Errorf("Wait() = %v", err)
+	}
+	if _, err := net.DialTimeout("tcp", tunnel.Addr().String(), time.Second); err == nil {
+		t.Error("listener should be closed")
+	}
+}
+
+func TestTunnel_DialError(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	dialErr := errors.New("refused")
+	tunnel := newTunnel(context.Background(), listener, nil, func(net.Conn) (net.Conn, string, error) {
+		return nil, "target:22", dialErr
+	})
+	defer tunnel.Close()
+
+	conn, err := net.Dial("tcp", tunnel.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	event := <-tunnel.Events()
+	if event.Type != ConnError || event.Err != dialErr || event.Conn.Target != "target:22" {
+		t.Errorf("event = %+v", event)
+	}
+}
+
+func TestClientType_RemoteForwardReconnectFailed(t *testing.T) {
+	server, err := sshtest.NewServer(sshtest.WithPassword("tester", "secret"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	conf := &AuthConfig{
+		Username: "tester",
+		Password: "secret",
+		HostKey: HostKeyPolicy{
+			Mode:         HostKeyFingerprint,
+			Fingerprints: []string{ssh.FingerprintSHA256(server.HostKey())},
+		},
+		NetworkConfig: NetworkConfig{Network: "tcp", Address: server.Addr(), ConnectTimeout: 1},
+	}
+	cli := newTestClient(t, conf, WithReconnect(1, 10*time.Millisecond))
+	tunnel, err := cli.RemoteForward(
+		NetworkConfig{Network: "tcp", Address: "127.0.0.1:0"},
+		NetworkConfig{Network: "tcp", Address: echoServer(t)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tunnel.Close()
+
+	// 服务端关闭后重连失败，转发应该结束而不是一直等待
+	server.Close()
+	select {
+	case <-tunnel.Done():
+	case <-time.After(10 * time.Second):
+		t.Fatal("remote forward still waiting after reconnect gave up")
+	}
+	if err := tunnel.Wait(); err == nil || !strings.Contains(err.Error(), "reconnect failed") {
+		t.Errorf("want reconnect error, got %v", err)
+	}
+	if cli.State() != StateDisconnected {
+		t.Errorf("state = %v", cli.State())
+	}
+}
@@ -3,18 +3,29 @@ module github.com/Lvzhenqian/library/ssh
 go 1.19
 
 require (
-	github.com/kr/fs v0.1.0
-	github.com/pkg/sftp v1.10.1
+	github.com/pkg/sftp v1.13.5
 	golang.org/x/crypto v0.0.0-20221012134737-56aed061732a
 	golang.org/x/term v0.0.0-20201126162022-7de9c90e9dd1
 	gopkg.in/cheggaaa/pb.v1 v1.0.28
 )
 
 require (
+	github.com/Lvzhenqian/library/errors v0.0.0-20231221094931-cc13f1d4e4e3 // indirect
 	github.com/fatih/color v1.13.0 // indirect
+	github.com/kr/fs v0.1.0 // indirect
 	github.com/mattn/go-colorable v0.1.13 // indirect
+	github.com/mattn/go-isatty v0.0.20 // indirect
 	github.com/mattn/go-runewidth v0.0.14 // indirect
-	github.com/pkg/errors v0.8.1 // indirect
+	github.com/panjf2000/ants/v2 v2.6.0 // indirect
 	github.com/rivo/uniseg v0.2.0 // indirect
-	golang.org/x/sys v0.0.0-20220811171246-fbc7d0a398ab // indirect
+	github.com/rs/zerolog v1.32.0 // indirect
+	go.opentelemetry.io/otel v1.24.0 // indirect
+	go.opentelemetry.io/otel/trace v1.24.0 // indirect
+	golang.org/x/sys v0.18.0 // indirect
+	gopkg.in/natefinch/lumberjack.v2 v2.0.0 // indirect
+)
+
+require (
+	github.com/Lvzhenqian/library/groupsync v0.0.0-20231221094931-cc13f1d4e4e3
+	github.com/Lvzhenqian/library/log v0.0.0-20231221094931-cc13f1d4e4e3
 )
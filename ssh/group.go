@@ -0,0 +1,30 @@
+package ssh
+
+import (
+	"github.com/Lvzhenqian/library/groupsync"
+)
+
+// runGroup 使用 workers 个 goroutine 并发执行 fn(0) 到 fn(n-1)，返回所有结果，顺序不确定。
+// 没能提交执行的任务由 failed 生成结果，每个任务都有且只有一个结果
+func runGroup[T any](n, workers int, fn func(i int) T, failed func(i int, err error) T) []T {
+	results := make([]T, 0, n)
+	// 额外的一个 goroutine 用于收集结果
+	group, err := groupsync.NewGroup(&results, groupsync.WithLimit(workers+1), groupsync.WithReceivers(1))
+	if err != nil {
+		for i := 0; i < n; i++ {
+			results = append(results, failed(i, err))
+		}
+		return results
+	}
+
+	// 提交失败的结果不能直接写入 results，receiver 可能正在追加
+	rejected := make([]T, 0)
+	for i := 0; i < n; i++ {
+		i := i
+		if submitErr := group.Go(func() T { return fn(i) }); submitErr != nil {
+			rejected = append(rejected, failed(i, submitErr))
+		}
+	}
+	group.Wait()
+	return append(results, rejected...)
+}
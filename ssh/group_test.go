@@ -0,0 +1,34 @@
+package ssh
+
+import (
+	"sort"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestRunGroup(t *testing.T) {
+	var running, peak int32
+	results := runGroup(20, 3, func(i int) int {
+		n := atomic.AddInt32(&running, 1)
+		for {
+			p := atomic.LoadInt32(&peak)
+			if n <= p || atomic.CompareAndSwapInt32(&peak, p, n) {
+				break
+			}
+		}
+		time.Sleep(5 * time.Millisecond)
+		atomic.AddInt32(&running, -1)
+		return i
+	}, func(i int, err error) int {
+		t.Errorf("task %d rejected: %v", i, err)
+		return -1
+	})
+	sort.Ints(results)
+	if len(results) != 20 || results[0] != 0 || results[19] != 19 {
+		t.Errorf("results = %v", results)
+	}
+	if peak > 3 {
+		t.Errorf("%d tasks ran at once, want at most 3", peak)
+	}
+}
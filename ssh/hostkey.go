@@ -0,0 +1,268 @@
+package ssh
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+type HostKeyMode int
+
+const (
+	// HostKeyStrict 只接受 known_hosts 中已记录的公钥，等同 StrictHostKeyChecking=yes，是默认的校验方式
+	HostKeyStrict HostKeyMode = iota
+	// HostKeyIgnore 不校验服务端公钥（旧版本的默认行为），存在中间人攻击风险，需要显式指定
+	HostKeyIgnore
+	// HostKeyTOFU 首次连接时把公钥追加到 known_hosts，之后严格校验，等同 StrictHostKeyChecking=accept-new
+	HostKeyTOFU
+	// HostKeyFingerprint 只接受 Fingerprints 中固定的公钥指纹
+	HostKeyFingerprint
+	// HostKeyCertificate 只接受 CAKeys 签发的主机证书，证书需要包含 Principals 中的一个
+	HostKeyCertificate
+)
+
+// DefaultKnownHosts 未指定 KnownHostsFiles 时使用的文件
+var DefaultKnownHosts = []string{"~/.ssh/known_hosts"}
+
+type HostKeyPolicy struct {
+	Mode HostKeyMode
+	// KnownHostsFiles known_hosts 文件列表，支持 hashed 记录和 @cert-authority/@revoked 标记。
+	// TOFU 模式下新公钥写入第一个文件
+	KnownHostsFiles []string
+	// Fingerprints 固定的公钥指纹，格式同 ssh-keygen -lf 输出，如 SHA256:xxxx 或 MD5:aa:bb:...
+	Fingerprints []string
+	// CAKeys 信任的主机证书 CA 公钥，每项为 authorized_keys 格式的公钥或包含这类公钥的文件
+	CAKeys []string
+	// Principals 主机证书需要包含其中一个 principal，为空时使用连接的主机名
+	Principals []string
+}
+
+// HostKeyError 服务端公钥校验失败
+type HostKeyError struct {
+	Host string
+	// Fingerprint 服务端提供的公钥指纹
+	Fingerprint string
+	// Want 期望的公钥指纹，为空表示 known_hosts 中没有该主机的记录
+	Want []string
+	// reason 主机证书校验失败的原因
+	reason string
+	err    error
+}
+
+func (e *HostKeyError) Error() string {
+	if e.reason != "" {
+		return fmt.Sprintf("host key verification failed: %s presented %s, %s", e.Host, e.Fingerprint, e.reason)
+	}
+	if len(e.Want) == 0 {
+		return fmt.Sprintf("host key verification failed: %s is unknown, fingerprint %s", e.Host, e.Fingerprint)
+	}
+	return fmt.Sprintf("host key verification failed: %s presented %s, want %s",
+		e.Host, e.Fingerprint, strings.Join(e.Want, ", "))
+}
+
+func (e *HostKeyError) Unwrap() error {
+	return e.err
+}
+
+// Unknown 主机不在 known_hosts 中
+func (e *HostKeyError) Unknown() bool {
+	return len(e.Want) == 0
+}
+
+// known_hosts 追加写入锁，避免并发连接时写坏文件
+var knownHostsMu sync.Mutex
+
+func (p *HostKeyPolicy) callback() (ssh.HostKeyCallback, error) {
+	switch p.Mode {
+	case HostKeyIgnore:
+		return ssh.InsecureIgnoreHostKey(), nil
+	case HostKeyStrict:
+		return p.knownHostsCallback(false)
+	case HostKeyTOFU:
+		return p.knownHostsCallback(true)
+	case HostKeyFingerprint:
+		if len(p.Fingerprints) == 0 {
+			return nil, errors.New("host key policy: no fingerprints configured")
+		}
+		return p.fingerprintCallback, nil
+	case HostKeyCertificate:
+		return p.certificateCallback()
+	default:
+		return nil, fmt.Errorf("host key policy: unknown mode %d", p.Mode)
+	}
+}
+
+func (p *HostKeyPolicy) files() []string {
+	files := p.KnownHostsFiles
+	if len(files) == 0 {
+		files = DefaultKnownHosts
+	}
+	ret := make([]string, 0, len(files))
+	for _, f := range files {
+		ret = append(ret, localRealPath(f))
+	}
+	return ret
+}
+
+func (p *HostKeyPolicy) knownHostsCallback(accept bool) (ssh.HostKeyCallback, error) {
+	files := p.files()
+	return func(hostname string, remote net.Addr, key ssh.PublicKey) error {
+		// 每次校验都重新读取，TOFU 追加的记录可以被后续连接看到
+		existing := make([]string, 0, len(files))
+		for _, f := range files {
+			if _, err := os.Stat(f); err == nil {
+				existing = append(existing, f)
+			}
+		}
+		check := func(string, net.Addr, ssh.PublicKey) error {
+			return &knownhosts.KeyError{}
+		}
+		if len(existing) > 0 {
+			cb, err := knownhosts.New(existing...)
+			if err != nil {
+				return fmt.Errorf("read known_hosts error: %w", err)
+			}
+			check = cb
+		}
+
+		err := check(hostname, remote, key)
+		var keyErr *knownhosts.KeyError
+		if err == nil || !errors.As(err, &keyErr) {
+			return err
+		}
+		if len(keyErr.Want) == 0 && accept {
+			return appendKnownHost(files[0], hostname, remote, key)
+		}
+		hostErr := &HostKeyError{
+			Host:        hostname,
+			Fingerprint: ssh.FingerprintSHA256(key),
+			err:         err,
+		}
+		for _, want := range keyErr.Want {
+			hostErr.Want = append(hostErr.Want, ssh.FingerprintSHA256(want.Key))
+		}
+		return hostErr
+	}, nil
+}
+
+func appendKnownHost(file, hostname string, remote net.Addr, key ssh.PublicKey) error {
+	knownHostsMu.Lock()
+	defer knownHostsMu.Unlock()
+
+	if err := os.MkdirAll(filepath.Dir(file), 0700); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(file, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return fmt.Errorf("open known_hosts %s error: %w", file, err)
+	}
+	defer f.Close()
+
+	addresses := []string{knownhosts.Normalize(hostname)}
+	if tcp, ok := remote.(*net.TCPAddr); ok {
+		if ip := knownhosts.Normalize(tcp.String()); ip != addresses[0] {
+			addresses = append(addresses, ip)
+		}
+	}
+	_, err = fmt.Fprintln(f, knownhosts.Line(addresses, key))
+	return err
+}
+
+func (p *HostKeyPolicy) fingerprintCallback(hostname string, _ net.Addr, key ssh.PublicKey) error {
+	sha := ssh.FingerprintSHA256(key)
+	md5 := "MD5:" + ssh.FingerprintLegacyMD5(key)
+	for _, fp := range p.Fingerprints {
+		if fp == sha || strings.EqualFold(fp, md5) {
+			return nil
+		}
+	}
+	return &HostKeyError{
+		Host:        hostname,
+		Fingerprint: sha,
+		Want:        p.Fingerprints,
+	}
+}
+
+// caKeys 解析 CAKeys，每项可以是公钥本身或文件，文件中的空行和注释会被跳过
+func (p *HostKeyPolicy) caKeys() ([]ssh.PublicKey, error) {
+	keys := make([]ssh.PublicKey, 0, len(p.CAKeys))
+	for _, item := range p.CAKeys {
+		content := []byte(item)
+		if _, _, _, _, err := ssh.ParseAuthorizedKey(content); err != nil {
+			if content, err = os.ReadFile(localRealPath(item)); err != nil {
+				return nil, fmt.Errorf("read CA key %s error: %w", item, err)
+			}
+		}
+		for len(bytes.TrimSpace(content)) > 0 {
+			key, _, _, rest, err := ssh.ParseAuthorizedKey(content)
+			if err != nil {
+				return nil, fmt.Errorf("parse CA key %s error: %w", item, err)
+			}
+			keys = append(keys, key)
+			content = rest
+		}
+	}
+	if len(keys) == 0 {
+		return nil, errors.New("host key policy: no CA keys configured")
+	}
+	return keys, nil
+}
+
+func (p *HostKeyPolicy) certificateCallback() (ssh.HostKeyCallback, error) {
+	cas, err := p.caKeys()
+	if err != nil {
+		return nil, err
+	}
+	want := make([]string, 0, len(cas))
+	for _, ca := range cas {
+		want = append(want, ssh.FingerprintSHA256(ca))
+	}
+	checker := &ssh.CertChecker{
+		IsHostAuthority: func(auth ssh.PublicKey, _ string) bool {
+			for _, ca := range cas {
+				if bytes.Equal(ca.Marshal(), auth.Marshal()) {
+					return true
+				}
+			}
+			return false
+		},
+	}
+	return func(hostname string, _ net.Addr, key ssh.PublicKey) error {
+		hostErr := &HostKeyError{Host: hostname, Fingerprint: ssh.FingerprintSHA256(key), Want: want}
+		cert, ok := key.(*ssh.Certificate)
+		switch {
+		case !ok:
+			hostErr.reason = "not a certificate"
+			return hostErr
+		case cert.CertType != ssh.HostCert:
+			hostErr.reason = "not a host certificate"
+			return hostErr
+		case !checker.IsHostAuthority(cert.SignatureKey, hostname):
+			hostErr.reason = fmt.Sprintf("certificate signed by untrusted CA %s", ssh.FingerprintSHA256(cert.SignatureKey))
+			return hostErr
+		}
+
+		principals := p.Principals
+		if len(principals) == 0 {
+			host, _, splitErr := net.SplitHostPort(hostname)
+			if splitErr != nil {
+				host = hostname
+			}
+			principals = []string{host}
+		}
+		for _, principal := range principals {
+			if hostErr.err = checker.CheckCert(principal, cert); hostErr.err == nil {
+				return nil
+			}
+		}
+		hostErr.reason = hostErr.err.Error()
+		return hostErr
+	}, nil
+}
@@ -0,0 +1,160 @@
+package ssh
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"errors"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func newTestPublicKey(t *testing.T) ssh.PublicKey {
+	pub, _, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, err := ssh.NewPublicKey(pub)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return key
+}
+
+func TestHostKeyPolicy_TOFU(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "known_hosts")
+	remote := &net.TCPAddr{IP: net.ParseIP("127.0.0.1"), Port: 2222}
+	key := newTestPublicKey(t)
+
+	tofu, err := (&HostKeyPolicy{Mode: HostKeyTOFU, KnownHostsFiles: []string{file}}).callback()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := tofu("example.com:2222", remote, key); err != nil {
+		t.Fatalf("first connect: %v", err)
+	}
+	if err := tofu("example.com:2222", remote, key); err != nil {
+		t.Fatalf("second connect: %v", err)
+	}
+
+	strict, err := (&HostKeyPolicy{Mode: HostKeyStrict, KnownHostsFiles: []string{file}}).callback()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := strict("example.com:2222", remote, key); err != nil {
+		t.Fatalf("strict after tofu: %v", err)
+	}
+
+	var hostErr *HostKeyError
+	err = tofu("example.com:2222", remote, newTestPublicKey(t))
+	if !errors.As(err, &hostErr) || hostErr.Unknown() {
+		t.Fatalf("want mismatch error, got %v", err)
+	}
+	if hostErr.Want[0] != ssh.FingerprintSHA256(key) {
+		t.Errorf("want fingerprint %s, got %s", ssh.FingerprintSHA256(key), hostErr.Want[0])
+	}
+}
+
+func TestHostKeyPolicy_StrictHashed(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "known_hosts")
+	remote := &net.TCPAddr{IP: net.ParseIP("10.0.0.1"), Port: 22}
+	key := newTestPublicKey(t)
+	line := knownhosts.Line([]string{knownhosts.HashHostname("example.com")}, key)
+	if err := os.WriteFile(file, []byte(line+"\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	strict, err := (&HostKeyPolicy{Mode: HostKeyStrict, KnownHostsFiles: []string{file}}).callback()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := strict("example.com:22", remote, key); err != nil {
+		t.Fatalf("hashed entry: %v", err)
+	}
+
+	var hostErr *HostKeyError
+	if err := strict("unknown.com:22", remote, key); !errors.As(err, &hostErr) || !hostErr.Unknown() {
+		t.Fatalf("want unknown host error, got %v", err)
+	}
+}
+
+func TestHostKeyPolicy_Fingerprint(t *testing.T) {
+	key := newTestPublicKey(t)
+	policy := &HostKeyPolicy{Mode: HostKeyFingerprint, Fingerprints: []string{ssh.FingerprintSHA256(key)}}
+	cb, err := policy.callback()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := cb("example.com:22", nil, key); err != nil {
+		t.Fatal(err)
+	}
+	var hostErr *HostKeyError
+	if err := cb("example.com:22", nil, newTestPublicKey(t)); !errors.As(err, &hostErr) {
+		t.Fatalf("want HostKeyError, got %v", err)
+	}
+}
+
+func TestHostKeyPolicy_Certificate(t *testing.T) {
+	ca := newTestCA(t)
+	cert := signTestCert(t, ca, newTestPublicKey(t), ssh.HostCert, "web-1", "10.0.0.1")
+	caFile := filepath.Join(t.TempDir(), "ca.pub")
+	if err := os.WriteFile(caFile, append([]byte("# host ca\n"), ssh.MarshalAuthorizedKey(ca.PublicKey())...), 0644); err != nil {
+		t.Fatal(err)
+	}
+	policy := &HostKeyPolicy{Mode: HostKeyCertificate, CAKeys: []string{caFile}}
+	cb, err := policy.callback()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := cb("web-1:22", nil, cert); err != nil {
+		t.Errorf("trusted certificate: %v", err)
+	}
+
+	var hostErr *HostKeyError
+	untrusted := signTestCert(t, newTestCA(t), newTestPublicKey(t), ssh.HostCert, "web-1")
+	userCert := signTestCert(t, ca, newTestPublicKey(t), ssh.UserCert, "web-1")
+	for name, tc := range map[string]struct {
+		host string
+		key  ssh.PublicKey
+	}{
+		"principal": {"web-2:22", cert},
+		"bare key":  {"web-1:22", newTestPublicKey(t)},
+		"other CA":  {"web-1:22", untrusted},
+		"user cert": {"web-1:22", userCert},
+	} {
+		if err := cb(tc.host, nil, tc.key); !errors.As(err, &hostErr) {
+			t.Errorf("%s: want HostKeyError, got %v", name, err)
+		}
+	}
+
+	policy = &HostKeyPolicy{
+		Mode:       HostKeyCertificate,
+		CAKeys:     []string{string(ssh.MarshalAuthorizedKey(ca.PublicKey()))},
+		Principals: []string{"web-2", "web-1"},
+	}
+	if cb, err = policy.callback(); err != nil {
+		t.Fatal(err)
+	}
+	if err := cb("192.168.1.1:22", nil, cert); err != nil {
+		t.Errorf("configured principals: %v", err)
+	}
+	if _, err := (&HostKeyPolicy{Mode: HostKeyCertificate}).callback(); err == nil {
+		t.Error("want error without CA keys")
+	}
+}
+
+func TestHostKeyPolicy_DefaultStrict(t *testing.T) {
+	conf := newTestServer(t)
+	conf.HostKey = HostKeyPolicy{KnownHostsFiles: []string{filepath.Join(t.TempDir(), "known_hosts")}}
+	_, err := NewClient(conf)
+	if err == nil || !strings.Contains(err.Error(), "is unknown") {
+		t.Errorf("zero-value policy should reject unknown hosts, got %v", err)
+	}
+
+	conf.HostKey.Mode = HostKeyIgnore
+	newTestClient(t, conf)
+}
@@ -0,0 +1,144 @@
+package ssh
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"golang.org/x/crypto/ssh"
+	"net"
+	"sync"
+)
+
+// bastion 跳板链中的一跳，相同路径（之前的跳板机、用户名、地址、主机公钥校验策略和认证方式都相同）的连接
+// 在所有客户端之间共享。
+// refs 为直接使用这个连接的下一跳跳板机和目标客户端的数量，降为 0 时关闭连接并释放上一跳
+type bastion struct {
+	key    string
+	client *ssh.Client
+	parent *bastion
+	refs   int
+}
+
+// jumpRegistry 正在使用的跳板机连接
+type jumpRegistry struct {
+	mu       sync.Mutex
+	bastions map[string]*bastion
+}
+
+var jumpPool = &jumpRegistry{bastions: make(map[string]*bastion)}
+
+// hopKey 配置不同的客户端不共享连接，否则严格校验主机公钥的客户端会复用 HostKeyIgnore 建立的连接，
+// 或者借用其他凭据认证过的连接
+func hopKey(parent *bastion, hop *AuthConfig) string {
+	key := fmt.Sprintf("%s@%s/%s#%s", hop.Username, hop.Network, hop.Address, hopIdentity(hop))
+	if parent != nil {
+		key = parent.key + " > " + key
+	}
+	return key
+}
+
+// hopIdentity 主机公钥校验策略和认证方式的摘要，密码只参与计算，不会出现在 key 中
+func hopIdentity(hop *AuthConfig) string {
+	h := sha256.New()
+	policy := hop.HostKey
+	fmt.Fprintf(h, "hostkey %d %q %q %q %q\n", policy.Mode, policy.KnownHostsFiles, policy.Fingerprints, policy.CAKeys, policy.Principals)
+	for _, m := range hop.methods() {
+		fmt.Fprintf(h, "auth %d %q %q %x %t\n", m.kind, m.files, m.certs, sha256.Sum256([]byte(m.password)), m.challenge != nil)
+	}
+	return hex.EncodeToString(h.Sum(nil))[:16]
+}
+
+// acquire 按顺序连接 hops 中的跳板机，已经建立的连接直接复用，返回最后一跳，使用完需要 release
+func (r *jumpRegistry) acquire(hops []AuthConfig) (*bastion, error) {
+	var parent *bastion
+	for i := range hops {
+		b, err := r.get(parent, &hops[i])
+		if err != nil {
+			r.release(parent)
+			return nil, fmt.Errorf("connect jump host %s error: %w", hops[i].Address, err)
+		}
+		parent = b
+	}
+	return parent, nil
+}
+
+// get 返回 parent 之后的一跳，调用方持有的 parent 引用转移给新建的连接，复用已有连接时释放
+func (r *jumpRegistry) get(parent *bastion, hop *AuthConfig) (*bastion, error) {
+	key := hopKey(parent, hop)
+	r.mu.Lock()
+	if b, ok := r.bastions[key]; ok {
+		b.refs++
+		r.mu.Unlock()
+		r.release(parent)
+		return b, nil
+	}
+	r.mu.Unlock()
+
+	client, err := dialHop(parent, hop)
+	if err != nil {
+		return nil, err
+	}
+	r.mu.Lock()
+	if b, ok := r.bastions[key]; ok {
+		// 并发连接同一个跳板机，使用先建立的连接
+		b.refs++
+		r.mu.Unlock()
+		client.Close()
+		r.release(parent)
+		return b, nil
+	}
+	b := &bastion{key: key, client: client, parent: parent, refs: 1}
+	r.bastions[key] = b
+	r.mu.Unlock()
+
+	go func() {
+		// 连接断开后不再复用，新的客户端会重新连接
+		client.Wait()
+		r.mu.Lock()
+		if r.bastions[key] == b {
+			delete(r.bastions, key)
+		}
+		r.mu.Unlock()
+	}()
+	return b, nil
+}
+
+// release 减少引用，没有使用者时关闭连接，再依次释放前面的跳板机
+func (r *jumpRegistry) release(b *bastion) {
+	for b != nil {
+		r.mu.Lock()
+		b.refs--
+		if b.refs > 0 {
+			r.mu.Unlock()
+			return
+		}
+		if r.bastions[b.key] == b {
+			delete(r.bastions, b.key)
+		}
+		r.mu.Unlock()
+		b.client.Close()
+		b = b.parent
+	}
+}
+
+// dialHop 直接或通过 parent 连接 hop
+func dialHop(parent *bastion, hop *AuthConfig) (*ssh.Client, error) {
+	config, release, err := authConfig(hop)
+	defer release()
+	if err != nil {
+		return nil, err
+	}
+	conn, err := dialVia(parent, &hop.NetworkConfig, config)
+	if err != nil {
+		return nil, err
+	}
+	return handshake(conn, hop.Address, config)
+}
+
+// dialVia 没有跳板机时按 NetworkConfig 的代理设置直接连接
+func dialVia(parent *bastion, n *NetworkConfig, config *ssh.ClientConfig) (net.Conn, error) {
+	if parent == nil {
+		return n.dial(config.Timeout)
+	}
+	return parent.client.Dial(n.Network, n.Address)
+}
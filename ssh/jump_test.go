@@ -0,0 +1,153 @@
+package ssh
+
+import (
+	"bytes"
+	"github.com/Lvzhenqian/library/ssh/sshtest"
+	"io"
+	"strings"
+	"testing"
+	"time"
+)
+
+// poolRefs 跳板机连接的引用数，不存在时返回 0
+func poolRefs(key string) int {
+	jumpPool.mu.Lock()
+	defer jumpPool.mu.Unlock()
+	if b, ok := jumpPool.bastions[key]; ok {
+		return b.refs
+	}
+	return 0
+}
+
+func poolSize() int {
+	jumpPool.mu.Lock()
+	defer jumpPool.mu.Unlock()
+	return len(jumpPool.bastions)
+}
+
+func namedServer(t *testing.T, name string) *AuthConfig {
+	return newTestServer(t, sshtest.WithHandler(func(s *sshtest.Session) int {
+		io.WriteString(s.Stdout, name)
+		return 0
+	}))
+}
+
+func TestJumpHosts_Shared(t *testing.T) {
+	first, second := newTestServer(t), newTestServer(t)
+	firstKey := hopKey(nil, first)
+	secondKey := firstKey + " > " + hopKey(nil, second)
+
+	var clients []Client
+	for _, name := range []string{"a", "b"} {
+		target := namedServer(t, name)
+		target.JumpHosts = []AuthConfig{*first, *second}
+		cli, err := NewClient(target)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var out bytes.Buffer
+		if err := cli.Run("hostname", &out, io.Discard); err != nil || out.String() != name {
+			t.Fatalf("run via jump hosts: %q, %v", out.String(), err)
+		}
+		clients = append(clients, cli)
+	}
+	if poolSize() != 2 || poolRefs(firstKey) != 1 || poolRefs(secondKey) != 2 {
+		t.Fatalf("want shared bastions, got size %d, refs %d/%d", poolSize(), poolRefs(firstKey), poolRefs(secondKey))
+	}
+
+	jumpPool.mu.Lock()
+	firstConn := jumpPool.bastions[firstKey].client
+	jumpPool.mu.Unlock()
+	clients[0].Close()
+	if poolRefs(secondKey) != 1 {
+		t.Errorf("want 1 ref after closing one client, got %d", poolRefs(secondKey))
+	}
+	clients[1].Close()
+	if poolSize() != 0 {
+		t.Errorf("want all bastions released, got %d", poolSize())
+	}
+	done := make(chan struct{})
+	go func() {
+		firstConn.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Error("first bastion connection not closed")
+	}
+}
+
+func TestJumpHosts_Prefix(t *testing.T) {
+	first, second := newTestServer(t), newTestServer(t)
+	direct, nested := namedServer(t, "direct"), namedServer(t, "nested")
+	direct.JumpHosts = []AuthConfig{*first}
+	nested.JumpHosts = []AuthConfig{*first, *second}
+
+	directCli := newTestClient(t, direct)
+	nestedCli, err := NewClient(nested)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if refs := poolRefs(hopKey(nil, first)); refs != 2 {
+		t.Errorf("first bastion should be used by the target and the second bastion, got %d refs", refs)
+	}
+	nestedCli.Close()
+	if poolSize() != 1 {
+		t.Errorf("want only the first bastion left, got %d", poolSize())
+	}
+	if err := directCli.Run("true", io.Discard, io.Discard); err != nil {
+		t.Error(err)
+	}
+	directCli.Close()
+	if poolSize() != 0 {
+		t.Errorf("want all bastions released, got %d", poolSize())
+	}
+}
+
+func TestJumpHosts_Failure(t *testing.T) {
+	first, second := newTestServer(t), newTestServer(t)
+	second.Password = "wrong"
+	target := newTestServer(t)
+	target.JumpHosts = []AuthConfig{*first, *second}
+	_, err := NewClient(target)
+	if err == nil || !strings.Contains(err.Error(), second.Address) {
+		t.Fatalf("want error for the second jump host, got %v", err)
+	}
+	if poolSize() != 0 {
+		t.Errorf("first bastion should be released on failure, got %d", poolSize())
+	}
+}
+
+func TestJumpHosts_PolicyNotShared(t *testing.T) {
+	bastion := newTestServer(t)
+	ignore := *bastion
+	ignore.HostKey = HostKeyPolicy{Mode: HostKeyIgnore}
+	loose := namedServer(t, "loose")
+	loose.JumpHosts = []AuthConfig{ignore}
+	newTestClient(t, loose)
+
+	// 指纹不匹配的严格配置不能复用 HostKeyIgnore 建立的跳板机连接
+	strict := *bastion
+	strict.HostKey = HostKeyPolicy{Mode: HostKeyFingerprint, Fingerprints: []string{"SHA256:AAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAA"}}
+	target := namedServer(t, "strict")
+	target.JumpHosts = []AuthConfig{strict}
+	if cli, err := NewClient(target); err == nil {
+		cli.Close()
+		t.Fatal("strict jump host attached to a bastion verified with HostKeyIgnore")
+	} else if !strings.Contains(err.Error(), "host key verification failed") {
+		t.Errorf("want host key error, got %v", err)
+	}
+
+	// 密码不同也不共享
+	other := *bastion
+	other.Password = "wrong"
+	target.JumpHosts = []AuthConfig{other}
+	if cli, err := NewClient(target); err == nil {
+		cli.Close()
+		t.Fatal("jump host with a wrong password attached to an authenticated bastion")
+	}
+	if poolSize() != 1 || poolRefs(hopKey(nil, &ignore)) != 1 {
+		t.Errorf("want only the ignore-policy bastion, got size %d", poolSize())
+	}
+}
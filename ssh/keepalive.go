@@ -0,0 +1,250 @@
+package ssh
+
+import (
+	"errors"
+	"fmt"
+	"golang.org/x/crypto/ssh"
+	"time"
+)
+
+type ConnState int
+
+const (
+	// StateConnected 连接正常
+	StateConnected ConnState = iota
+	// StateDisconnected 连接断开（keepalive 超时或底层连接关闭）
+	StateDisconnected
+	// StateReconnecting 正在重连
+	StateReconnecting
+	// StateClosed 调用了 Close
+	StateClosed
+)
+
+func (s ConnState) String() string {
+	switch s {
+	case StateConnected:
+		return "connected"
+	case StateDisconnected:
+		return "disconnected"
+	case StateReconnecting:
+		return "reconnecting"
+	case StateClosed:
+		return "closed"
+	default:
+		return fmt.Sprintf("ConnState(%d)", int(s))
+	}
+}
+
+// ErrKeepAliveTimeout 连续多次没有收到 keepalive 回复
+var ErrKeepAliveTimeout = errors.New("keepalive timeout")
+
+// 重连间隔的上限
+const maxReconnectBackoff = time.Minute
+
+type keepAliveConfig struct {
+	interval  time.Duration
+	maxMissed int
+}
+
+type reconnectConfig struct {
+	enabled    bool
+	maxRetries int
+	backoff    time.Duration
+}
+
+// WithKeepAlive 每隔 interval 发送 keepalive@openssh.com 请求，连续 maxMissed 次没有回复认为连接已断开，
+// 效果等同 ServerAliveInterval/ServerAliveCountMax
+func WithKeepAlive(interval time.Duration, maxMissed int) Option {
+	return func(c *ClientType) {
+		if maxMissed <= 0 {
+			maxMissed = 3
+		}
+		c.keepAlive = keepAliveConfig{interval: interval, maxMissed: maxMissed}
+	}
+}
+
+// WithReconnect 连接断开后自动重连，重新认证后后续的 Run/Push/隧道等操作使用新连接，
+// 通过跳板机建立的连接会经由跳板机当前的连接重连。
+// maxRetries 为 0 表示一直重试，第 n 次重试前等待 n*backoff，最长 1 分钟
+func WithReconnect(maxRetries int, backoff time.Duration) Option {
+	return func(c *ClientType) {
+		if backoff <= 0 {
+			backoff = time.Second
+		}
+		c.reconnect = reconnectConfig{enabled: true, maxRetries: maxRetries, backoff: backoff}
+	}
+}
+
+// WithStateCallback 连接状态变化时调用 fn，err 为导致状态变化的错误
+func WithStateCallback(fn func(state ConnState, err error)) Option {
+	return func(c *ClientType) {
+		c.onState = fn
+	}
+}
+
+// conn 返回当前的 ssh 连接，重连后会变化
+func (c *ClientType) conn() *ssh.Client {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.client
+}
+
+// State 当前的连接状态
+func (c *ClientType) State() ConnState {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.state
+}
+
+func (c *ClientType) setState(state ConnState, err error) {
+	c.mu.Lock()
+	if c.state == state || c.state == StateClosed {
+		c.mu.Unlock()
+		return
+	}
+	c.state = state
+	c.mu.Unlock()
+	if c.onState != nil {
+		c.onState(state, err)
+	}
+}
+
+func (c *ClientType) isClosed() bool {
+	select {
+	case <-c.closed:
+		return true
+	default:
+		return false
+	}
+}
+
+// startMonitor 配置了 keepalive、重连或状态回调时启动连接监控
+func (c *ClientType) startMonitor() {
+	if c.keepAlive.interval <= 0 && !c.reconnect.enabled && c.onState == nil {
+		return
+	}
+	go c.monitor()
+}
+
+func (c *ClientType) monitor() {
+	for {
+		cli := c.conn()
+		err := c.watch(cli)
+		if c.isClosed() {
+			return
+		}
+		cli.Close()
+		c.setState(StateDisconnected, err)
+		if !c.reconnect.enabled {
+			return
+		}
+		if err := c.redial(err); err != nil {
+			c.fail(err)
+			c.setState(StateDisconnected, err)
+			return
+		}
+		c.setState(StateConnected, nil)
+	}
+}
+
+// fail 重连失败后不再恢复连接，通知 RemoteForward 等等待重连的操作退出
+func (c *ClientType) fail(err error) {
+	c.mu.Lock()
+	c.failErr = err
+	c.mu.Unlock()
+	close(c.failed)
+}
+
+// failure 重连失败的原因
+func (c *ClientType) failure() error {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.failErr
+}
+
+// watch 阻塞直到连接断开或 Close，返回断开的原因
+func (c *ClientType) watch(cli *ssh.Client) error {
+	dead := make(chan error, 1)
+	go func() {
+		dead <- cli.Wait()
+	}()
+
+	var tick <-chan time.Time
+	if c.keepAlive.interval > 0 {
+		ticker := time.NewTicker(c.keepAlive.interval)
+		defer ticker.Stop()
+		tick = ticker.C
+	}
+
+	missed := 0
+	for {
+		select {
+		case <-c.closed:
+			return nil
+		case err := <-dead:
+			if err == nil {
+				err = errors.New("connection closed")
+			}
+			return err
+		case <-tick:
+		}
+		if sendKeepAlive(cli, c.keepAlive.interval) {
+			missed = 0
+			continue
+		}
+		missed++
+		if missed >= c.keepAlive.maxMissed {
+			return ErrKeepAliveTimeout
+		}
+	}
+}
+
+// sendKeepAlive 服务端回复失败也说明连接正常，只有超时才算丢失
+func sendKeepAlive(cli *ssh.Client, timeout time.Duration) bool {
+	reply := make(chan error, 1)
+	go func() {
+		_, _, err := cli.SendRequest("keepalive@openssh.com", true, nil)
+		reply <- err
+	}()
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	select {
+	case err := <-reply:
+		return err == nil
+	case <-timer.C:
+		return false
+	}
+}
+
+func (c *ClientType) redial(cause error) error {
+	lastErr := cause
+	for attempt := 1; c.reconnect.maxRetries == 0 || attempt <= c.reconnect.maxRetries; attempt++ {
+		c.setState(StateReconnecting, lastErr)
+		backoff := time.Duration(attempt) * c.reconnect.backoff
+		if backoff > maxReconnectBackoff {
+			backoff = maxReconnectBackoff
+		}
+		select {
+		case <-c.closed:
+			return nil
+		case <-time.After(backoff):
+		}
+
+		cli, err := c.dial()
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		c.mu.Lock()
+		if c.state == StateClosed {
+			c.mu.Unlock()
+			cli.Close()
+			c.setJump(nil)
+			return nil
+		}
+		c.client = cli
+		c.mu.Unlock()
+		return nil
+	}
+	return fmt.Errorf("reconnect failed after %d retries: %w", c.reconnect.maxRetries, lastErr)
+}
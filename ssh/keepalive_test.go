@@ -0,0 +1,97 @@
+package ssh
+
+import (
+	"bytes"
+	"errors"
+	"github.com/Lvzhenqian/library/ssh/sshtest"
+	"io"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestClientType_setState(t *testing.T) {
+	var states []ConnState
+	c := &ClientType{closed: make(chan struct{})}
+	WithStateCallback(func(state ConnState, err error) {
+		states = append(states, state)
+	})(c)
+
+	c.setState(StateDisconnected, ErrKeepAliveTimeout)
+	c.setState(StateDisconnected, ErrKeepAliveTimeout)
+	c.setState(StateReconnecting, nil)
+	c.setState(StateConnected, nil)
+	c.setState(StateClosed, nil)
+	c.setState(StateConnected, nil)
+
+	want := []ConnState{StateDisconnected, StateReconnecting, StateConnected, StateClosed}
+	if len(states) != len(want) {
+		t.Fatalf("states = %v, want %v", states, want)
+	}
+	for i := range want {
+		if states[i] != want[i] {
+			t.Errorf("states = %v, want %v", states, want)
+			break
+		}
+	}
+	if c.State().String() != "closed" {
+		t.Errorf("State() = %s", c.State())
+	}
+}
+
+func TestClientType_KeepAliveReconnect(t *testing.T) {
+	var silent int32 = 1
+	conf := newTestServer(t,
+		sshtest.WithKeepAliveReply(func() bool { return atomic.LoadInt32(&silent) == 0 }),
+		sshtest.WithHandler(func(s *sshtest.Session) int {
+			io.WriteString(s.Stdout, s.Cmd)
+			return 0
+		}),
+	)
+	states := make(chan ConnState, 8)
+	errs := make(chan error, 8)
+	cli := newTestClient(t, conf,
+		WithKeepAlive(50*time.Millisecond, 2),
+		WithReconnect(3, 10*time.Millisecond),
+		WithStateCallback(func(state ConnState, err error) {
+			if state == StateDisconnected {
+				// 重连后的连接正常回复 keepalive
+				atomic.StoreInt32(&silent, 0)
+			}
+			states <- state
+			errs <- err
+		}),
+	)
+	old := cli.(*ClientType).conn()
+
+	want := []ConnState{StateDisconnected, StateReconnecting, StateConnected}
+	for i, w := range want {
+		select {
+		case state := <-states:
+			err := <-errs
+			if state != w {
+				t.Fatalf("state %d = %s, want %s", i, state, w)
+			}
+			if i == 0 && !errors.Is(err, ErrKeepAliveTimeout) {
+				t.Errorf("disconnected error = %v, want %v", err, ErrKeepAliveTimeout)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("timeout waiting for state %s", w)
+		}
+	}
+	if cli.(*ClientType).conn() == old {
+		t.Error("client was not replaced after reconnect")
+	}
+
+	var out bytes.Buffer
+	if err := cli.Run("hostname", &out, io.Discard); err != nil || out.String() != "hostname" {
+		t.Fatalf("run after reconnect = %q, %v", out.String(), err)
+	}
+	// 新连接的 keepalive 有回复，不会再次断开
+	time.Sleep(200 * time.Millisecond)
+	select {
+	case state := <-states:
+		t.Errorf("unexpected state %s after reconnect", state)
+	default:
+	}
+}
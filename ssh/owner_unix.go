@@ -0,0 +1,17 @@
+//go:build !windows
+
+package ssh
+
+import (
+	"os"
+	"syscall"
+)
+
+// sysOwner 本地文件的 uid/gid
+func sysOwner(info os.FileInfo) (int, int, bool) {
+	stat, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return 0, 0, false
+	}
+	return int(stat.Uid), int(stat.Gid), true
+}
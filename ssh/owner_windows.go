@@ -0,0 +1,12 @@
+//go:build windows
+
+package ssh
+
+import (
+	"os"
+)
+
+// windows 没有 uid/gid
+func sysOwner(info os.FileInfo) (int, int, bool) {
+	return 0, 0, false
+}
@@ -0,0 +1,374 @@
+package ssh
+
+import (
+	"github.com/Lvzhenqian/library/log"
+	"gopkg.in/cheggaaa/pb.v1"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Progress 传输进度回调。目录传输时文件并发复制，FileStart、Add、FileDone 会在多个 goroutine 中同时调用，
+// 实现需要并发安全
+type Progress interface {
+	// Start 传输开始，name 为传输的文件或目录名，files 为文件数量，total 为总字节数
+	Start(name string, files int, total int64)
+	// FileStart 开始传输一个文件，失败重试时会再次调用。offset 为断点续传跳过的字节数
+	FileStart(name string, size, offset int64)
+	// Add 文件 name 又传输了 n 字节
+	Add(name string, n int64)
+	// FileDone 文件传输结束，err 为 nil 表示成功
+	FileDone(name string, err error)
+	// Finish 全部传输结束，err 为汇总的错误
+	Finish(err error)
+}
+
+type nopProgress struct{}
+
+func (nopProgress) Start(string, int, int64)       {}
+func (nopProgress) FileStart(string, int64, int64) {}
+func (nopProgress) Add(string, int64)              {}
+func (nopProgress) FileDone(string, error)         {}
+func (nopProgress) Finish(error)                   {}
+
+// fileProgress 绑定文件名，作为 io.Writer 统计写入的字节数
+type fileProgress struct {
+	Progress
+	name string
+}
+
+func (p fileProgress) start(size, offset int64) {
+	p.FileStart(p.name, size, offset)
+}
+
+func (p fileProgress) Write(b []byte) (int, error) {
+	p.Add(p.name, int64(len(b)))
+	return len(b), nil
+}
+
+func (p fileProgress) done(err error) error {
+	p.FileDone(p.name, err)
+	return err
+}
+
+// fileBytes 记录每个正在传输的文件已经计入总进度的字节数。重试或续传时文件从 offset 重新开始，
+// 总进度只需要调整和上一次的差值，避免重复计数超过 100%
+type fileBytes struct {
+	mu    sync.Mutex
+	files map[string]int64
+}
+
+// start 返回总进度需要增加的字节数，可能为负数
+func (f *fileBytes) start(name string, offset int64) int64 {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.files == nil {
+		f.files = make(map[string]int64)
+	}
+	delta := offset - f.files[name]
+	f.files[name] = offset
+	return delta
+}
+
+func (f *fileBytes) add(name string, n int64) {
+	f.mu.Lock()
+	if f.files != nil {
+		f.files[name] += n
+	}
+	f.mu.Unlock()
+}
+
+func (f *fileBytes) done(name string) {
+	f.mu.Lock()
+	delete(f.files, name)
+	f.mu.Unlock()
+}
+
+func (f *fileBytes) reset() {
+	f.mu.Lock()
+	f.files = nil
+	f.mu.Unlock()
+}
+
+// progress 每次传输调用一次，没有设置进度回调时返回空实现
+func (c *ClientType) progress() Progress {
+	if c.newProgress == nil {
+		return nopProgress{}
+	}
+	return c.newProgress()
+}
+
+func newBar(title string, total int64) *pb.ProgressBar {
+	bar := pb.New64(total)
+	bar.SetUnits(pb.U_BYTES)
+	bar.ShowSpeed = true
+	bar.ShowTimeLeft = true
+	bar.ShowPercent = true
+	bar.Prefix(title)
+	return bar
+}
+
+// barProgress 用一个 pb 进度条显示总进度
+type barProgress struct {
+	bar     *pb.ProgressBar
+	started fileBytes
+}
+
+// NewBarProgress 在终端显示一个总进度条，WithProgressBar(true) 使用的就是这个实现
+func NewBarProgress() Progress {
+	return &barProgress{}
+}
+
+func (p *barProgress) Start(name string, _ int, total int64) {
+	p.bar = newBar(name, total)
+	p.started.reset()
+	p.bar.Start()
+}
+
+func (p *barProgress) FileStart(name string, _, offset int64) {
+	p.bar.Add64(p.started.start(name, offset))
+}
+
+func (p *barProgress) Add(name string, n int64) {
+	p.started.add(name, n)
+	p.bar.Add64(n)
+}
+
+func (p *barProgress) FileDone(name string, _ error) {
+	p.started.done(name)
+}
+
+func (p *barProgress) Finish(error) {
+	p.bar.Finish()
+}
+
+// multiBarProgress 第一行显示总进度，下面每个正在传输的文件一行。
+// 文件结束后进度条留给下一个文件复用，行数不超过并发数
+type multiBarProgress struct {
+	mu    sync.Mutex
+	pool  *pb.Pool
+	total *pb.ProgressBar
+	// active 正在传输的文件占用的进度条
+	active map[string]*pb.ProgressBar
+	// idle 已经结束、可以复用的进度条
+	idle    []*pb.ProgressBar
+	started fileBytes
+}
+
+// NewMultiBarProgress 并发传输目录时为每个正在传输的文件显示一个进度条，终端不支持时不显示
+func NewMultiBarProgress() Progress {
+	return &multiBarProgress{}
+}
+
+func (p *multiBarProgress) Start(name string, _ int, total int64) {
+	p.total = newBar(name, total)
+	p.active = make(map[string]*pb.ProgressBar)
+	p.idle = nil
+	p.started.reset()
+	if pool, err := pb.StartPool(p.total); err == nil {
+		p.pool = pool
+	}
+}
+
+func (p *multiBarProgress) FileStart(name string, size, offset int64) {
+	p.total.Add64(p.started.start(name, offset))
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	bar, ok := p.active[name]
+	switch {
+	case ok:
+		// 重试时从 offset 重新开始
+	case len(p.idle) > 0:
+		bar = p.idle[len(p.idle)-1]
+		p.idle = p.idle[:len(p.idle)-1]
+	default:
+		bar = newBar("", size)
+		if p.pool != nil {
+			p.pool.Add(bar)
+		}
+	}
+	bar.Prefix(name)
+	bar.SetTotal64(size)
+	bar.Set64(offset)
+	p.active[name] = bar
+}
+
+func (p *multiBarProgress) Add(name string, n int64) {
+	p.started.add(name, n)
+	p.total.Add64(n)
+	p.mu.Lock()
+	bar := p.active[name]
+	p.mu.Unlock()
+	if bar != nil {
+		bar.Add64(n)
+	}
+}
+
+func (p *multiBarProgress) FileDone(name string, _ error) {
+	p.started.done(name)
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if bar, ok := p.active[name]; ok {
+		delete(p.active, name)
+		p.idle = append(p.idle, bar)
+	}
+}
+
+func (p *multiBarProgress) Finish(error) {
+	p.total.Finish()
+	if p.pool != nil {
+		p.pool.Stop()
+		p.pool = nil
+	}
+}
+
+// logProgress 通过 ZeroLogger 输出结构化的进度日志
+type logProgress struct {
+	logger   *log.ZeroLogger
+	interval time.Duration
+
+	name    string
+	files   int
+	total   int64
+	start   time.Time
+	done    int64
+	failed  int64
+	bytes   int64
+	lastLog int64
+	started fileBytes
+}
+
+// NewLogProgress 通过 logger 输出传输日志：开始和结束为 info，单个文件为 debug，失败为 error，
+// 传输过程中每隔 interval 输出一次总进度，小于等于 0 时不输出
+func NewLogProgress(logger *log.ZeroLogger, interval time.Duration) Progress {
+	return &logProgress{logger: logger, interval: interval}
+}
+
+func (p *logProgress) Start(name string, files int, total int64) {
+	p.name, p.files, p.total, p.start = name, files, total, time.Now()
+	atomic.StoreInt64(&p.done, 0)
+	atomic.StoreInt64(&p.failed, 0)
+	atomic.StoreInt64(&p.bytes, 0)
+	atomic.StoreInt64(&p.lastLog, p.start.UnixNano())
+	p.started.reset()
+	logger := p.logger.Multi()
+	logger.Info().Str("name", name).Int("files", files).Int64("bytes", total).Msg("transfer started")
+}
+
+func (p *logProgress) FileStart(name string, size, offset int64) {
+	atomic.AddInt64(&p.bytes, p.started.start(name, offset))
+	logger := p.logger.Multi()
+	logger.Debug().Str("file", name).Int64("size", size).Int64("offset", offset).Msg("file started")
+}
+
+func (p *logProgress) Add(name string, n int64) {
+	p.started.add(name, n)
+	bytes := atomic.AddInt64(&p.bytes, n)
+	if p.interval <= 0 {
+		return
+	}
+	now, last := time.Now().UnixNano(), atomic.LoadInt64(&p.lastLog)
+	if now-last < int64(p.interval) || !atomic.CompareAndSwapInt64(&p.lastLog, last, now) {
+		return
+	}
+	logger := p.logger.Multi()
+	event := logger.Info().Str("name", p.name).Int64("bytes", bytes).Int64("total", p.total).
+		Int64("files_done", atomic.LoadInt64(&p.done)).Int("files", p.files)
+	if p.total > 0 {
+		event = event.Float64("percent", float64(bytes*100)/float64(p.total))
+	}
+	event.Msg("transfer progress")
+}
+
+func (p *logProgress) FileDone(name string, err error) {
+	p.started.done(name)
+	logger := p.logger.Multi()
+	if err != nil {
+		atomic.AddInt64(&p.failed, 1)
+		logger.Error().Err(err).Str("file", name).Msg("file failed")
+		return
+	}
+	atomic.AddInt64(&p.done, 1)
+	logger.Debug().Str("file", name).Msg("file finished")
+}
+
+func (p *logProgress) Finish(err error) {
+	logger := p.logger.Multi()
+	event := logger.Info()
+	if err != nil {
+		event = logger.Error().Err(err)
+	}
+	event.Str("name", p.name).
+		Int64("files_done", atomic.LoadInt64(&p.done)).
+		Int64("files_failed", atomic.LoadInt64(&p.failed)).
+		Int64("bytes", atomic.LoadInt64(&p.bytes)).
+		Dur("elapsed", time.Since(p.start)).
+		Msg("transfer finished")
+}
+
+// sharedProgress WithProgress 设置的进度回调被所有传输共用。Start 会重置进度，
+// 所以同一时间只有一个传输报告给 p，其他并发的传输不报告进度，直到它 Finish
+type sharedProgress struct {
+	p    Progress
+	busy int32
+}
+
+// sharedTransfer 一次传输持有的 sharedProgress，owner 表示 Start 时抢到了 p
+type sharedTransfer struct {
+	shared *sharedProgress
+	owner  bool
+}
+
+func (t *sharedTransfer) Start(name string, files int, total int64) {
+	t.owner = atomic.CompareAndSwapInt32(&t.shared.busy, 0, 1)
+	if t.owner {
+		t.shared.p.Start(name, files, total)
+	}
+}
+
+func (t *sharedTransfer) FileStart(name string, size, offset int64) {
+	if t.owner {
+		t.shared.p.FileStart(name, size, offset)
+	}
+}
+
+func (t *sharedTransfer) Add(name string, n int64) {
+	if t.owner {
+		t.shared.p.Add(name, n)
+	}
+}
+
+func (t *sharedTransfer) FileDone(name string, err error) {
+	if t.owner {
+		t.shared.p.FileDone(name, err)
+	}
+}
+
+func (t *sharedTransfer) Finish(err error) {
+	if !t.owner {
+		return
+	}
+	t.shared.p.Finish(err)
+	t.owner = false
+	atomic.StoreInt32(&t.shared.busy, 0)
+}
+
+// WithProgress 设置传输进度回调，所有传输共用同一个 p，nil 表示不报告进度。
+// 并发的传输只有先 Start 的一个报告给 p，需要每个传输都显示进度时使用 WithProgressFunc
+func WithProgress(p Progress) Option {
+	return func(c *ClientType) {
+		if p == nil {
+			c.newProgress = nil
+			return
+		}
+		shared := &sharedProgress{p: p}
+		c.newProgress = func() Progress { return &sharedTransfer{shared: shared} }
+	}
+}
+
+// WithProgressFunc 每次传输调用 newProgress 创建新的进度回调，例如 WithProgressFunc(NewMultiBarProgress)
+func WithProgressFunc(newProgress func() Progress) Option {
+	return func(c *ClientType) {
+		c.newProgress = newProgress
+	}
+}
@@ -0,0 +1,192 @@
+package ssh
+
+import (
+	"errors"
+	"github.com/Lvzhenqian/library/log"
+	"gopkg.in/cheggaaa/pb.v1"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+)
+
+type recordProgress struct {
+	mu     sync.Mutex
+	name   string
+	files  int
+	total  int64
+	bytes  map[string]int64
+	done   []string
+	failed []string
+	err    error
+}
+
+func (p *recordProgress) Start(name string, files int, total int64) {
+	p.name, p.files, p.total, p.bytes = name, files, total, make(map[string]int64)
+}
+
+func (p *recordProgress) FileStart(name string, _, offset int64) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.bytes[name] = offset
+}
+
+func (p *recordProgress) Add(name string, n int64) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.bytes[name] += n
+}
+
+func (p *recordProgress) FileDone(name string, err error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if err != nil {
+		p.failed = append(p.failed, name)
+		return
+	}
+	p.done = append(p.done, name)
+}
+
+func (p *recordProgress) Finish(err error) {
+	p.err = err
+}
+
+func TestClientType_transferDir_Progress(t *testing.T) {
+	src := filepath.Join(t.TempDir(), "src")
+	writeTree(t, src, map[string]string{"a.txt": "aaa", "sub/b.txt": "bb"})
+	progress := &recordProgress{}
+	c := &ClientType{}
+	WithProgress(progress)(c)
+
+	if err := c.transferDir(localFS{}, localFS{}, src, t.TempDir(), newTransfer(nil), localCopy); err != nil {
+		t.Fatal(err)
+	}
+	sort.Strings(progress.done)
+	if progress.name != "src" || progress.files != 2 || progress.total != 5 || progress.err != nil {
+		t.Errorf("progress = %+v", progress)
+	}
+	if !reflect.DeepEqual(progress.done, []string{"src/a.txt", "src/sub/b.txt"}) || progress.bytes["src/a.txt"] != 3 {
+		t.Errorf("done = %v, bytes = %v", progress.done, progress.bytes)
+	}
+}
+
+func TestClientType_sync_Progress(t *testing.T) {
+	src := t.TempDir()
+	writeTree(t, src, map[string]string{"a.txt": "aaa", "b.txt": "b"})
+	progress := &recordProgress{}
+	c := &ClientType{}
+	WithProgressFunc(func() Progress { return progress })(c)
+
+	if _, err := c.sync(localFS{}, localFS{}, src, t.TempDir(), nil); err != nil {
+		t.Fatal(err)
+	}
+	if progress.files != 2 || progress.total != 4 || strings.Join(progress.done, ",") != "a.txt,b.txt" {
+		t.Errorf("progress = %+v", progress)
+	}
+}
+
+func TestWithProgress_Concurrent(t *testing.T) {
+	progress := &recordProgress{}
+	c := &ClientType{}
+	WithProgress(progress)(c)
+
+	first, second := c.progress(), c.progress()
+	first.Start("first", 1, 3)
+	second.Start("second", 1, 5)
+	second.FileStart("second/b.txt", 5, 0)
+	second.Add("second/b.txt", 5)
+	second.FileDone("second/b.txt", nil)
+	second.Finish(nil)
+	first.FileStart("first/a.txt", 3, 0)
+	first.Add("first/a.txt", 3)
+	first.FileDone("first/a.txt", nil)
+	first.Finish(nil)
+	if progress.name != "first" || progress.total != 3 || !reflect.DeepEqual(progress.done, []string{"first/a.txt"}) {
+		t.Errorf("progress = %+v", progress)
+	}
+
+	third := c.progress()
+	third.Start("third", 1, 7)
+	third.Finish(nil)
+	if progress.name != "third" || progress.total != 7 {
+		t.Errorf("progress after finish = %+v", progress)
+	}
+}
+
+func TestMultiBarProgress_Reuse(t *testing.T) {
+	// 不启动 pb.Pool，只检查进度条的分配和复用
+	p := &multiBarProgress{total: newBar("dir", 30), active: make(map[string]*pb.ProgressBar)}
+	p.FileStart("a", 10, 0)
+	p.FileStart("b", 10, 4)
+	p.Add("a", 10)
+	bar := p.active["a"]
+	if bar.Get() != 10 || p.active["b"].Get() != 4 {
+		t.Errorf("a = %d, b = %d", bar.Get(), p.active["b"].Get())
+	}
+	p.FileDone("a", nil)
+	p.FileStart("c", 10, 0)
+	if p.active["c"] != bar || bar.Get() != 0 || len(p.active) != 2 {
+		t.Error("finished bar should be reused")
+	}
+	if p.total.Get() != 14 {
+		t.Errorf("total = %d", p.total.Get())
+	}
+}
+
+func TestBarProgress_Retry(t *testing.T) {
+	p := &barProgress{bar: newBar("a", 100)}
+	p.FileStart("a", 100, 0)
+	p.Add("a", 60)
+	// 续传重试从 40 字节处继续，之前多计入的 20 字节需要扣除
+	p.FileStart("a", 100, 40)
+	if p.bar.Get() != 40 {
+		t.Errorf("after resume = %d, want 40", p.bar.Get())
+	}
+	p.Add("a", 60)
+	p.FileDone("a", nil)
+	if p.bar.Get() != 100 {
+		t.Errorf("total = %d, want 100", p.bar.Get())
+	}
+
+	m := &multiBarProgress{total: newBar("dir", 20), active: make(map[string]*pb.ProgressBar)}
+	m.FileStart("a", 10, 5)
+	m.Add("a", 3)
+	m.FileStart("a", 10, 0)
+	m.Add("a", 10)
+	m.FileStart("b", 10, 10)
+	if m.total.Get() != 20 {
+		t.Errorf("multi bar total = %d, want 20", m.total.Get())
+	}
+}
+
+func TestLogProgress(t *testing.T) {
+	name := filepath.Join(t.TempDir(), "transfer.log")
+	logger, err := log.NewLogger(&log.ZeroLoggerConfig{Filename: name, LogLevel: "debug"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := NewLogProgress(logger, 0)
+	p.Start("dir", 2, 10)
+	p.FileStart("dir/a", 6, 0)
+	p.Add("dir/a", 6)
+	p.FileDone("dir/a", nil)
+	p.FileStart("dir/b", 4, 0)
+	p.FileDone("dir/b", errors.New("permission denied"))
+	p.Finish(errors.New("1/2 files failed"))
+
+	b, err := os.ReadFile(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(string(b)), "\n")
+	if len(lines) != 6 {
+		t.Fatalf("lines = %q", lines)
+	}
+	if !strings.Contains(lines[2], `"file":"dir/a"`) || !strings.Contains(lines[5], `"files_failed":1`) ||
+		!strings.Contains(lines[5], `"bytes":6`) || !strings.Contains(lines[5], `"level":"error"`) {
+		t.Errorf("log = %s", b)
+	}
+}
@@ -0,0 +1,296 @@
+package ssh
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// DialFunc 自定义拨号函数，签名同 net.Dialer.DialContext
+type DialFunc func(ctx context.Context, network, address string) (net.Conn, error)
+
+// socks5 用户名密码认证（RFC 1929）
+const (
+	socks5UserPass       = 0x02
+	socks5UserPassVer    = 0x01
+	socks5UserPassStatus = 0x00
+)
+
+// proxyURL 连接使用的代理，不使用代理时返回 nil
+func (n *NetworkConfig) proxyURL() (*url.URL, error) {
+	raw := n.Proxy
+	if raw == "" && n.ProxyFromEnv {
+		raw = getenv("ALL_PROXY", "all_proxy")
+		if raw != "" && noProxy(n.Address, getenv("NO_PROXY", "no_proxy")) {
+			raw = ""
+		}
+	}
+	if raw == "" || n.Network == "unix" {
+		return nil, nil
+	}
+	u, err := url.Parse(raw)
+	if err != nil {
+		return nil, fmt.Errorf("invalid proxy %q: %w", raw, err)
+	}
+	switch u.Scheme {
+	case "http", "https", "socks5", "socks5h":
+	default:
+		return nil, fmt.Errorf("unsupported proxy scheme %q", u.Scheme)
+	}
+	return u, nil
+}
+
+func getenv(names ...string) string {
+	for _, name := range names {
+		if v := os.Getenv(name); v != "" {
+			return v
+		}
+	}
+	return ""
+}
+
+// noProxy 判断 address 是否在 NO_PROXY 中：* 匹配所有主机，域名匹配自身及子域名，也支持 IP 和 CIDR，
+// 带端口的项只匹配对应端口
+func noProxy(address, list string) bool {
+	host, port, err := net.SplitHostPort(address)
+	if err != nil {
+		host = address
+	}
+	host = strings.ToLower(host)
+	ip := net.ParseIP(host)
+	for _, item := range strings.Split(list, ",") {
+		item = strings.ToLower(strings.TrimSpace(item))
+		if item == "" {
+			continue
+		}
+		if item == "*" {
+			return true
+		}
+		if _, cidr, err := net.ParseCIDR(item); err == nil {
+			if ip != nil && cidr.Contains(ip) {
+				return true
+			}
+			continue
+		}
+		if h, p, err := net.SplitHostPort(item); err == nil {
+			if p != port {
+				continue
+			}
+			item = h
+		}
+		item = strings.TrimPrefix(strings.TrimPrefix(item, "*"), ".")
+		if host == item || strings.HasSuffix(host, "."+item) {
+			return true
+		}
+	}
+	return false
+}
+
+// dial 建立到 SSH 服务端的连接，依次考虑 Dial 和代理设置，timeout 包括和代理握手的时间
+func (n *NetworkConfig) dial(timeout time.Duration) (net.Conn, error) {
+	ctx := context.Background()
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+	dial := n.Dial
+	if dial == nil {
+		dial = (&net.Dialer{}).DialContext
+	}
+	proxy, err := n.proxyURL()
+	if err != nil {
+		return nil, err
+	}
+	if proxy == nil {
+		return dial(ctx, n.Network, n.Address)
+	}
+
+	conn, err := dialProxy(ctx, dial, proxy)
+	if err != nil {
+		return nil, fmt.Errorf("connect proxy %s error: %w", proxy.Redacted(), err)
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+	tunnel := conn
+	switch proxy.Scheme {
+	case "socks5", "socks5h":
+		err = socks5Dial(ctx, conn, proxy, n.Address)
+	default:
+		tunnel, err = httpConnect(conn, proxy, n.Address)
+	}
+	if err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("proxy %s connect %s error: %w", proxy.Redacted(), n.Address, err)
+	}
+	conn.SetDeadline(time.Time{})
+	return tunnel, nil
+}
+
+func dialProxy(ctx context.Context, dial DialFunc, proxy *url.URL) (net.Conn, error) {
+	port := proxy.Port()
+	if port == "" {
+		port = map[string]string{"http": "80", "https": "443"}[proxy.Scheme]
+	}
+	if port == "" {
+		port = "1080"
+	}
+	conn, err := dial(ctx, "tcp", net.JoinHostPort(proxy.Hostname(), port))
+	if err != nil || proxy.Scheme != "https" {
+		return conn, err
+	}
+	tlsConn := tls.Client(conn, &tls.Config{ServerName: proxy.Hostname()})
+	if err := tlsConn.HandshakeContext(ctx); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return tlsConn, nil
+}
+
+// bufferedConn HTTP 响应之后代理可能已经发送了 SSH 版本号，先读完缓冲区中的数据
+type bufferedConn struct {
+	net.Conn
+	r *bufio.Reader
+}
+
+func (c *bufferedConn) Read(p []byte) (int, error) {
+	return c.r.Read(p)
+}
+
+func httpConnect(conn net.Conn, proxy *url.URL, address string) (net.Conn, error) {
+	req := &http.Request{
+		Method: http.MethodConnect,
+		URL:    &url.URL{Opaque: address},
+		Host:   address,
+		Header: make(http.Header),
+	}
+	if proxy.User != nil {
+		password, _ := proxy.User.Password()
+		auth := base64.StdEncoding.EncodeToString([]byte(proxy.User.Username() + ":" + password))
+		req.Header.Set("Proxy-Authorization", "Basic "+auth)
+	}
+	if err := req.Write(conn); err != nil {
+		return nil, err
+	}
+	r := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(r, req)
+	if err != nil {
+		return nil, err
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("http proxy: %s", resp.Status)
+	}
+	if r.Buffered() > 0 {
+		return &bufferedConn{Conn: conn, r: r}, nil
+	}
+	return conn, nil
+}
+
+// socks5Dial 客户端握手，socks5 在本地解析域名，socks5h 由代理解析
+func socks5Dial(ctx context.Context, conn net.Conn, proxy *url.URL, address string) error {
+	method := byte(socks5NoAuth)
+	if proxy.User != nil {
+		method = socks5UserPass
+	}
+	if _, err := conn.Write([]byte{socks5Version, 1, method}); err != nil {
+		return err
+	}
+	reply := make([]byte, 2)
+	if _, err := io.ReadFull(conn, reply); err != nil {
+		return err
+	}
+	if reply[0] != socks5Version || reply[1] != method {
+		return errors.New("socks: no acceptable auth method")
+	}
+	if method == socks5UserPass {
+		user := proxy.User.Username()
+		password, _ := proxy.User.Password()
+		if len(user) > 255 || len(password) > 255 {
+			return errors.New("socks: username or password too long")
+		}
+		auth := append([]byte{socks5UserPassVer, byte(len(user))}, user...)
+		auth = append(append(auth, byte(len(password))), password...)
+		if _, err := conn.Write(auth); err != nil {
+			return err
+		}
+		if _, err := io.ReadFull(conn, reply); err != nil {
+			return err
+		}
+		if reply[1] != socks5UserPassStatus {
+			return errors.New("socks: authentication failed")
+		}
+	}
+
+	host, portStr, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	port, err := strconv.Atoi(portStr)
+	if err != nil {
+		return fmt.Errorf("invalid port %q", portStr)
+	}
+	ip := net.ParseIP(host)
+	if ip == nil && proxy.Scheme == "socks5" {
+		addrs, lookupErr := net.DefaultResolver.LookupIPAddr(ctx, host)
+		if lookupErr != nil {
+			return lookupErr
+		}
+		ip = addrs[0].IP
+	}
+	request := []byte{socks5Version, socks5Connect, 0x00}
+	switch {
+	case ip.To4() != nil:
+		request = append(append(request, socks5IPv4), ip.To4()...)
+	case ip != nil:
+		request = append(append(request, socks5IPv6), ip.To16()...)
+	default:
+		if len(host) > 255 {
+			return errors.New("socks: host name too long")
+		}
+		request = append(append(request, socks5Domain, byte(len(host))), host...)
+	}
+	request = binary.BigEndian.AppendUint16(request, uint16(port))
+	if _, err := conn.Write(request); err != nil {
+		return err
+	}
+
+	header := make([]byte, 4)
+	if _, err := io.ReadFull(conn, header); err != nil {
+		return err
+	}
+	if header[1] != socks5Succeeded {
+		return fmt.Errorf("socks: connect failed with code %d", header[1])
+	}
+	var skip int
+	switch header[3] {
+	case socks5IPv4:
+		skip = net.IPv4len
+	case socks5IPv6:
+		skip = net.IPv6len
+	case socks5Domain:
+		length := make([]byte, 1)
+		if _, err := io.ReadFull(conn, length); err != nil {
+			return err
+		}
+		skip = int(length[0])
+	default:
+		return fmt.Errorf("socks: unsupported address type %d", header[3])
+	}
+	// 绑定地址和端口
+	_, err = io.ReadFull(conn, make([]byte, skip+2))
+	return err
+}
@@ -0,0 +1,228 @@
+package ssh
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+)
+
+// testProxy 记录代理收到的连接数和最后一次请求的目标地址
+type testProxy struct {
+	addr   string
+	conns  int32
+	target atomic.Value
+}
+
+func serveProxy(t *testing.T, handle func(p *testProxy, conn net.Conn) net.Conn) *testProxy {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { listener.Close() })
+	p := &testProxy{addr: listener.Addr().String()}
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			atomic.AddInt32(&p.conns, 1)
+			go func() {
+				defer conn.Close()
+				if remote := handle(p, conn); remote != nil {
+					defer remote.Close()
+					go io.Copy(remote, conn)
+					io.Copy(conn, remote)
+				}
+			}()
+		}
+	}()
+	return p
+}
+
+// httpProxy 要求 Basic 认证的 HTTP CONNECT 代理
+func httpProxy(t *testing.T, user, password string) *testProxy {
+	return serveProxy(t, func(p *testProxy, conn net.Conn) net.Conn {
+		req, err := http.ReadRequest(bufio.NewReader(conn))
+		if err != nil || req.Method != http.MethodConnect {
+			io.WriteString(conn, "HTTP/1.1 405 Method Not Allowed\r\n\r\n")
+			return nil
+		}
+		p.target.Store(req.Host)
+		if u, pw, ok := (&http.Request{Header: http.Header{"Authorization": req.Header["Proxy-Authorization"]}}).BasicAuth(); !ok || u != user || pw != password {
+			io.WriteString(conn, "HTTP/1.1 407 Proxy Authentication Required\r\n\r\n")
+			return nil
+		}
+		remote, err := net.Dial("tcp", req.Host)
+		if err != nil {
+			io.WriteString(conn, "HTTP/1.1 502 Bad Gateway\r\n\r\n")
+			return nil
+		}
+		io.WriteString(conn, "HTTP/1.1 200 Connection established\r\n\r\n")
+		return remote
+	})
+}
+
+// socksProxy 要求用户名密码认证的 SOCKS5 代理
+func socksProxy(t *testing.T, user, password string) *testProxy {
+	return serveProxy(t, func(p *testProxy, conn net.Conn) net.Conn {
+		r := bufio.NewReader(conn)
+		header := make([]byte, 2)
+		io.ReadFull(r, header)
+		io.ReadFull(r, make([]byte, header[1]))
+		conn.Write([]byte{socks5Version, socks5UserPass})
+
+		io.ReadFull(r, header)
+		u := make([]byte, header[1])
+		io.ReadFull(r, u)
+		n, _ := r.ReadByte()
+		pw := make([]byte, n)
+		io.ReadFull(r, pw)
+		if string(u) != user || string(pw) != password {
+			conn.Write([]byte{socks5UserPassVer, 0x01})
+			return nil
+		}
+		conn.Write([]byte{socks5UserPassVer, socks5UserPassStatus})
+
+		request := make([]byte, 4)
+		io.ReadFull(r, request)
+		var host string
+		switch request[3] {
+		case socks5IPv4:
+			ip := make([]byte, net.IPv4len)
+			io.ReadFull(r, ip)
+			host = net.IP(ip).String()
+		case socks5Domain:
+			n, _ := r.ReadByte()
+			domain := make([]byte, n)
+			io.ReadFull(r, domain)
+			host = string(domain)
+		}
+		port := make([]byte, 2)
+		io.ReadFull(r, port)
+		target := net.JoinHostPort(host, strconv.Itoa(int(binary.BigEndian.Uint16(port))))
+		p.target.Store(target)
+		remote, err := net.Dial("tcp", target)
+		if err != nil {
+			socks5Reply(conn, socks5HostUnreachable)
+			return nil
+		}
+		socks5Reply(conn, socks5Succeeded)
+		return remote
+	})
+}
+
+func TestNetworkConfig_HTTPProxy(t *testing.T) {
+	proxy := httpProxy(t, "alice", "s3cret")
+	conf := newTestServer(t)
+	conf.Proxy = "http://alice:s3cret@" + proxy.addr
+	cli := newTestClient(t, conf)
+	if err := cli.Run("true", io.Discard, io.Discard); err != nil {
+		t.Fatal(err)
+	}
+	if proxy.target.Load() != conf.Address {
+		t.Errorf("proxy target %v, want %s", proxy.target.Load(), conf.Address)
+	}
+
+	conf.Proxy = "http://alice:wrong@" + proxy.addr
+	_, err := NewClient(conf)
+	if err == nil || !strings.Contains(err.Error(), "407") {
+		t.Errorf("want 407 error, got %v", err)
+	}
+	if err != nil && strings.Contains(err.Error(), "wrong") {
+		t.Errorf("proxy password leaked in error: %v", err)
+	}
+}
+
+func TestNetworkConfig_SOCKS5Proxy(t *testing.T) {
+	proxy := socksProxy(t, "bob", "pw")
+	conf := newTestServer(t)
+	conf.Proxy = "socks5://bob:pw@" + proxy.addr
+	newTestClient(t, conf)
+	if proxy.target.Load() != conf.Address {
+		t.Errorf("proxy target %v, want %s", proxy.target.Load(), conf.Address)
+	}
+
+	// socks5h 把域名交给代理解析
+	_, port, _ := net.SplitHostPort(conf.Address)
+	conf.Address = net.JoinHostPort("localhost", port)
+	conf.Proxy = "socks5h://bob:pw@" + proxy.addr
+	newTestClient(t, conf)
+	if proxy.target.Load() != conf.Address {
+		t.Errorf("socks5h should send the host name, proxy got %v", proxy.target.Load())
+	}
+
+	conf.Proxy = "socks5://bob:bad@" + proxy.addr
+	if _, err := NewClient(conf); err == nil || !strings.Contains(err.Error(), "authentication failed") {
+		t.Errorf("want authentication error, got %v", err)
+	}
+}
+
+func TestNetworkConfig_ProxyFromEnv(t *testing.T) {
+	proxy := socksProxy(t, "bob", "pw")
+	conf := newTestServer(t)
+	t.Setenv("ALL_PROXY", "socks5://bob:pw@"+proxy.addr)
+	t.Setenv("NO_PROXY", "")
+
+	newTestClient(t, conf)
+	if atomic.LoadInt32(&proxy.conns) != 0 {
+		t.Error("environment proxy should be ignored unless ProxyFromEnv is set")
+	}
+	conf.ProxyFromEnv = true
+	newTestClient(t, conf)
+	if atomic.LoadInt32(&proxy.conns) != 1 {
+		t.Errorf("want 1 proxy connection, got %d", atomic.LoadInt32(&proxy.conns))
+	}
+	t.Setenv("NO_PROXY", "example.com,127.0.0.0/8")
+	newTestClient(t, conf)
+	if atomic.LoadInt32(&proxy.conns) != 1 {
+		t.Error("NO_PROXY address should be dialed directly")
+	}
+}
+
+func TestNetworkConfig_Dial(t *testing.T) {
+	conf := newTestServer(t)
+	var dialed []string
+	conf.Dial = func(ctx context.Context, network, address string) (net.Conn, error) {
+		dialed = append(dialed, address)
+		return (&net.Dialer{}).DialContext(ctx, network, address)
+	}
+	newTestClient(t, conf)
+
+	// 使用代理时通过 Dial 连接代理服务器
+	proxy := httpProxy(t, "alice", "s3cret")
+	conf.Proxy = "http://alice:s3cret@" + proxy.addr
+	newTestClient(t, conf)
+	if len(dialed) != 2 || dialed[0] != conf.Address || dialed[1] != proxy.addr {
+		t.Errorf("unexpected dials: %v", dialed)
+	}
+}
+
+func TestNoProxy(t *testing.T) {
+	list := "localhost, .corp.example.com, example.org:2222, 10.0.0.0/8, ::1"
+	for address, want := range map[string]bool{
+		"localhost:22":           true,
+		"db.corp.example.com:22": true,
+		"corp.example.com:22":    true,
+		"example.org:2222":       true,
+		"example.org:22":         false,
+		"10.1.2.3:22":            true,
+		"[::1]:22":               true,
+		"192.168.1.1:22":         false,
+		"notcorp.example.com:22": false,
+	} {
+		if got := noProxy(address, list); got != want {
+			t.Errorf("noProxy(%s) = %v, want %v", address, got, want)
+		}
+	}
+	if !noProxy("anything:22", "*") {
+		t.Error("* should match every host")
+	}
+}
@@ -0,0 +1,308 @@
+package ssh
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+// CastHeader asciicast v2 文件的第一行
+type CastHeader struct {
+	Version   int   `json:"version"`
+	Width     int   `json:"width"`
+	Height    int   `json:"height"`
+	Timestamp int64 `json:"timestamp,omitempty"`
+	// IdleTimeLimit 回放时最长的空闲时间（秒），0 表示不限制
+	IdleTimeLimit float64           `json:"idle_time_limit,omitempty"`
+	Title         string            `json:"title,omitempty"`
+	Env           map[string]string `json:"env,omitempty"`
+}
+
+// CastEvent asciicast v2 的事件，编码为 [time, type, data]。
+// Type 为 "o" 输出、"i" 输入、"r" 窗口大小变化（data 为 "宽x高"）
+type CastEvent struct {
+	Time float64
+	Type string
+	Data string
+}
+
+func (e CastEvent) MarshalJSON() ([]byte, error) {
+	return json.Marshal([]interface{}{e.Time, e.Type, e.Data})
+}
+
+func (e *CastEvent) UnmarshalJSON(b []byte) error {
+	var fields []json.RawMessage
+	if err := json.Unmarshal(b, &fields); err != nil {
+		return err
+	}
+	if len(fields) != 3 {
+		return fmt.Errorf("invalid asciicast event: %s", b)
+	}
+	if err := json.Unmarshal(fields[0], &e.Time); err != nil {
+		return err
+	}
+	if err := json.Unmarshal(fields[1], &e.Type); err != nil {
+		return err
+	}
+	return json.Unmarshal(fields[2], &e.Data)
+}
+
+// Recorder 把终端会话按 asciicast v2 格式写入 w，可以并发调用。
+// 写入失败不会影响会话本身，错误通过 Err 获取
+type Recorder struct {
+	mu    sync.Mutex
+	w     io.Writer
+	start time.Time
+	err   error
+	out   castWriter
+	in    castWriter
+}
+
+// NewRecorder 写入文件头，header.Version 固定为 2，Timestamp 为 0 时使用当前时间
+func NewRecorder(w io.Writer, header CastHeader) (*Recorder, error) {
+	r := &Recorder{w: w, start: time.Now()}
+	r.out = castWriter{r: r, kind: "o"}
+	r.in = castWriter{r: r, kind: "i"}
+	header.Version = 2
+	if header.Timestamp == 0 {
+		header.Timestamp = r.start.Unix()
+	}
+	b, err := json.Marshal(header)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := w.Write(append(b, '\n')); err != nil {
+		return nil, fmt.Errorf("write asciicast header error: %w", err)
+	}
+	return r, nil
+}
+
+// Output 记录终端输出的 io.Writer
+func (r *Recorder) Output() io.Writer {
+	return &r.out
+}
+
+// Input 记录键盘输入的 io.Writer
+func (r *Recorder) Input() io.Writer {
+	return &r.in
+}
+
+// Resize 记录窗口大小变化
+func (r *Recorder) Resize(width, height int) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.event("r", fmt.Sprintf("%dx%d", width, height))
+}
+
+// Err 第一次写入失败的错误
+func (r *Recorder) Err() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.err
+}
+
+// event 调用方持有 mu，时间精确到微秒
+func (r *Recorder) event(kind, data string) {
+	if r.err != nil {
+		return
+	}
+	elapsed := math.Round(time.Since(r.start).Seconds()*1e6) / 1e6
+	b, err := json.Marshal(CastEvent{Time: elapsed, Type: kind, Data: data})
+	if err == nil {
+		_, err = r.w.Write(append(b, '\n'))
+	}
+	r.err = err
+}
+
+// castWriter 一次写入可能在多字节字符中间截断，不完整的字符留到下一次写入，保证事件是合法的 UTF-8
+type castWriter struct {
+	r       *Recorder
+	kind    string
+	pending []byte
+}
+
+func (w *castWriter) Write(p []byte) (int, error) {
+	w.r.mu.Lock()
+	defer w.r.mu.Unlock()
+	data := append(w.pending, p...)
+	n := len(data) - incompleteRune(data)
+	if n > 0 {
+		w.r.event(w.kind, string(data[:n]))
+	}
+	w.pending = append([]byte(nil), data[n:]...)
+	return len(p), nil
+}
+
+// incompleteRune 末尾不完整的 UTF-8 字符的字节数
+func incompleteRune(b []byte) int {
+	for i := 1; i <= utf8.UTFMax-1 && i <= len(b); i++ {
+		c := b[len(b)-i]
+		if utf8.RuneStart(c) {
+			if !utf8.FullRune(b[len(b)-i:]) {
+				return i
+			}
+			return 0
+		}
+	}
+	return 0
+}
+
+type recordConfig struct {
+	create func() (io.WriteCloser, error)
+	input  bool
+}
+
+// startRecording 没有设置录像时返回 nil
+func (c *ClientType) startRecording(width, height int, termType string) (*Recorder, func(), error) {
+	if c.record == nil {
+		return nil, func() {}, nil
+	}
+	w, err := c.record.create()
+	if err != nil {
+		return nil, nil, fmt.Errorf("create recording error: %w", err)
+	}
+	env := map[string]string{"TERM": termType}
+	if shell, ok := os.LookupEnv("SHELL"); ok {
+		env["SHELL"] = shell
+	}
+	rec, err := NewRecorder(w, CastHeader{Width: width, Height: height, Env: env})
+	if err != nil {
+		w.Close()
+		return nil, nil, err
+	}
+	return rec, func() { w.Close() }, nil
+}
+
+// WithRecording 把每次 Login 的终端输出按 asciicast v2 格式保存到 dir 下，文件名为开始时间，
+// input 为 true 时同时记录键盘输入（包括输入的密码）
+func WithRecording(dir string, input bool) Option {
+	return WithRecordWriter(func() (io.WriteCloser, error) {
+		if err := os.MkdirAll(dir, 0700); err != nil {
+			return nil, err
+		}
+		name := filepath.Join(dir, time.Now().Format("20060102-150405.000000")+".cast")
+		return os.OpenFile(name, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	}, input)
+}
+
+// WithRecordWriter 每次 Login 调用 create 获取录像的输出，会话结束后关闭，nil 表示不录像
+func WithRecordWriter(create func() (io.WriteCloser, error), input bool) Option {
+	return func(c *ClientType) {
+		if create == nil {
+			c.record = nil
+			return
+		}
+		c.record = &recordConfig{create: create, input: input}
+	}
+}
+
+type replayer struct {
+	speed   float64
+	maxIdle time.Duration
+	resize  bool
+}
+
+type ReplayOption func(*replayer)
+
+// WithReplaySpeed 回放速度倍数，默认 1
+func WithReplaySpeed(speed float64) ReplayOption {
+	return func(r *replayer) {
+		if speed > 0 {
+			r.speed = speed
+		}
+	}
+}
+
+// WithReplayMaxIdle 事件之间最长等待的时间，默认使用文件头的 idle_time_limit
+func WithReplayMaxIdle(d time.Duration) ReplayOption {
+	return func(r *replayer) {
+		r.maxIdle = d
+	}
+}
+
+// WithReplayResize 遇到窗口大小变化时输出 xterm 调整窗口大小的控制序列
+func WithReplayResize(resize bool) ReplayOption {
+	return func(r *replayer) {
+		r.resize = resize
+	}
+}
+
+// Replay 按录制时的时间间隔把 asciicast v2 录像的输出写入 w，输入事件不回放。ctx 取消时停止
+func Replay(ctx context.Context, r io.Reader, w io.Writer, option ...ReplayOption) error {
+	p := &replayer{speed: 1}
+	for _, opt := range option {
+		opt(p)
+	}
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	if !scanner.Scan() {
+		if err := scanner.Err(); err != nil {
+			return err
+		}
+		return errors.New("empty asciicast file")
+	}
+	var header CastHeader
+	if err := json.Unmarshal(scanner.Bytes(), &header); err != nil {
+		return fmt.Errorf("invalid asciicast header: %w", err)
+	}
+	if header.Version != 2 {
+		return fmt.Errorf("unsupported asciicast version %d", header.Version)
+	}
+	maxIdle := p.maxIdle
+	if maxIdle == 0 && header.IdleTimeLimit > 0 {
+		maxIdle = time.Duration(header.IdleTimeLimit * float64(time.Second))
+	}
+
+	timer := time.NewTimer(0)
+	defer timer.Stop()
+	<-timer.C
+	last := 0.0
+	for scanner.Scan() {
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+		var event CastEvent
+		if err := json.Unmarshal(scanner.Bytes(), &event); err != nil {
+			return err
+		}
+		delay := time.Duration((event.Time - last) * float64(time.Second))
+		last = event.Time
+		if maxIdle > 0 && delay > maxIdle {
+			delay = maxIdle
+		}
+		if delay = time.Duration(float64(delay) / p.speed); delay > 0 {
+			timer.Reset(delay)
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-timer.C:
+			}
+		} else if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		switch event.Type {
+		case "o":
+			if _, err := io.WriteString(w, event.Data); err != nil {
+				return err
+			}
+		case "r":
+			var width, height int
+			if _, err := fmt.Sscanf(event.Data, "%dx%d", &width, &height); err == nil && p.resize {
+				if _, err := fmt.Fprintf(w, "\x1b[8;%d;%dt", height, width); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	return scanner.Err()
+}
@@ -0,0 +1,89 @@
+package ssh
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRecorder(t *testing.T) {
+	var buf bytes.Buffer
+	rec, err := NewRecorder(&buf, CastHeader{Width: 80, Height: 24, Env: map[string]string{"TERM": "xterm"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	out := rec.Output()
+	// "你" 的 UTF-8 编码被拆成两次写入
+	word := []byte("你")
+	out.Write(append([]byte("$ ls\r\n"), word[:1]...))
+	out.Write(word[1:])
+	rec.Input().Write([]byte("q"))
+	rec.Resize(100, 30)
+	if rec.Err() != nil {
+		t.Fatal(rec.Err())
+	}
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 5 {
+		t.Fatalf("lines = %q", lines)
+	}
+	var header CastHeader
+	if err := json.Unmarshal([]byte(lines[0]), &header); err != nil || header.Version != 2 || header.Width != 80 || header.Timestamp == 0 {
+		t.Errorf("header = %+v, %v", header, err)
+	}
+	want := []CastEvent{{Type: "o", Data: "$ ls\r\n"}, {Type: "o", Data: "你"}, {Type: "i", Data: "q"}, {Type: "r", Data: "100x30"}}
+	for i, line := range lines[1:] {
+		var event CastEvent
+		if err := json.Unmarshal([]byte(line), &event); err != nil {
+			t.Fatal(err)
+		}
+		if event.Type != want[i].Type || event.Data != want[i].Data || event.Time < 0 {
+			t.Errorf("event %d = %+v", i, event)
+		}
+	}
+}
+
+func TestReplay(t *testing.T) {
+	cast := `{"version":2,"width":80,"height":24,"idle_time_limit":0.01}
+[0.5,"o","hello "]
+[0.6,"i","x"]
+[0.7,"r","100x30"]
+[60,"o","world"]
+`
+	var out bytes.Buffer
+	start := time.Now()
+	if err := Replay(context.Background(), strings.NewReader(cast), &out, WithReplaySpeed(10), WithReplayResize(true)); err != nil {
+		t.Fatal(err)
+	}
+	// 60 秒的空闲被 idle_time_limit 限制
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("replay took %v", elapsed)
+	}
+	if out.String() != "hello \x1b[8;30;100tworld" {
+		t.Errorf("output = %q", out.String())
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	err := Replay(ctx, strings.NewReader(`{"version":2,"width":80,"height":24}`+"\n"+`[10,"o","late"]`), &out)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("err = %v", err)
+	}
+
+	if err := Replay(context.Background(), strings.NewReader(`{"version":1}`), &out); err == nil {
+		t.Error("version 1 should be rejected")
+	}
+}
+
+func TestIncompleteRune(t *testing.T) {
+	word := []byte("a你")
+	for i, want := range []int{0, 1, 2, 0} {
+		if got := incompleteRune(word[:i+1]); got != want {
+			t.Errorf("incompleteRune(%q) = %d, want %d", word[:i+1], got, want)
+		}
+	}
+}
@@ -0,0 +1,230 @@
+package ssh
+
+import (
+	"errors"
+	"github.com/pkg/sftp"
+	"io/fs"
+	"os"
+	"path"
+	"sort"
+	"syscall"
+)
+
+// DiskUsage 远程路径所在文件系统的空间，单位为字节
+type DiskUsage struct {
+	Total uint64
+	Free  uint64
+	// Available 非 root 用户可用的空间
+	Available uint64
+	Used      uint64
+}
+
+// ErrStatVFSUnsupported 服务端不支持 statvfs@openssh.com 扩展，无法获取磁盘空间
+var ErrStatVFSUnsupported = errors.New("statvfs@openssh.com extension unsupported")
+
+// pathError 文件操作的错误统一为 *fs.PathError，文件不存在和没有权限的状态码转换为
+// fs.ErrNotExist 和 fs.ErrPermission，可以用 errors.Is 判断
+func pathError(op, name string, err error) error {
+	if err == nil {
+		return nil
+	}
+	var status *sftp.StatusError
+	if errors.As(err, &status) {
+		switch status.FxCode() {
+		case sftp.ErrSSHFxNoSuchFile:
+			err = fs.ErrNotExist
+		case sftp.ErrSSHFxPermissionDenied:
+			err = fs.ErrPermission
+		}
+	}
+	return &fs.PathError{Op: op, Path: name, Err: err}
+}
+
+// withSftp 打开一个 sftp session 执行 fn，结束后归还
+func (c *ClientType) withSftp(fn func(cli *sftp.Client) error) error {
+	session, err := c.newSftpClient()
+	if err != nil {
+		return err
+	}
+	defer session.Close()
+	return fn(session.Client)
+}
+
+// Stat 返回远程文件信息，符号链接返回指向的文件
+func (c *ClientType) Stat(name string) (info os.FileInfo, err error) {
+	err = c.withSftp(func(cli *sftp.Client) error {
+		info, err = cli.Stat(remoteRealpath(name, cli))
+		return pathError("stat", name, err)
+	})
+	return info, err
+}
+
+// Lstat 同 Stat，但符号链接返回链接本身的信息
+func (c *ClientType) Lstat(name string) (info os.FileInfo, err error) {
+	err = c.withSftp(func(cli *sftp.Client) error {
+		info, err = cli.Lstat(remoteRealpath(name, cli))
+		return pathError("lstat", name, err)
+	})
+	return info, err
+}
+
+// ReadDir 列出远程目录，按文件名排序
+func (c *ClientType) ReadDir(name string) (infos []os.FileInfo, err error) {
+	err = c.withSftp(func(cli *sftp.Client) error {
+		infos, err = cli.ReadDir(remoteRealpath(name, cli))
+		return pathError("readdir", name, err)
+	})
+	sort.Slice(infos, func(i, j int) bool {
+		return infos[i].Name() < infos[j].Name()
+	})
+	return infos, err
+}
+
+// MkdirAll 同 mkdir -p 创建目录及所有不存在的上级目录，并设置为 mode，目录已存在时不修改权限
+func (c *ClientType) MkdirAll(name string, mode os.FileMode) error {
+	return c.withSftp(func(cli *sftp.Client) error {
+		return mkdirRemoteAll(cli, remoteRealpath(name, cli), name, mode)
+	})
+}
+
+func mkdirRemoteAll(cli *sftp.Client, p, name string, mode os.FileMode) error {
+	info, err := cli.Stat(p)
+	if err == nil {
+		if info.IsDir() {
+			return nil
+		}
+		return &fs.PathError{Op: "mkdir", Path: name, Err: syscall.ENOTDIR}
+	}
+	if parent := path.Dir(p); parent != p {
+		if err := mkdirRemoteAll(cli, parent, name, mode); err != nil {
+			return err
+		}
+	}
+	if err := cli.Mkdir(p); err != nil {
+		// 并发创建同一个目录
+		if info, statErr := cli.Stat(p); statErr == nil && info.IsDir() {
+			return nil
+		}
+		return pathError("mkdir", name, err)
+	}
+	return pathError("chmod", name, cli.Chmod(p, mode))
+}
+
+// RemoveAll 同 rm -r 删除文件或目录及其中的所有内容，不跟随符号链接。路径不存在时返回 nil
+func (c *ClientType) RemoveAll(name string) error {
+	return c.withSftp(func(cli *sftp.Client) error {
+		err := removeRemoteAll(cli, remoteRealpath(name, cli))
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil
+		}
+		return err
+	})
+}
+
+func removeRemoteAll(cli *sftp.Client, p string) error {
+	info, err := cli.Lstat(p)
+	if err != nil {
+		return pathError("remove", p, err)
+	}
+	if !info.IsDir() {
+		return pathError("remove", p, cli.Remove(p))
+	}
+	infos, err := cli.ReadDir(p)
+	if err != nil {
+		return pathError("readdir", p, err)
+	}
+	for _, child := range infos {
+		if err := removeRemoteAll(cli, path.Join(p, child.Name())); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return err
+		}
+	}
+	return pathError("remove", p, cli.RemoveDirectory(p))
+}
+
+// Glob 返回匹配 pattern 的远程路径，语法同 path.Match，没有匹配时返回空列表
+func (c *ClientType) Glob(pattern string) (matches []string, err error) {
+	err = c.withSftp(func(cli *sftp.Client) error {
+		matches, err = cli.Glob(remoteRealpath(pattern, cli))
+		if err != nil {
+			return pathError("glob", pattern, err)
+		}
+		return nil
+	})
+	return matches, err
+}
+
+// Chmod 修改远程文件权限
+func (c *ClientType) Chmod(name string, mode os.FileMode) error {
+	return c.withSftp(func(cli *sftp.Client) error {
+		return pathError("chmod", name, cli.Chmod(remoteRealpath(name, cli), mode))
+	})
+}
+
+// Chown 修改远程文件的 uid 和 gid
+func (c *ClientType) Chown(name string, uid, gid int) error {
+	return c.withSftp(func(cli *sftp.Client) error {
+		return pathError("chown", name, cli.Chown(remoteRealpath(name, cli), uid, gid))
+	})
+}
+
+// Rename 重命名远程文件，newname 已存在时覆盖。服务端支持 posix-rename@openssh.com 时原子替换，
+// 否则先删除 newname 再重命名；newname 是目录时只能替换空目录
+func (c *ClientType) Rename(oldname, newname string) error {
+	return c.withSftp(func(cli *sftp.Client) error {
+		src, dst := remoteRealpath(oldname, cli), remoteRealpath(newname, cli)
+		if _, ok := cli.HasExtension("posix-rename@openssh.com"); ok {
+			return pathError("rename", oldname, cli.PosixRename(src, dst))
+		}
+		if _, err := cli.Stat(src); err != nil {
+			return pathError("rename", oldname, err)
+		}
+		if info, err := cli.Lstat(dst); err == nil {
+			if info.IsDir() {
+				err = cli.RemoveDirectory(dst)
+			} else {
+				err = cli.Remove(dst)
+			}
+			if err != nil {
+				return pathError("rename", newname, err)
+			}
+		}
+		return pathError("rename", oldname, cli.Rename(src, dst))
+	})
+}
+
+// Readlink 返回符号链接指向的路径
+func (c *ClientType) Readlink(name string) (target string, err error) {
+	err = c.withSftp(func(cli *sftp.Client) error {
+		target, err = cli.ReadLink(remoteRealpath(name, cli))
+		return pathError("readlink", name, err)
+	})
+	return target, err
+}
+
+// Symlink 创建指向 oldname 的符号链接 newname，oldname 原样写入链接，不做 ~ 展开
+func (c *ClientType) Symlink(oldname, newname string) error {
+	return c.withSftp(func(cli *sftp.Client) error {
+		return pathError("symlink", newname, cli.Symlink(oldname, remoteRealpath(newname, cli)))
+	})
+}
+
+// DiskUsage 返回 name 所在文件系统的空间使用情况，需要服务端支持 statvfs@openssh.com 扩展
+func (c *ClientType) DiskUsage(name string) (usage *DiskUsage, err error) {
+	err = c.withSftp(func(cli *sftp.Client) error {
+		if _, ok := cli.HasExtension("statvfs@openssh.com"); !ok {
+			return &fs.PathError{Op: "statvfs", Path: name, Err: ErrStatVFSUnsupported}
+		}
+		vfs, err := cli.StatVFS(remoteRealpath(name, cli))
+		if err != nil {
+			return pathError("statvfs", name, err)
+		}
+		usage = &DiskUsage{
+			Total:     vfs.TotalSpace(),
+			Free:      vfs.FreeSpace(),
+			Available: vfs.Bavail * vfs.Frsize,
+			Used:      (vfs.Blocks - vfs.Bfree) * vfs.Frsize,
+		}
+		return nil
+	})
+	return usage, err
+}
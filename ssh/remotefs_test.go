@@ -0,0 +1,143 @@
+package ssh
+
+import (
+	"errors"
+	"github.com/pkg/sftp"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestPathError(t *testing.T) {
+	if pathError("stat", "a", nil) != nil {
+		t.Error("nil error should stay nil")
+	}
+	if err := pathError("stat", "a", os.ErrNotExist); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("want not exist, got %v", err)
+	}
+	if err := pathError("remove", "a", &sftp.StatusError{Code: 2}); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("want not exist, got %v", err)
+	}
+	err := pathError("chmod", "/etc/shadow", &sftp.StatusError{Code: 3})
+	var pathErr *fs.PathError
+	if !errors.Is(err, fs.ErrPermission) || !errors.As(err, &pathErr) || pathErr.Op != "chmod" || pathErr.Path != "/etc/shadow" {
+		t.Errorf("want permission path error, got %#v", err)
+	}
+	if err := pathError("mkdir", "a", &sftp.StatusError{Code: 4}); errors.Is(err, fs.ErrNotExist) || errors.Is(err, fs.ErrPermission) {
+		t.Errorf("generic failure should not be typed, got %v", err)
+	}
+}
+
+func TestClientType_RemoteFS(t *testing.T) {
+	cli := newTestClient(t, newTestServer(t))
+	dir := t.TempDir()
+
+	nested := filepath.Join(dir, "a", "b", "c")
+	if err := cli.MkdirAll(nested, 0750); err != nil {
+		t.Fatal(err)
+	}
+	if err := cli.MkdirAll(nested, 0750); err != nil {
+		t.Errorf("MkdirAll on existing directory: %v", err)
+	}
+	info, err := cli.Stat(nested)
+	if err != nil || !info.IsDir() || info.Mode().Perm() != 0750 {
+		t.Fatalf("stat %s: %v, %v", nested, info, err)
+	}
+	file := filepath.Join(dir, "a", "file.txt")
+	if err := os.WriteFile(file, []byte("hello"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := cli.MkdirAll(filepath.Join(file, "sub"), 0755); err == nil {
+		t.Error("MkdirAll under a file should fail")
+	}
+
+	if err := cli.Chmod(file, 0600); err != nil {
+		t.Fatal(err)
+	}
+	if info, err := os.Stat(file); err != nil || info.Mode().Perm() != 0600 {
+		t.Errorf("chmod: %v, %v", info.Mode(), err)
+	}
+	if err := cli.Chown(file, os.Getuid(), os.Getgid()); err != nil {
+		t.Errorf("chown to self: %v", err)
+	}
+
+	infos, err := cli.ReadDir(filepath.Join(dir, "a"))
+	if err != nil || len(infos) != 2 || infos[0].Name() != "b" || infos[1].Name() != "file.txt" {
+		t.Errorf("ReadDir = %v, %v", infos, err)
+	}
+	matches, err := cli.Glob(filepath.Join(dir, "a", "*.txt"))
+	if err != nil || !reflect.DeepEqual(matches, []string{file}) {
+		t.Errorf("Glob = %v, %v", matches, err)
+	}
+
+	link := filepath.Join(dir, "link")
+	if err := cli.Symlink(file, link); err != nil {
+		t.Fatal(err)
+	}
+	if target, err := cli.Readlink(link); err != nil || target != file {
+		t.Errorf("Readlink = %q, %v", target, err)
+	}
+	if info, err := cli.Lstat(link); err != nil || info.Mode()&fs.ModeSymlink == 0 {
+		t.Errorf("Lstat should not follow the link: %v, %v", info, err)
+	}
+
+	// 覆盖已存在的文件
+	other := filepath.Join(dir, "other.txt")
+	if err := os.WriteFile(other, []byte("old"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := cli.Rename(file, other); err != nil {
+		t.Fatal(err)
+	}
+	if data, err := os.ReadFile(other); err != nil || string(data) != "hello" {
+		t.Errorf("rename should overwrite: %q, %v", data, err)
+	}
+
+	usage, err := cli.DiskUsage(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if usage.Total == 0 || usage.Free > usage.Total || usage.Used > usage.Total {
+		t.Errorf("unexpected disk usage: %+v", usage)
+	}
+
+	// 删除目录时不跟随指向外部的符号链接
+	if err := cli.Symlink(other, filepath.Join(nested, "escape")); err != nil {
+		t.Fatal(err)
+	}
+	if err := cli.RemoveAll(filepath.Join(dir, "a")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "a")); !os.IsNotExist(err) {
+		t.Errorf("directory should be removed: %v", err)
+	}
+	if _, err := os.Stat(other); err != nil {
+		t.Errorf("symlink target should be kept: %v", err)
+	}
+	if err := cli.RemoveAll(filepath.Join(dir, "a")); err != nil {
+		t.Errorf("RemoveAll on a missing path: %v", err)
+	}
+}
+
+func TestClientType_RemoteFSNotExist(t *testing.T) {
+	cli := newTestClient(t, newTestServer(t))
+	missing := filepath.Join(t.TempDir(), "missing")
+
+	_, statErr := cli.Stat(missing)
+	_, readErr := cli.ReadDir(missing)
+	_, linkErr := cli.Readlink(missing)
+	for op, err := range map[string]error{
+		"stat":     statErr,
+		"readdir":  readErr,
+		"readlink": linkErr,
+		"chmod":    cli.Chmod(missing, 0644),
+		"rename":   cli.Rename(missing, missing+".new"),
+	} {
+		var pathErr *fs.PathError
+		if !errors.Is(err, fs.ErrNotExist) || !errors.As(err, &pathErr) || pathErr.Path != missing {
+			t.Errorf("%s: want not exist error for %s, got %v", op, missing, err)
+		}
+	}
+}
@@ -3,17 +3,24 @@
 package ssh
 
 import (
+	"golang.org/x/crypto/ssh"
 	terminal "golang.org/x/term"
 	"os"
 	"os/signal"
 	"syscall"
 )
 
-func (c *ClientType) updateTerminalSize(fd, termWidth, termHeight int, failed chan error) {
+func (c *ClientType) updateTerminalSize(session *ssh.Session, fd, termWidth, termHeight int, failed chan error, done <-chan struct{}, resized func(width, height int)) {
 	sigwinchCh := make(chan os.Signal, 1)
 	signal.Notify(sigwinchCh, syscall.SIGWINCH)
+	defer signal.Stop(sigwinchCh)
 
-	for range sigwinchCh {
+	for {
+		select {
+		case <-done:
+			return
+		case <-sigwinchCh:
+		}
 		currTermWidth, currTermHeight, getSizeErr := terminal.GetSize(fd)
 		if getSizeErr != nil {
 			failed <- getSizeErr
@@ -23,10 +30,11 @@ func (c *ClientType) updateTerminalSize(fd, termWidth, termHeight int, failed ch
 			continue
 		}
 
-		if changeErr := c.session.WindowChange(currTermHeight, currTermWidth); changeErr != nil {
+		if changeErr := session.WindowChange(currTermHeight, currTermWidth); changeErr != nil {
 			failed <- changeErr
 			continue
 		}
 		termWidth, termHeight = currTermWidth, currTermHeight
+		resized(termWidth, termHeight)
 	}
 }
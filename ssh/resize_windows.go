@@ -3,13 +3,22 @@
 package ssh
 
 import (
+	"golang.org/x/crypto/ssh"
 	terminal "golang.org/x/term"
 	"time"
 )
 
 // windows not support Terminal Resize
-func (c *ClientType) updateTerminalSize(fd, termWidth, termHeight int, failed chan error) {
-	for range time.Tick(time.Second) {
+func (c *ClientType) updateTerminalSize(session *ssh.Session, fd, termWidth, termHeight int, failed chan error, done <-chan struct{}, resized func(width, height int)) {
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+		}
 		currTermWidth, currTermHeight, getSizeErr := terminal.GetSize(fd)
 		if getSizeErr != nil {
 			failed <- getSizeErr
@@ -19,10 +28,11 @@ func (c *ClientType) updateTerminalSize(fd, termWidth, termHeight int, failed ch
 			continue
 		}
 
-		if changeErr := c.session.WindowChange(currTermHeight, currTermWidth); changeErr != nil {
+		if changeErr := session.WindowChange(currTermHeight, currTermWidth); changeErr != nil {
 			failed <- changeErr
 			continue
 		}
 		termWidth, termHeight = currTermWidth, currTermHeight
+		resized(termWidth, termHeight)
 	}
 }
@@ -0,0 +1,160 @@
+package ssh
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"golang.org/x/crypto/ssh"
+	"io"
+	"time"
+)
+
+// DefaultKillGrace RunContext 被取消后，从发送 TERM 到发送 KILL 之间的等待时间
+const DefaultKillGrace = 5 * time.Second
+
+// RunError 远程命令执行失败
+type RunError struct {
+	Cmd string
+	// ExitStatus 远程进程的退出码，没有拿到退出码时为 -1
+	ExitStatus int
+	// Signal 远程进程被信号终止时的信号名，如 TERM、KILL
+	Signal string
+	// Timeout context 超时导致命令被终止
+	Timeout bool
+	// Canceled context 被取消导致命令被终止（包括超时）
+	Canceled bool
+	err      error
+}
+
+func (e *RunError) Error() string {
+	msg := fmt.Sprintf("run %q", e.Cmd)
+	switch {
+	case e.Timeout:
+		msg += " timeout"
+	case e.Canceled:
+		msg += " canceled"
+	}
+	if e.Signal != "" {
+		msg += fmt.Sprintf(", killed by signal %s", e.Signal)
+	} else if e.ExitStatus >= 0 {
+		msg += fmt.Sprintf(", exit status %d", e.ExitStatus)
+	}
+	if e.err != nil {
+		msg += fmt.Sprintf(": %v", e.err)
+	}
+	return msg
+}
+
+// Unwrap 返回 context 的错误或 *ssh.ExitError 等底层错误
+func (e *RunError) Unwrap() error {
+	return e.err
+}
+
+// newRunError sent 为 ctx 结束后最后发送的信号，closed 表示 session 被强制关闭。
+// 只有没拿到退出状态时才认为进程被 sent 终止，进程收到 TERM 后正常退出时保留真实的退出码
+func newRunError(cmd string, waitErr, ctxErr error, sent ssh.Signal, closed bool) error {
+	if waitErr == nil && ctxErr == nil {
+		return nil
+	}
+	runErr := &RunError{
+		Cmd:        cmd,
+		ExitStatus: -1,
+		Canceled:   ctxErr != nil,
+		Timeout:    errors.Is(ctxErr, context.DeadlineExceeded),
+		err:        waitErr,
+	}
+	var (
+		exitErr    *ssh.ExitError
+		missingErr *ssh.ExitMissingError
+	)
+	switch {
+	case waitErr == nil:
+		runErr.ExitStatus = 0
+	case errors.As(waitErr, &exitErr):
+		runErr.ExitStatus = exitErr.ExitStatus()
+		runErr.Signal = exitErr.Signal()
+	}
+	if ctxErr != nil {
+		// 退出码已经在 ExitStatus/Signal 中，错误链上保留 context 的错误，方便 errors.Is 判断
+		runErr.err = ctxErr
+		if runErr.Signal == "" && (closed || errors.As(waitErr, &missingErr)) {
+			runErr.Signal = string(sent)
+		}
+	}
+	return runErr
+}
+
+// canceledError 命令还没有开始 ctx 就结束了，例如一直在等待 session 配额
+func canceledError(cmd string, ctxErr error) *RunError {
+	return &RunError{
+		Cmd:        cmd,
+		ExitStatus: -1,
+		Canceled:   true,
+		Timeout:    errors.Is(ctxErr, context.DeadlineExceeded),
+		err:        ctxErr,
+	}
+}
+
+// waitContext 等待 session 结束，ctx 结束时先发送 TERM，超过 grace 后发送 KILL 并关闭 session
+func waitContext(ctx context.Context, session *ssh.Session, cmd string, grace time.Duration) error {
+	done := make(chan error, 1)
+	go func() {
+		done <- session.Wait()
+	}()
+
+	select {
+	case err := <-done:
+		return newRunError(cmd, err, nil, "", false)
+	case <-ctx.Done():
+	}
+
+	sent := ssh.SIGTERM
+	session.Signal(sent)
+	timer := time.NewTimer(grace)
+	defer timer.Stop()
+	select {
+	case err := <-done:
+		return newRunError(cmd, err, ctx.Err(), sent, false)
+	case <-timer.C:
+	}
+
+	sent = ssh.SIGKILL
+	session.Signal(sent)
+	timer.Reset(time.Second)
+	select {
+	case err := <-done:
+		return newRunError(cmd, err, ctx.Err(), sent, false)
+	case <-timer.C:
+	}
+
+	// 服务端不支持 signal 请求时直接关闭 session
+	session.Close()
+	return newRunError(cmd, <-done, ctx.Err(), sent, true)
+}
+
+// RunContext 执行远程命令，ctx 取消或超时时向远程进程发送 TERM，
+// 等待 WithKillGrace 设置的时间后发送 KILL 并关闭 session。等待 session 配额时 ctx 结束直接返回。
+// 命令失败时返回 *RunError
+func (c *ClientType) RunContext(ctx context.Context, cmd string, stdout, stderr io.Writer) error {
+	session, sessionErr := c.newSessionContext(ctx)
+	if sessionErr != nil {
+		if ctxErr := ctx.Err(); ctxErr != nil && errors.Is(sessionErr, ctxErr) {
+			return canceledError(cmd, ctxErr)
+		}
+		return sessionErr
+	}
+	defer c.closeSession(session)
+	session.Stdout = stdout
+	session.Stderr = stderr
+	if err := session.Start(cmd); err != nil {
+		return err
+	}
+	return waitContext(ctx, session, cmd, c.killGrace)
+}
+
+// WithKillGrace 设置 RunContext 取消后从 TERM 到 KILL 的等待时间
+func WithKillGrace(d time.Duration) Option {
+	return func(c *ClientType) {
+		c.killGrace = d
+	}
+}
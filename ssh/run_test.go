@@ -0,0 +1,44 @@
+package ssh
+
+import (
+	"context"
+	"errors"
+	"golang.org/x/crypto/ssh"
+	"io"
+	"testing"
+)
+
+func TestNewRunError(t *testing.T) {
+	if err := newRunError("true", nil, nil, "", false); err != nil {
+		t.Fatalf("want nil, got %v", err)
+	}
+
+	err := newRunError("sleep 10", &ssh.ExitMissingError{}, context.DeadlineExceeded, ssh.SIGTERM, false)
+	var runErr *RunError
+	if !errors.As(err, &runErr) {
+		t.Fatalf("want *RunError, got %T", err)
+	}
+	if !runErr.Timeout || !runErr.Canceled || runErr.Signal != "TERM" || runErr.ExitStatus != -1 {
+		t.Errorf("unexpected run error: %+v", runErr)
+	}
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Error("run error should wrap context error")
+	}
+
+	err = newRunError("sleep 10", &ssh.ExitMissingError{}, context.Canceled, ssh.SIGKILL, false)
+	if !errors.As(err, &runErr) || runErr.Timeout || !runErr.Canceled {
+		t.Errorf("unexpected run error: %+v", runErr)
+	}
+
+	// 收到 TERM 后正常退出
+	err = newRunError("trap 'exit 0' TERM; sleep 10", nil, context.Canceled, ssh.SIGTERM, false)
+	if !errors.As(err, &runErr) || runErr.Signal != "" || runErr.ExitStatus != 0 || !runErr.Canceled {
+		t.Errorf("clean exit should keep exit status 0: %+v", runErr)
+	}
+
+	// 服务端不支持 signal，强制关闭 session
+	err = newRunError("sleep 10", io.EOF, context.Canceled, ssh.SIGKILL, true)
+	if !errors.As(err, &runErr) || runErr.Signal != "KILL" || runErr.ExitStatus != -1 {
+		t.Errorf("closed session should report the signal: %+v", runErr)
+	}
+}
@@ -0,0 +1,604 @@
+package ssh
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"golang.org/x/crypto/ssh"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// ErrSftpUnavailable 服务端没有 sftp 子系统。Push、Get 等文件传输会自动改用 SCP，
+// Sync、Open、FS 等只能通过 sftp 实现的接口直接返回这个错误
+var ErrSftpUnavailable = errors.New("sftp subsystem unavailable")
+
+// scpError 对端通过应答 1（警告）或 2（错误）返回的消息，警告只影响当前文件
+type scpError struct {
+	fatal bool
+	msg   string
+}
+
+func (e *scpError) Error() string {
+	return e.msg
+}
+
+// Unwrap 把常见的错误消息转换为 os.ErrNotExist 和 os.ErrPermission
+func (e *scpError) Unwrap() error {
+	switch {
+	case strings.HasSuffix(e.msg, "No such file or directory"):
+		return os.ErrNotExist
+	case strings.HasSuffix(e.msg, "Permission denied"):
+		return os.ErrPermission
+	}
+	return nil
+}
+
+// readScpMessage 读取应答码 code 后面的消息，code 不是 1、2 时通常是远程 shell 的输出，作为错误处理
+func readScpMessage(r *bufio.Reader, code byte) error {
+	msg, _ := r.ReadString('\n')
+	msg = strings.TrimSuffix(msg, "\n")
+	if code != 1 && code != 2 {
+		return &scpError{fatal: true, msg: fmt.Sprintf("unexpected scp response: %q", string(code)+msg)}
+	}
+	return &scpError{fatal: code == 2, msg: msg}
+}
+
+// scpAck 读取对端的应答，0 表示成功
+func scpAck(r *bufio.Reader) error {
+	b, err := r.ReadByte()
+	if err != nil {
+		return err
+	}
+	if b == 0 {
+		return nil
+	}
+	return readScpMessage(r, b)
+}
+
+// scpWarning 对端的警告
+func scpWarning(err error) bool {
+	var scpErr *scpError
+	return errors.As(err, &scpErr) && !scpErr.fatal
+}
+
+// scpMode 转换为 scp 协议使用的八进制权限
+func scpMode(mode os.FileMode) uint32 {
+	m := uint32(mode.Perm())
+	if mode&os.ModeSetuid != 0 {
+		m |= 04000
+	}
+	if mode&os.ModeSetgid != 0 {
+		m |= 02000
+	}
+	if mode&os.ModeSticky != 0 {
+		m |= 01000
+	}
+	return m
+}
+
+func parseScpMode(s string) (os.FileMode, error) {
+	m, err := strconv.ParseUint(s, 8, 32)
+	if err != nil {
+		return 0, fmt.Errorf("invalid scp mode %q", s)
+	}
+	mode := os.FileMode(m).Perm()
+	if m&04000 != 0 {
+		mode |= os.ModeSetuid
+	}
+	if m&02000 != 0 {
+		mode |= os.ModeSetgid
+	}
+	if m&01000 != 0 {
+		mode |= os.ModeSticky
+	}
+	return mode, nil
+}
+
+// scpPath scp 在用户的 home 目录下执行，~ 开头的路径转换为相对路径
+func scpPath(name string) string {
+	switch {
+	case name == "" || name == "~":
+		return "."
+	case strings.HasPrefix(name, "~/"):
+		name = name[2:]
+	}
+	return shellQuote(name)
+}
+
+// scpCommand mode 为 t（接收）或 f（发送），preserve 时对端保留权限和修改时间
+func scpCommand(mode string, recursive, preserve bool, name string) string {
+	cmd := "scp"
+	if recursive {
+		cmd += " -r"
+	}
+	if preserve {
+		cmd += " -p"
+	}
+	return cmd + " -" + mode + " " + scpPath(name)
+}
+
+// scpTarget 只用于通过 dirWalker 遍历本地目录，SCP 的目录由对端在接收时创建
+type scpTarget struct {
+	syncFS
+}
+
+func (scpTarget) Mkdir(string) error {
+	return nil
+}
+
+func (scpTarget) Join(elem ...string) string {
+	return path.Join(elem...)
+}
+
+// scpSender 作为 scp 协议的发送端，对端为 scp -t
+type scpSender struct {
+	w        io.Writer
+	r        *bufio.Reader
+	opt      *transfer
+	progress Progress
+	failed   []*FileError
+}
+
+func (s *scpSender) send(format string, args ...interface{}) error {
+	if _, err := fmt.Fprintf(s.w, format, args...); err != nil {
+		return err
+	}
+	return scpAck(s.r)
+}
+
+// warn 对端的警告记录到当前文件，其他错误中止传输
+func (s *scpSender) warn(rel string, err error) error {
+	if !scpWarning(err) {
+		return err
+	}
+	s.failed = append(s.failed, &FileError{Path: rel, Err: err})
+	return nil
+}
+
+// header 发送 C 或 D 行，保留时间时先发送 T 行
+func (s *scpSender) header(kind byte, job fileJob, size int64) error {
+	if s.opt.preserveTimes {
+		mtime := job.info.ModTime().Unix()
+		if err := s.send("T%d 0 %d 0\n", mtime, mtime); err != nil {
+			return err
+		}
+	}
+	return s.send("%c%04o %d %s\n", kind, scpMode(job.info.Mode()), size, path.Base(job.rel))
+}
+
+func (s *scpSender) file(job fileJob) error {
+	p := fileProgress{Progress: s.progress, name: job.rel}
+	f, err := os.Open(job.src)
+	if err != nil {
+		s.failed = append(s.failed, &FileError{Path: job.rel, Err: p.done(err)})
+		return nil
+	}
+	defer f.Close()
+	size := job.info.Size()
+	if err := s.header('C', job, size); err != nil {
+		return s.warn(job.rel, p.done(err))
+	}
+	p.start(size, 0)
+	// 协议要求发送 size 字节，文件在传输过程中变短时无法继续
+	if _, err := io.CopyN(io.MultiWriter(s.w, p), f, size); err != nil {
+		return p.done(fmt.Errorf("scp %s error: %w", job.rel, err))
+	}
+	if _, err := s.w.Write([]byte{0}); err != nil {
+		return p.done(err)
+	}
+	return s.warn(job.rel, p.done(scpAck(s.r)))
+}
+
+// run 按路径顺序发送，进入目录发送 D，离开目录发送 E。被对端拒绝的目录跳过其中所有文件
+func (s *scpSender) run(jobs []fileJob) error {
+	if err := scpAck(s.r); err != nil {
+		return err
+	}
+	open := make([]string, 0)
+	rejected := ""
+	for _, job := range jobs {
+		if err := s.opt.ctx.Err(); err != nil {
+			return err
+		}
+		if rejected != "" && strings.HasPrefix(job.rel, rejected+"/") {
+			continue
+		}
+		parent := path.Dir(job.rel)
+		for len(open) > 0 && open[len(open)-1] != parent {
+			if err := s.send("E\n"); err != nil {
+				return err
+			}
+			open = open[:len(open)-1]
+		}
+		if !job.info.IsDir() {
+			if err := s.file(job); err != nil {
+				return err
+			}
+			continue
+		}
+		if err := s.header('D', job, 0); err != nil {
+			if err := s.warn(job.rel, err); err != nil {
+				return err
+			}
+			rejected = job.rel
+			continue
+		}
+		open = append(open, job.rel)
+	}
+	for range open {
+		if err := s.send("E\n"); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// discardOnError 写入失败后继续丢弃剩余的数据，保持协议同步
+type discardOnError struct {
+	w   io.Writer
+	err error
+}
+
+func (d *discardOnError) Write(b []byte) (int, error) {
+	if d.err == nil {
+		_, d.err = d.w.Write(b)
+	}
+	return len(b), nil
+}
+
+// scpDir 正在接收的目录
+type scpDir struct {
+	path  string
+	rel   string
+	mode  os.FileMode
+	mtime time.Time
+}
+
+// scpReceiver 作为 scp 协议的接收端，对端为 scp -f
+type scpReceiver struct {
+	w        io.Writer
+	r        *bufio.Reader
+	opt      *transfer
+	report   *TransferReport
+	progress Progress
+	failed   []*FileError
+
+	// dst 本地目标，dstDir 为已存在的目录时接收到 dst 下，否则接收为 dst 本身
+	dst    string
+	dstDir bool
+	dirs   []scpDir
+	// mtime 最近一个 T 行的修改时间，用于下一个 C 或 D
+	mtime time.Time
+}
+
+func (s *scpReceiver) ack() error {
+	_, err := s.w.Write([]byte{0})
+	return err
+}
+
+// target 拒绝对端发送的带路径的文件名，避免写到 dst 之外
+func (s *scpReceiver) target(name string) (string, string, error) {
+	if name == "" || name == "." || name == ".." || strings.ContainsAny(name, `/\`) {
+		return "", "", fmt.Errorf("scp: invalid file name %q", name)
+	}
+	if len(s.dirs) > 0 {
+		top := s.dirs[len(s.dirs)-1]
+		return filepath.Join(top.path, name), path.Join(top.rel, name), nil
+	}
+	if s.dstDir {
+		return filepath.Join(s.dst, name), name, nil
+	}
+	return s.dst, name, nil
+}
+
+// setAttrs 按选项设置收到的权限和修改时间
+func (s *scpReceiver) setAttrs(name string, mode os.FileMode, mtime time.Time) error {
+	if s.opt.preserveMode {
+		if err := os.Chmod(name, mode); err != nil {
+			return err
+		}
+	}
+	if s.opt.preserveTimes && !mtime.IsZero() {
+		return os.Chtimes(name, mtime, mtime)
+	}
+	return nil
+}
+
+// header 解析 C 和 D 行：mode size name
+func (s *scpReceiver) header(line string) (os.FileMode, int64, string, error) {
+	fields := strings.SplitN(line, " ", 3)
+	if len(fields) != 3 {
+		return 0, 0, "", fmt.Errorf("scp: invalid header %q", line)
+	}
+	mode, err := parseScpMode(fields[0])
+	if err != nil {
+		return 0, 0, "", err
+	}
+	size, err := strconv.ParseInt(fields[1], 10, 64)
+	if err != nil || size < 0 {
+		return 0, 0, "", fmt.Errorf("scp: invalid size %q", fields[1])
+	}
+	return mode, size, fields[2], nil
+}
+
+func (s *scpReceiver) file(line string) error {
+	mode, size, name, err := s.header(line)
+	if err != nil {
+		return err
+	}
+	dst, rel, err := s.target(name)
+	if err != nil {
+		return err
+	}
+	mtime := s.mtime
+	s.mtime = time.Time{}
+
+	p := fileProgress{Progress: s.progress, name: rel}
+	f, fileErr := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, mode.Perm())
+	if err := s.ack(); err != nil {
+		if f != nil {
+			f.Close()
+		}
+		return err
+	}
+	w := &discardOnError{w: io.Discard}
+	if fileErr == nil {
+		w.w = io.MultiWriter(f, p)
+		p.start(size, 0)
+	}
+	if _, err := io.CopyN(w, s.r, size); err != nil {
+		if f != nil {
+			f.Close()
+		}
+		return p.done(err)
+	}
+	// 数据之后是发送端的状态，读取文件失败时为警告
+	statusErr := scpAck(s.r)
+	if statusErr != nil && !scpWarning(statusErr) {
+		if f != nil {
+			f.Close()
+		}
+		return p.done(statusErr)
+	}
+	if fileErr == nil {
+		fileErr = w.err
+		if closeErr := f.Close(); fileErr == nil {
+			fileErr = closeErr
+		}
+	}
+	if fileErr == nil {
+		fileErr = statusErr
+	}
+	if fileErr == nil {
+		fileErr = s.setAttrs(dst, mode, mtime)
+	}
+	s.report.Files++
+	s.report.Bytes += size
+	if p.done(fileErr) != nil {
+		s.failed = append(s.failed, &FileError{Path: rel, Err: fileErr})
+	}
+	return s.ack()
+}
+
+func (s *scpReceiver) dir(line string) error {
+	mode, _, name, err := s.header(line)
+	if err != nil {
+		return err
+	}
+	dst, rel, err := s.target(name)
+	if err != nil {
+		return err
+	}
+	if err := (localFS{}).Mkdir(dst); err != nil {
+		return &FileError{Path: rel, Err: err}
+	}
+	s.dirs = append(s.dirs, scpDir{path: dst, rel: rel, mode: mode, mtime: s.mtime})
+	s.mtime = time.Time{}
+	s.report.Dirs++
+	return s.ack()
+}
+
+// end 离开目录，目录内的文件写完后再设置目录的属性
+func (s *scpReceiver) end() error {
+	if len(s.dirs) == 0 {
+		return errors.New("scp: unexpected end of directory")
+	}
+	dir := s.dirs[len(s.dirs)-1]
+	s.dirs = s.dirs[:len(s.dirs)-1]
+	if err := s.setAttrs(dir.path, dir.mode, dir.mtime); err != nil {
+		s.failed = append(s.failed, &FileError{Path: dir.rel, Err: err})
+	}
+	return s.ack()
+}
+
+func (s *scpReceiver) times(line string) error {
+	fields := strings.Fields(line)
+	if len(fields) != 4 {
+		return fmt.Errorf("scp: invalid times %q", line)
+	}
+	mtime, err := strconv.ParseInt(fields[0], 10, 64)
+	if err != nil {
+		return fmt.Errorf("scp: invalid times %q", line)
+	}
+	s.mtime = time.Unix(mtime, 0)
+	return s.ack()
+}
+
+func (s *scpReceiver) run() error {
+	if err := s.ack(); err != nil {
+		return err
+	}
+	for {
+		code, err := s.r.ReadByte()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if code != 'C' && code != 'D' && code != 'E' && code != 'T' {
+			msgErr := readScpMessage(s.r, code)
+			// 只有一个源，顶层的警告表示源文件本身无法读取
+			if !scpWarning(msgErr) || len(s.dirs) == 0 {
+				return msgErr
+			}
+			s.failed = append(s.failed, &FileError{Path: s.dirs[len(s.dirs)-1].rel, Err: msgErr})
+			continue
+		}
+		line, err := s.r.ReadString('\n')
+		if err != nil {
+			return err
+		}
+		line = strings.TrimSuffix(line, "\n")
+		switch code {
+		case 'T':
+			err = s.times(line)
+		case 'C':
+			err = s.file(line)
+		case 'D':
+			err = s.dir(line)
+		case 'E':
+			err = s.end()
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// scpRun 在远程执行 scp 命令，handle 通过命令的 stdin 和 stdout 交换数据
+func (c *ClientType) scpRun(cmd string, handle func(w io.Writer, r *bufio.Reader) error) error {
+	session, err := c.newSession()
+	if err != nil {
+		return err
+	}
+	defer c.closeSession(session)
+	stdin, err := session.StdinPipe()
+	if err != nil {
+		return err
+	}
+	stdout, err := session.StdoutPipe()
+	if err != nil {
+		return err
+	}
+	var stderr bytes.Buffer
+	session.Stderr = &stderr
+	if err := session.Start(cmd); err != nil {
+		return fmt.Errorf("start scp error: %w", err)
+	}
+	handleErr := handle(stdin, bufio.NewReader(stdout))
+	stdin.Close()
+	waitErr := session.Wait()
+	if handleErr != nil {
+		return handleErr
+	}
+	if waitErr != nil {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return fmt.Errorf("scp error: %s: %w", msg, waitErr)
+		}
+		return fmt.Errorf("scp error: %w", waitErr)
+	}
+	return nil
+}
+
+// scpResult 有文件失败时对端以非 0 退出，返回汇总的 *TransferError
+func scpResult(err error, failed []*FileError, total int) error {
+	var exitErr *ssh.ExitError
+	if len(failed) > 0 && (err == nil || errors.As(err, &exitErr)) {
+		return newTransferError(failed, total)
+	}
+	return err
+}
+
+// scpJobs 遍历本地的 src 得到需要发送的目录和文件，按路径的每一级排序，保证目录内的文件连续发送
+func scpJobs(src string, info os.FileInfo, opt *transfer, report *TransferReport) ([]fileJob, []*FileError) {
+	walkOpt := *opt
+	if walkOpt.symlinks == SymlinkPreserve {
+		walkOpt.symlinks = SymlinkFollow
+	}
+	name := filepath.Base(src)
+	w := &dirWalker{srcFS: localFS{}, dstFS: scpTarget{}, opt: &walkOpt, report: report}
+	w.walk(src, name, name, info, nil)
+	jobs := append(w.dirs, w.jobs...)
+	sort.Slice(jobs, func(i, j int) bool {
+		return strings.ReplaceAll(jobs[i].rel, "/", "\x00") < strings.ReplaceAll(jobs[j].rel, "/", "\x00")
+	})
+	return jobs, w.failed
+}
+
+// scpPush 通过 SCP 上传文件或目录，保留权限和时间的选项对应 scp -p。
+// SCP 不支持断点续传、校验、原子写入和并发，符号链接总是跟随
+func (c *ClientType) scpPush(src, dst string, opt *transfer) error {
+	report := opt.report
+	if report == nil {
+		report = &TransferReport{}
+	}
+	*report = TransferReport{}
+	src = filepath.Clean(localRealPath(src))
+	info, err := os.Stat(src)
+	if err != nil {
+		return err
+	}
+
+	jobs, failed := scpJobs(src, info, opt, report)
+	total := report.Files + report.Dirs + len(failed)
+
+	name := filepath.Base(src)
+	progress := c.progress()
+	progress.Start(name, report.Files, report.Bytes)
+	s := &scpSender{opt: opt, progress: progress, failed: failed}
+	err = c.scpRun(scpCommand("t", info.IsDir(), opt.preserveMode || opt.preserveTimes, dst), func(w io.Writer, r *bufio.Reader) error {
+		s.w, s.r = w, r
+		return s.run(jobs)
+	})
+	err = scpResult(err, s.failed, total)
+	progress.Finish(err)
+	return err
+}
+
+// scpGet 通过 SCP 下载文件或目录，选项同 scpPush。下载前不知道文件数量，Progress.Start 的总数为 0
+func (c *ClientType) scpGet(src, dst string, opt *transfer) error {
+	report := opt.report
+	if report == nil {
+		report = &TransferReport{}
+	}
+	*report = TransferReport{}
+	progress := c.progress()
+	progress.Start(path.Base(src), 0, 0)
+	s := &scpReceiver{opt: opt, report: report, progress: progress, dst: localRealPath(dst)}
+	if info, err := os.Stat(s.dst); err == nil && info.IsDir() {
+		s.dstDir = true
+	}
+	err := c.scpRun(scpCommand("f", true, opt.preserveMode || opt.preserveTimes, src), func(w io.Writer, r *bufio.Reader) error {
+		s.w, s.r = w, r
+		return s.run()
+	})
+	err = scpResult(err, s.failed, report.Files+report.Dirs)
+	progress.Finish(err)
+	return err
+}
+
+// sftpUnavailable 记录服务端不支持 sftp，之后的传输直接使用 SCP
+func (c *ClientType) sftpUnavailable() bool {
+	return atomic.LoadInt32(&c.noSftp) == 1
+}
+
+// WithSCP 强制使用 SCP 传输文件。不设置时在 sftp 子系统不可用时自动切换
+func WithSCP(force bool) Option {
+	return func(c *ClientType) {
+		if force {
+			c.noSftp = 1
+		} else {
+			c.noSftp = 0
+		}
+	}
+}
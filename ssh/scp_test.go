@@ -0,0 +1,152 @@
+package ssh
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// scpLoopback 通过管道连接发送端和接收端，不需要 ssh 连接
+func scpLoopback(t *testing.T, src, dst string, opt *transfer) (*scpSender, *scpReceiver, error, error) {
+	info, err := os.Stat(src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	jobs, failed := scpJobs(src, info, opt, &TransferReport{})
+	toRecv, fromSend := io.Pipe()
+	toSend, fromRecv := io.Pipe()
+	recv := &scpReceiver{w: fromRecv, r: bufio.NewReader(toRecv), opt: opt, report: &TransferReport{}, progress: nopProgress{}, dst: dst}
+	if info, err := os.Stat(dst); err == nil && info.IsDir() {
+		recv.dstDir = true
+	}
+	send := &scpSender{w: fromSend, r: bufio.NewReader(toSend), opt: opt, progress: nopProgress{}, failed: failed}
+
+	done := make(chan error, 1)
+	go func() {
+		err := recv.run()
+		fromRecv.Close()
+		toRecv.Close()
+		done <- err
+	}()
+	sendErr := send.run(jobs)
+	fromSend.Close()
+	return send, recv, sendErr, <-done
+}
+
+func TestScpLoopback(t *testing.T) {
+	src := filepath.Join(t.TempDir(), "src")
+	writeTree(t, src, map[string]string{
+		"a.txt":         "a",
+		"a-b/c.txt":     "c",
+		"a/deep/d.txt":  "dd",
+		"a/e.txt":       "eee",
+		"empty/.hidden": "",
+	})
+	os.Chmod(filepath.Join(src, "a", "e.txt"), 0750)
+	old := time.Now().Add(-time.Hour).Truncate(time.Second)
+	os.Chtimes(filepath.Join(src, "a", "deep", "d.txt"), old, old)
+	os.Chtimes(filepath.Join(src, "a"), old, old)
+
+	dst := t.TempDir()
+	opt := newTransfer([]TransferOption{WithPreserveMode(true), WithPreserveTimes(true)})
+	_, recv, sendErr, recvErr := scpLoopback(t, src, dst, opt)
+	if sendErr != nil || recvErr != nil {
+		t.Fatal(sendErr, recvErr)
+	}
+	for name, want := range map[string]string{"a.txt": "a", "a-b/c.txt": "c", "a/deep/d.txt": "dd", "a/e.txt": "eee"} {
+		if b, err := os.ReadFile(filepath.Join(dst, "src", filepath.FromSlash(name))); err != nil || string(b) != want {
+			t.Errorf("%s = %q, %v", name, b, err)
+		}
+	}
+	if recv.report.Files != 5 || recv.report.Dirs != 5 || recv.report.Bytes != 7 {
+		t.Errorf("report = %+v", recv.report)
+	}
+	if info, _ := os.Stat(filepath.Join(dst, "src", "a", "e.txt")); info.Mode().Perm() != 0750 {
+		t.Errorf("mode = %v", info.Mode())
+	}
+	if info, _ := os.Stat(filepath.Join(dst, "src", "a", "deep", "d.txt")); !info.ModTime().Equal(old) {
+		t.Errorf("file mtime = %v", info.ModTime())
+	}
+	if info, _ := os.Stat(filepath.Join(dst, "src", "a")); !info.ModTime().Equal(old) {
+		t.Errorf("dir mtime = %v", info.ModTime())
+	}
+
+	// 目标不存在时单个文件接收为 dst 本身
+	name := filepath.Join(t.TempDir(), "renamed.txt")
+	if _, _, sendErr, recvErr := scpLoopback(t, filepath.Join(src, "a.txt"), name, newTransfer(nil)); sendErr != nil || recvErr != nil {
+		t.Fatal(sendErr, recvErr)
+	}
+	if b, err := os.ReadFile(name); err != nil || string(b) != "a" {
+		t.Errorf("renamed.txt = %q, %v", b, err)
+	}
+}
+
+func TestScpLoopback_FileError(t *testing.T) {
+	src := filepath.Join(t.TempDir(), "src")
+	writeTree(t, src, map[string]string{"a.txt": "a", "b.txt": "b"})
+	dst := t.TempDir()
+	// a.txt 的位置已经是目录，写入失败但不影响 b.txt
+	os.MkdirAll(filepath.Join(dst, "src", "a.txt"), 0755)
+
+	_, recv, sendErr, recvErr := scpLoopback(t, src, dst, newTransfer(nil))
+	if sendErr != nil || recvErr != nil {
+		t.Fatal(sendErr, recvErr)
+	}
+	if len(recv.failed) != 1 || recv.failed[0].Path != "src/a.txt" {
+		t.Errorf("failed = %v", recv.failed)
+	}
+	if b, _ := os.ReadFile(filepath.Join(dst, "src", "b.txt")); string(b) != "b" {
+		t.Errorf("b.txt = %q", b)
+	}
+}
+
+func TestScpReceiver_Errors(t *testing.T) {
+	dst := t.TempDir()
+	cases := map[string]string{
+		"C0644 1 ../evil\n":                        "invalid file name",
+		"D0755 0 sub\nC0644 1 /etc/passwd\n":       "invalid file name",
+		"\x01scp: /x: No such file or directory\n": "No such file",
+		"bash: scp: command not found\n":           "unexpected scp response",
+	}
+	for stream, want := range cases {
+		s := &scpReceiver{w: io.Discard, r: bufio.NewReader(strings.NewReader(stream)), opt: newTransfer(nil), report: &TransferReport{}, progress: nopProgress{}, dst: dst, dstDir: true}
+		err := s.run()
+		if err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("%q: err = %v", stream, err)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(filepath.Dir(dst), "evil")); !os.IsNotExist(err) {
+		t.Error("file written outside dst")
+	}
+
+	s := &scpReceiver{w: io.Discard, r: bufio.NewReader(strings.NewReader("\x01scp: /x: No such file or directory\n")), opt: newTransfer(nil), report: &TransferReport{}, progress: nopProgress{}, dst: dst}
+	if err := s.run(); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("err = %v, want os.ErrNotExist", err)
+	}
+}
+
+func TestScpCommand(t *testing.T) {
+	cases := []struct {
+		got, want string
+	}{
+		{scpCommand("t", false, false, "~"), "scp -t ."},
+		{scpCommand("t", true, true, "~/my dir"), "scp -r -p -t 'my dir'"},
+		{scpCommand("f", true, false, "/tmp/it's"), `scp -r -f '/tmp/it'\''s'`},
+	}
+	for _, c := range cases {
+		if c.got != c.want {
+			t.Errorf("command = %q, want %q", c.got, c.want)
+		}
+	}
+	for _, mode := range []os.FileMode{0644, 0755 | os.ModeSetuid, 0700 | os.ModeSetgid | os.ModeSticky} {
+		if got, err := parseScpMode(fmt.Sprintf("%04o", scpMode(mode))); err != nil || got != mode {
+			t.Errorf("mode %v -> %v, %v", mode, got, err)
+		}
+	}
+}
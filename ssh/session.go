@@ -0,0 +1,199 @@
+package ssh
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/pkg/sftp"
+	"golang.org/x/crypto/ssh"
+	"io"
+	"sync"
+	"sync/atomic"
+)
+
+// DefaultMaxSessions 默认同时打开的 session 数量，和 OpenSSH 服务端 MaxSessions 默认值一致
+const DefaultMaxSessions = 10
+
+// sftpSession 占用一个 session 配额的 sftp 客户端，Close 时归还
+type sftpSession struct {
+	*sftp.Client
+	release func()
+}
+
+func (s *sftpSession) Close() error {
+	defer s.release()
+	return s.Client.Close()
+}
+
+// newSessionLimit 为通过当前连接建立的新客户端创建同样大小的 session 配额
+func (c *ClientType) newSessionLimit() chan struct{} {
+	if c.sessions == nil {
+		return nil
+	}
+	return make(chan struct{}, cap(c.sessions))
+}
+
+// acquireSession 等待 session 配额，ctx 结束时返回 ctx.Err()
+func (c *ClientType) acquireSession(ctx context.Context) error {
+	if c.sessions == nil {
+		return nil
+	}
+	select {
+	case c.sessions <- struct{}{}:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// tryAcquireSession 不等待，配额已满时返回 false
+func (c *ClientType) tryAcquireSession() bool {
+	if c.sessions == nil {
+		return true
+	}
+	select {
+	case c.sessions <- struct{}{}:
+		return true
+	default:
+		return false
+	}
+}
+
+func (c *ClientType) releaseSession() {
+	if c.sessions != nil {
+		<-c.sessions
+	}
+}
+
+// newSession 每个操作使用独立的 session，多个 session 复用同一个 TCP 连接。
+// 同时打开的 session 达到上限时会等待其他操作结束
+func (c *ClientType) newSession() (*ssh.Session, error) {
+	return c.newSessionContext(context.Background())
+}
+
+// newSessionContext 同 newSession，等待配额时 ctx 结束返回 ctx.Err()
+func (c *ClientType) newSessionContext(ctx context.Context) (*ssh.Session, error) {
+	if err := c.acquireSession(ctx); err != nil {
+		return nil, err
+	}
+	session, err := c.conn().NewSession()
+	if err != nil {
+		c.releaseSession()
+		return nil, fmt.Errorf("open session error: %w", err)
+	}
+	return session, nil
+}
+
+func (c *ClientType) closeSession(session *ssh.Session) error {
+	defer c.releaseSession()
+	return session.Close()
+}
+
+// newSftpClient sftp 子系统同样占用服务端的一个 session。服务端拒绝 sftp 子系统，
+// 或者接受后没有完成握手就关闭（没有安装 sftp-server）时返回 ErrSftpUnavailable
+func (c *ClientType) newSftpClient(option ...sftp.ClientOption) (*sftpSession, error) {
+	if c.sftpUnavailable() {
+		return nil, ErrSftpUnavailable
+	}
+	session, err := c.newSession()
+	if err != nil {
+		return nil, err
+	}
+	cli, err := sftpPipe(session, option...)
+	if err != nil {
+		c.closeSession(session)
+		if errors.Is(err, ErrSftpUnavailable) {
+			atomic.StoreInt32(&c.noSftp, 1)
+		}
+		return nil, err
+	}
+	return &sftpSession{Client: cli, release: func() {
+		c.closeSession(session)
+	}}, nil
+}
+
+// sftpConn 一次传输共用的 sftp 客户端。连接断开或 WithReconnect 换了连接后，旧客户端上的操作都会失败，
+// 重试前通过 reopen 换成新的客户端，并发传输的其他文件也会使用新的客户端
+type sftpConn struct {
+	c      *ClientType
+	option []sftp.ClientOption
+	mu     sync.Mutex
+	cur    *sftpSession
+	// stale cur 已经关闭，重新打开失败时保留旧客户端，后续操作返回错误而不是空指针
+	stale bool
+}
+
+func (c *ClientType) newSftpConn(option ...sftp.ClientOption) (*sftpConn, error) {
+	cur, err := c.newSftpClient(option...)
+	if err != nil {
+		return nil, err
+	}
+	return &sftpConn{c: c, option: option, cur: cur}, nil
+}
+
+func (s *sftpConn) client() *sftp.Client {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.cur.Client
+}
+
+// reopen 关闭出错的 old 并打开新的客户端，其他文件已经换过时直接返回当前的客户端。
+// 先关闭再打开，归还的 session 配额可以给新客户端使用
+func (s *sftpConn) reopen(old *sftp.Client) (*sftp.Client, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.cur.Client != old {
+		return s.cur.Client, nil
+	}
+	if !s.stale {
+		s.cur.Close()
+		s.stale = true
+	}
+	cur, err := s.c.newSftpClient(s.option...)
+	if err != nil {
+		return nil, err
+	}
+	s.cur, s.stale = cur, false
+	return cur.Client, nil
+}
+
+func (s *sftpConn) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.stale {
+		return nil
+	}
+	s.stale = true
+	return s.cur.Close()
+}
+
+func sftpPipe(session *ssh.Session, option ...sftp.ClientOption) (*sftp.Client, error) {
+	if err := session.RequestSubsystem("sftp"); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrSftpUnavailable, err)
+	}
+	w, err := session.StdinPipe()
+	if err != nil {
+		return nil, err
+	}
+	r, err := session.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	cli, err := sftp.NewClientPipe(r, w, option...)
+	if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+		return nil, fmt.Errorf("%w: %v", ErrSftpUnavailable, err)
+	}
+	return cli, err
+}
+
+// WithMaxSessions 设置同时打开的 session 数量上限，应与服务端 sshd_config 的 MaxSessions 一致，
+// 小于等于 0 表示不限制
+func WithMaxSessions(n int) Option {
+	return func(c *ClientType) {
+		if n <= 0 {
+			c.sessions = nil
+			return
+		}
+		c.sessions = make(chan struct{}, n)
+	}
+}
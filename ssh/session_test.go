@@ -0,0 +1,72 @@
+package ssh
+
+import (
+	"context"
+	"errors"
+	"io"
+	"testing"
+	"time"
+)
+
+func TestWithMaxSessions(t *testing.T) {
+	c := &ClientType{}
+	WithMaxSessions(2)(c)
+	c.acquireSession(context.Background())
+	c.acquireSession(context.Background())
+
+	acquired := make(chan struct{})
+	go func() {
+		c.acquireSession(context.Background())
+		close(acquired)
+	}()
+	select {
+	case <-acquired:
+		t.Fatal("third session should wait for a free slot")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	c.releaseSession()
+	select {
+	case <-acquired:
+	case <-time.After(time.Second):
+		t.Fatal("session slot was not released")
+	}
+
+	WithMaxSessions(0)(c)
+	if c.sessions != nil || c.newSessionLimit() != nil {
+		t.Error("zero should disable the limit")
+	}
+}
+
+func TestClientType_SessionWaitContext(t *testing.T) {
+	cli := newTestClient(t, newTestServer(t), WithMaxSessions(1))
+	c := cli.(*ClientType)
+	held, err := c.newSession()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.closeSession(held)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	done := make(chan error, 1)
+	go func() {
+		done <- cli.RunContext(ctx, "true", io.Discard, io.Discard)
+	}()
+	select {
+	case err := <-done:
+		var runErr *RunError
+		if !errors.As(err, &runErr) || !runErr.Timeout || !runErr.Canceled || !errors.Is(err, context.DeadlineExceeded) {
+			t.Errorf("want timeout RunError, got %#v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("RunContext ignored ctx while waiting for a session")
+	}
+
+	canceled, cancel := context.WithCancel(context.Background())
+	cancel()
+	var runErr *RunError
+	if _, err := cli.Exec(canceled, "true"); !errors.As(err, &runErr) || runErr.Timeout || !runErr.Canceled {
+		t.Errorf("want canceled RunError, got %#v", err)
+	}
+}
@@ -0,0 +1,542 @@
+package ssh
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"os/exec"
+	"os/user"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// DefaultSSHConfigFiles LoadSSHConfig 未指定文件时读取的配置，前面的文件优先
+var DefaultSSHConfigFiles = []string{"~/.ssh/config", "/etc/ssh/ssh_config"}
+
+// Include 嵌套的最大层数，和 OpenSSH 保持一致
+const maxIncludeDepth = 16
+
+type configLine struct {
+	key      string
+	args     []string
+	includes []*configFile
+}
+
+type configFile struct {
+	name  string
+	lines []configLine
+}
+
+// SSHConfig OpenSSH 客户端配置（ssh_config）。
+// 支持 Host/Match 块（通配符和 ! 取反）、Include、%h/%p/%r/%u 等 token 以及"第一个值生效"的规则
+type SSHConfig struct {
+	files []*configFile
+}
+
+// HostConfig 某个主机解析后的配置，key 为小写关键字
+type HostConfig map[string][]string
+
+// Get 返回关键字的第一个值
+func (h HostConfig) Get(key string) string {
+	if v := h[strings.ToLower(key)]; len(v) > 0 {
+		return v[0]
+	}
+	return ""
+}
+
+// Values 返回关键字的所有值，如多个 IdentityFile
+func (h HostConfig) Values(key string) []string {
+	return h[strings.ToLower(key)]
+}
+
+// 可以出现多次且值会累加的关键字
+var multiValueKeys = map[string]bool{
+	"identityfile":    true,
+	"certificatefile": true,
+	"localforward":    true,
+	"remoteforward":   true,
+	"dynamicforward":  true,
+	"sendenv":         true,
+	"setenv":          true,
+}
+
+// LoadSSHConfig 读取 ssh_config 文件，不存在的文件会被忽略
+func LoadSSHConfig(files ...string) (*SSHConfig, error) {
+	if len(files) == 0 {
+		files = DefaultSSHConfigFiles
+	}
+	conf := &SSHConfig{}
+	for _, f := range files {
+		file, err := loadConfigFile(localRealPath(f), 0)
+		if errors.Is(err, os.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		conf.files = append(conf.files, file)
+	}
+	return conf, nil
+}
+
+// ParseSSHConfig 从 r 解析 ssh_config，相对路径的 Include 以 ~/.ssh 为基准
+func ParseSSHConfig(r io.Reader) (*SSHConfig, error) {
+	file, err := parseConfig(r, "", 0)
+	if err != nil {
+		return nil, err
+	}
+	return &SSHConfig{files: []*configFile{file}}, nil
+}
+
+func loadConfigFile(name string, depth int) (*configFile, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return parseConfig(f, name, depth)
+}
+
+func parseConfig(r io.Reader, name string, depth int) (*configFile, error) {
+	file := &configFile{name: name}
+	scanner := bufio.NewScanner(r)
+	lineNo := 0
+	for scanner.Scan() {
+		lineNo++
+		key, args, err := splitConfigLine(scanner.Text())
+		if err != nil {
+			return nil, fmt.Errorf("%s line %d: %w", name, lineNo, err)
+		}
+		if key == "" {
+			continue
+		}
+		line := configLine{key: key, args: args}
+		if key == "include" {
+			if depth >= maxIncludeDepth {
+				return nil, fmt.Errorf("%s line %d: include nested too deeply", name, lineNo)
+			}
+			for _, pattern := range args {
+				matches, globErr := filepath.Glob(includePath(pattern, name))
+				if globErr != nil {
+					return nil, fmt.Errorf("%s line %d: %w", name, lineNo, globErr)
+				}
+				for _, m := range matches {
+					included, loadErr := loadConfigFile(m, depth+1)
+					if loadErr != nil {
+						return nil, loadErr
+					}
+					line.includes = append(line.includes, included)
+				}
+			}
+		}
+		file.lines = append(file.lines, line)
+	}
+	return file, scanner.Err()
+}
+
+// includePath 相对路径在用户配置中以 ~/.ssh 为基准，在系统配置中以 /etc/ssh 为基准
+func includePath(pattern, parent string) string {
+	pattern = localRealPath(pattern)
+	if filepath.IsAbs(pattern) {
+		return pattern
+	}
+	if strings.HasPrefix(parent, "/etc/ssh") {
+		return filepath.Join("/etc/ssh", pattern)
+	}
+	return filepath.Join(localRealPath("~/.ssh"), pattern)
+}
+
+// splitConfigLine 解析 "Keyword value" 或 "Keyword=value"，支持双引号
+func splitConfigLine(line string) (string, []string, error) {
+	line = strings.TrimSpace(line)
+	if line == "" || strings.HasPrefix(line, "#") {
+		return "", nil, nil
+	}
+	end := strings.IndexAny(line, " \t=")
+	if end < 0 {
+		return "", nil, fmt.Errorf("missing argument for %s", line)
+	}
+	key := strings.ToLower(line[:end])
+	rest := strings.TrimLeft(line[end:], " \t")
+	rest = strings.TrimLeft(strings.TrimPrefix(rest, "="), " \t")
+
+	var (
+		args    []string
+		current strings.Builder
+		quoted  bool
+		has     bool
+	)
+scan:
+	for _, r := range rest {
+		switch {
+		case r == '"':
+			quoted = !quoted
+			has = true
+		case (r == ' ' || r == '\t') && !quoted:
+			if has {
+				args = append(args, current.String())
+				current.Reset()
+				has = false
+			}
+		case r == '#' && !quoted && !has:
+			// 行尾注释
+			break scan
+		default:
+			current.WriteRune(r)
+			has = true
+		}
+	}
+	if quoted {
+		return "", nil, errors.New("unterminated quote")
+	}
+	if has {
+		args = append(args, current.String())
+	}
+	if len(args) == 0 {
+		return "", nil, fmt.Errorf("missing argument for %s", key)
+	}
+	return key, args, nil
+}
+
+// matchPatterns 逗号或空格分隔的通配符列表，任一 !pattern 命中则不匹配
+func matchPatterns(value string, patterns []string) bool {
+	matched := false
+	for _, list := range patterns {
+		for _, p := range strings.Split(list, ",") {
+			negate := strings.HasPrefix(p, "!")
+			p = strings.TrimPrefix(p, "!")
+			if ok, _ := filepath.Match(p, value); ok {
+				if negate {
+					return false
+				}
+				matched = true
+			}
+		}
+	}
+	return matched
+}
+
+type resolveState struct {
+	alias     string
+	localUser string
+	values    HostConfig
+}
+
+func (s *resolveState) host() string {
+	if h := s.values.Get("hostname"); h != "" {
+		return s.expand(h)
+	}
+	return s.alias
+}
+
+func (s *resolveState) user() string {
+	if u := s.values.Get("user"); u != "" {
+		return u
+	}
+	return s.localUser
+}
+
+// expand 替换 %h %p %r %u %n %d %%
+func (s *resolveState) expand(value string) string {
+	if !strings.Contains(value, "%") {
+		return value
+	}
+	host := s.alias
+	if h := s.values.Get("hostname"); h != "" && !strings.Contains(h, "%") {
+		host = h
+	}
+	port := s.values.Get("port")
+	if port == "" {
+		port = "22"
+	}
+	home, _ := os.UserHomeDir()
+	replacer := strings.NewReplacer(
+		"%%", "%",
+		"%h", host,
+		"%n", s.alias,
+		"%p", port,
+		"%r", s.user(),
+		"%u", s.localUser,
+		"%d", home,
+	)
+	return replacer.Replace(value)
+}
+
+func (s *resolveState) matchBlock(args []string) (bool, error) {
+	for i := 0; i < len(args); i++ {
+		criteria := strings.ToLower(args[i])
+		negate := strings.HasPrefix(criteria, "!")
+		criteria = strings.TrimPrefix(criteria, "!")
+
+		var ok bool
+		switch criteria {
+		case "all":
+			ok = true
+		case "canonical":
+			ok = false
+		case "final":
+			ok = true
+		case "host", "originalhost", "user", "localuser", "exec":
+			if i+1 >= len(args) {
+				return false, fmt.Errorf("match %s: missing argument", criteria)
+			}
+			i++
+			arg := args[i]
+			switch criteria {
+			case "host":
+				ok = matchPatterns(s.host(), []string{arg})
+			case "originalhost":
+				ok = matchPatterns(s.alias, []string{arg})
+			case "user":
+				ok = matchPatterns(s.user(), []string{arg})
+			case "localuser":
+				ok = matchPatterns(s.localUser, []string{arg})
+			case "exec":
+				ok = exec.Command("sh", "-c", s.expand(arg)).Run() == nil
+			}
+		default:
+			return false, fmt.Errorf("unsupported match criteria %s", criteria)
+		}
+		if ok == negate {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+func (s *resolveState) apply(file *configFile) error {
+	active := true
+	for _, line := range file.lines {
+		switch line.key {
+		case "host":
+			active = matchPatterns(s.alias, line.args)
+		case "match":
+			ok, err := s.matchBlock(line.args)
+			if err != nil {
+				return fmt.Errorf("%s: %w", file.name, err)
+			}
+			active = ok
+		case "include":
+			if !active {
+				continue
+			}
+			for _, included := range line.includes {
+				if err := s.apply(included); err != nil {
+					return err
+				}
+			}
+		default:
+			if !active {
+				continue
+			}
+			if multiValueKeys[line.key] {
+				s.values[line.key] = append(s.values[line.key], line.args...)
+			} else if _, ok := s.values[line.key]; !ok {
+				s.values[line.key] = line.args
+			}
+		}
+	}
+	return nil
+}
+
+// Resolve 按 ssh -G 的规则计算 alias 最终生效的配置
+func (c *SSHConfig) Resolve(alias string) (HostConfig, error) {
+	state := &resolveState{alias: alias, values: HostConfig{}}
+	if u, err := user.Current(); err == nil {
+		state.localUser = u.Username
+	}
+	for _, f := range c.files {
+		if err := state.apply(f); err != nil {
+			return nil, err
+		}
+	}
+	if h := state.values.Get("hostname"); h != "" {
+		state.values["hostname"] = []string{state.expand(h)}
+	}
+	for _, key := range []string{"identityfile", "certificatefile"} {
+		for i, f := range state.values[key] {
+			state.values[key][i] = state.expand(f)
+		}
+	}
+	return state.values, nil
+}
+
+// splitDestination 解析 [user@]host[:port]
+func splitDestination(dest string) (username, host, port string) {
+	dest = strings.TrimPrefix(dest, "ssh://")
+	if i := strings.LastIndex(dest, "@"); i >= 0 {
+		username, dest = dest[:i], dest[i+1:]
+	}
+	if h, p, err := net.SplitHostPort(dest); err == nil {
+		return username, h, p
+	}
+	return username, dest, ""
+}
+
+// AuthConfig 把 [user@]alias[:port] 按 ssh_config 解析为 AuthConfig，不包含 ProxyJump
+func (c *SSHConfig) AuthConfig(dest string) (*AuthConfig, error) {
+	username, alias, port := splitDestination(dest)
+	host, err := c.Resolve(alias)
+	if err != nil {
+		return nil, err
+	}
+	conf := &AuthConfig{
+		Username: username,
+		NetworkConfig: NetworkConfig{
+			Network: "tcp",
+		},
+	}
+	if conf.Username == "" {
+		conf.Username = host.Get("user")
+	}
+	if conf.Username == "" {
+		if u, userErr := user.Current(); userErr == nil {
+			conf.Username = u.Username
+		}
+	}
+
+	hostname := host.Get("hostname")
+	if hostname == "" {
+		hostname = alias
+	}
+	if port == "" {
+		port = host.Get("port")
+	}
+	if port == "" {
+		port = "22"
+	}
+	conf.Address = net.JoinHostPort(hostname, port)
+
+	if timeout := host.Get("connecttimeout"); timeout != "" {
+		conf.ConnectTimeout, err = strconv.Atoi(timeout)
+		if err != nil {
+			return nil, fmt.Errorf("invalid ConnectTimeout %q: %w", timeout, err)
+		}
+	}
+
+	files, certs := host.Values("identityfile"), host.Values("certificatefile")
+	if len(files) > 0 || len(certs) > 0 {
+		if !strings.EqualFold(host.Get("identitiesonly"), "yes") {
+			conf.Methods = append(conf.Methods, AgentAuth())
+		}
+		keyFile := KeyFileAuth(existingFiles(files)...)
+		keyFile.certs = existingFiles(certs)
+		conf.Methods = append(conf.Methods, keyFile)
+	}
+
+	conf.HostKey.KnownHostsFiles = append(host.Values("userknownhostsfile"), host.Values("globalknownhostsfile")...)
+	switch strings.ToLower(host.Get("stricthostkeychecking")) {
+	case "accept-new":
+		conf.HostKey.Mode = HostKeyTOFU
+	case "no", "off":
+		// 和 OpenSSH 一样不拒绝任何公钥。OpenSSH 还会把新公钥写入 known_hosts，这里不写入
+		conf.HostKey.Mode = HostKeyIgnore
+	default:
+		conf.HostKey.Mode = HostKeyStrict
+	}
+	return conf, nil
+}
+
+// existingFiles 和 OpenSSH 一样忽略不存在的 IdentityFile/CertificateFile
+func existingFiles(files []string) []string {
+	ret := make([]string, 0, len(files))
+	for _, f := range files {
+		if _, err := os.Stat(localRealPath(f)); err == nil {
+			ret = append(ret, f)
+		}
+	}
+	return ret
+}
+
+// JumpHosts 返回 ProxyJump 中的跳板机，按连接顺序排列，每个跳板机同样按 ssh_config 解析。
+// 跳板机自己配置了 ProxyJump 时先连接它的跳板机，链上已经经过的跳板机不会重复连接
+func (c *SSHConfig) JumpHosts(dest string) ([]*AuthConfig, error) {
+	return c.jumpHosts(dest, nil)
+}
+
+// jumpHosts visiting 为正在解析的别名，用于检测 ProxyJump 循环
+func (c *SSHConfig) jumpHosts(dest string, visiting []string) ([]*AuthConfig, error) {
+	_, alias, _ := splitDestination(dest)
+	for _, v := range visiting {
+		if v == alias {
+			return nil, fmt.Errorf("ProxyJump loop detected at %s", alias)
+		}
+	}
+	if len(visiting) >= maxIncludeDepth {
+		return nil, fmt.Errorf("ProxyJump too deep at %s", alias)
+	}
+	host, err := c.Resolve(alias)
+	if err != nil {
+		return nil, err
+	}
+	jump := host.Get("proxyjump")
+	if jump == "" || strings.EqualFold(jump, "none") {
+		return nil, nil
+	}
+
+	visiting = append(visiting, alias)
+	var chain []*AuthConfig
+	for _, hop := range strings.Split(jump, ",") {
+		hop = strings.TrimSpace(hop)
+		parents, parentErr := c.jumpHosts(hop, visiting)
+		if parentErr != nil {
+			return nil, parentErr
+		}
+		chain = appendHops(chain, parents)
+		conf, confErr := c.AuthConfig(hop)
+		if confErr != nil {
+			return nil, confErr
+		}
+		chain = append(chain, conf)
+	}
+	return chain, nil
+}
+
+// appendHops 把 hops 接到 chain 后面，chain 末尾已经是 hops 的前一部分时只追加剩下的
+func appendHops(chain, hops []*AuthConfig) []*AuthConfig {
+	for n := len(hops); n > 0; n-- {
+		if n > len(chain) {
+			continue
+		}
+		same := true
+		for i := 0; i < n; i++ {
+			if hopKey(nil, chain[len(chain)-n+i]) != hopKey(nil, hops[i]) {
+				same = false
+				break
+			}
+		}
+		if same {
+			return append(chain, hops[n:]...)
+		}
+	}
+	return append(chain, hops...)
+}
+
+// NewClientFromSSHConfig 按 ~/.ssh/config 解析 [user@]alias[:port] 并连接，ProxyJump 会自动建立跳板链
+func NewClientFromSSHConfig(dest string, option ...Option) (Client, error) {
+	conf, err := LoadSSHConfig()
+	if err != nil {
+		return nil, err
+	}
+	return conf.NewClient(dest, option...)
+}
+
+// NewClient 按当前配置连接 [user@]alias[:port]
+func (c *SSHConfig) NewClient(dest string, option ...Option) (Client, error) {
+	target, err := c.AuthConfig(dest)
+	if err != nil {
+		return nil, err
+	}
+	jumps, err := c.JumpHosts(dest)
+	if err != nil {
+		return nil, err
+	}
+	for _, hop := range jumps {
+		target.JumpHosts = append(target.JumpHosts, *hop)
+	}
+	return NewClient(target, option...)
+}
@@ -0,0 +1,182 @@
+package ssh
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const testSSHConfig = `
+# bastion
+Host bastion
+    HostName 10.0.0.1
+    User jump
+    Port 2222
+
+Host web-*
+    ProxyJump bastion
+    IdentitiesOnly yes
+    IdentityFile %d/.ssh/id_%h
+
+Host web-1
+    HostName 192.168.1.11
+    User ignored
+
+Match originalhost web-2 exec "test %n = web-2"
+    HostName 192.168.1.12
+
+Match originalhost web-3 !all
+    HostName 192.168.1.12
+
+Host *
+    User deploy
+    ConnectTimeout=5
+    StrictHostKeyChecking accept-new
+`
+
+func TestSSHConfig_Resolve(t *testing.T) {
+	conf, err := ParseSSHConfig(strings.NewReader(testSSHConfig))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	host, err := conf.Resolve("web-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := host.Get("HostName"); got != "192.168.1.11" {
+		t.Errorf("HostName = %s", got)
+	}
+	if got := host.Get("user"); got != "ignored" {
+		t.Errorf("first value should win, User = %s", got)
+	}
+	home, _ := os.UserHomeDir()
+	if got := host.Get("IdentityFile"); got != filepath.Join(home, ".ssh", "id_192.168.1.11") {
+		t.Errorf("IdentityFile = %s", got)
+	}
+
+	host, _ = conf.Resolve("web-2")
+	if got := host.Get("hostname"); got != "192.168.1.12" {
+		t.Errorf("Match exec should apply, HostName = %s", got)
+	}
+	host, _ = conf.Resolve("web-3")
+	if got := host.Get("hostname"); got != "" {
+		t.Errorf("Match !all should not apply, HostName = %s", got)
+	}
+}
+
+func TestSSHConfig_AuthConfig(t *testing.T) {
+	conf, err := ParseSSHConfig(strings.NewReader(testSSHConfig))
+	if err != nil {
+		t.Fatal(err)
+	}
+	auth, err := conf.AuthConfig("root@web-1:2200")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if auth.Username != "root" || auth.Address != "192.168.1.11:2200" || auth.ConnectTimeout != 5 {
+		t.Errorf("unexpected auth config: %+v", auth)
+	}
+	if auth.HostKey.Mode != HostKeyTOFU {
+		t.Errorf("StrictHostKeyChecking accept-new should use TOFU, got %v", auth.HostKey.Mode)
+	}
+	insecure, err := ParseSSHConfig(strings.NewReader("Host *\n    StrictHostKeyChecking no\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if auth, _ := insecure.AuthConfig("web-1"); auth.HostKey.Mode != HostKeyIgnore {
+		t.Errorf("StrictHostKeyChecking no should skip verification, got %v", auth.HostKey.Mode)
+	}
+	if len(auth.Methods) != 1 || auth.Methods[0].kind != authKeyFile {
+		t.Errorf("IdentitiesOnly should disable agent: %+v", auth.Methods)
+	}
+
+	jumps, err := conf.JumpHosts("web-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(jumps) != 1 || jumps[0].Address != "10.0.0.1:2222" || jumps[0].Username != "jump" {
+		t.Errorf("unexpected jump hosts: %+v", jumps)
+	}
+	if jumps, _ := conf.JumpHosts("bastion"); len(jumps) != 0 {
+		t.Errorf("bastion should be dialed directly: %+v", jumps)
+	}
+}
+
+func TestSSHConfig_JumpHostsNested(t *testing.T) {
+	conf, err := ParseSSHConfig(strings.NewReader(`
+Host a
+    HostName 10.0.0.1
+Host b
+    HostName 10.0.0.2
+    ProxyJump a
+Host c
+    HostName 10.0.0.3
+    ProxyJump d
+Host d
+    HostName 10.0.0.4
+Host via-ac
+    ProxyJump a,c
+Host via-ab
+    ProxyJump a,b
+Host loop-1
+    ProxyJump loop-2
+Host loop-2
+    ProxyJump loop-1
+Host *
+    User deploy
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for dest, want := range map[string]string{
+		// 后面的跳板机的 ProxyJump 同样生效
+		"via-ac": "10.0.0.1:22,10.0.0.4:22,10.0.0.3:22",
+		// b 的 ProxyJump 已经在链上
+		"via-ab": "10.0.0.1:22,10.0.0.2:22",
+	} {
+		jumps, err := conf.JumpHosts(dest)
+		if err != nil {
+			t.Fatal(err)
+		}
+		addrs := make([]string, 0, len(jumps))
+		for _, j := range jumps {
+			addrs = append(addrs, j.Address)
+		}
+		if got := strings.Join(addrs, ","); got != want {
+			t.Errorf("%s: jump hosts %s, want %s", dest, got, want)
+		}
+	}
+	if _, err := conf.JumpHosts("loop-1"); err == nil || !strings.Contains(err.Error(), "loop") {
+		t.Errorf("want loop error, got %v", err)
+	}
+}
+
+func TestLoadSSHConfig_Include(t *testing.T) {
+	dir := t.TempDir()
+	included := filepath.Join(dir, "conf.d", "db.conf")
+	if err := os.MkdirAll(filepath.Dir(included), 0700); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(included, []byte("Host db\n  HostName 10.1.1.1\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	main := filepath.Join(dir, "config")
+	content := "Include " + filepath.Join(dir, "conf.d", "*.conf") + "\nHost \"*\"\n  Port 22022\n"
+	if err := os.WriteFile(main, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	conf, err := LoadSSHConfig(main)
+	if err != nil {
+		t.Fatal(err)
+	}
+	auth, err := conf.AuthConfig("db")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if auth.Address != "10.1.1.1:22022" {
+		t.Errorf("Address = %s", auth.Address)
+	}
+}
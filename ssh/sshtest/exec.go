@@ -0,0 +1,95 @@
+package sshtest
+
+import (
+	"errors"
+	"io"
+	"os"
+	"os/exec"
+	"syscall"
+)
+
+var signals = map[string]syscall.Signal{
+	"HUP":  syscall.SIGHUP,
+	"INT":  syscall.SIGINT,
+	"QUIT": syscall.SIGQUIT,
+	"KILL": syscall.SIGKILL,
+	"TERM": syscall.SIGTERM,
+}
+
+// ExecHandler 在本机用 sh -c 执行命令，shell 请求时执行 sh 读取 stdin。
+// 申请了伪终端时在 linux 上通过真实的 pty 运行，应用客户端的终端模式和窗口大小，其他平台仍然使用管道。
+// 工作目录和 HOME 为 Session.Dir，客户端发送的 HUP、INT、QUIT、KILL、TERM 信号转发给 sh 及其子进程
+func ExecHandler(s *Session) int {
+	cmd := exec.Command("sh")
+	if s.Cmd != "" {
+		cmd = exec.Command("sh", "-c", s.Cmd)
+	}
+	cmd.Env = append(os.Environ(), s.Env...)
+	if s.Pty != nil {
+		cmd.Env = append(cmd.Env, "TERM="+s.Pty.Term)
+	}
+	if s.Dir != "" {
+		cmd.Dir = s.Dir
+		cmd.Env = append(cmd.Env, "HOME="+s.Dir)
+	}
+	start := startPipes
+	if s.Pty != nil {
+		start = startPty
+	}
+	wait, err := start(cmd, s)
+	if err != nil {
+		io.WriteString(s.Stderr, err.Error()+"\n")
+		return 127
+	}
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		for {
+			select {
+			case <-done:
+				return
+			case name := <-s.Signals:
+				if sig, ok := signals[name]; ok {
+					signalProcess(cmd, sig)
+				}
+			}
+		}
+	}()
+
+	err = wait()
+	var exitErr *exec.ExitError
+	if !errors.As(err, &exitErr) {
+		if err != nil {
+			return 127
+		}
+		return 0
+	}
+	if status, ok := exitErr.Sys().(syscall.WaitStatus); ok && status.Signaled() {
+		for name, sig := range signals {
+			if sig == status.Signal() {
+				s.KilledBy(name)
+			}
+		}
+		return 128 + int(status.Signal())
+	}
+	return exitErr.ExitCode()
+}
+
+// startPipes 通过管道启动命令，返回等待命令结束的函数
+func startPipes(cmd *exec.Cmd, s *Session) (func() error, error) {
+	cmd.Stdout, cmd.Stderr = s.Stdout, s.Stderr
+	setProcessGroup(cmd)
+	// stdin 单独复制，命令结束时不必等待客户端关闭输入
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return nil, err
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, err
+	}
+	go func() {
+		io.Copy(stdin, s.Stdin)
+		stdin.Close()
+	}()
+	return cmd.Wait, nil
+}
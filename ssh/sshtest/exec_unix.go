@@ -0,0 +1,17 @@
+//go:build !windows
+
+package sshtest
+
+import (
+	"os/exec"
+	"syscall"
+)
+
+// setProcessGroup 命令在单独的进程组中运行，信号发给整个进程组，sh 的子进程也会收到
+func setProcessGroup(cmd *exec.Cmd) {
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+}
+
+func signalProcess(cmd *exec.Cmd, sig syscall.Signal) {
+	syscall.Kill(-cmd.Process.Pid, sig)
+}
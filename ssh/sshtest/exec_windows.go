@@ -0,0 +1,15 @@
+//go:build windows
+
+package sshtest
+
+import (
+	"os/exec"
+	"syscall"
+)
+
+func setProcessGroup(*exec.Cmd) {}
+
+// signalProcess windows 上只支持结束进程
+func signalProcess(cmd *exec.Cmd, _ syscall.Signal) {
+	cmd.Process.Kill()
+}
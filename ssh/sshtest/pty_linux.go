@@ -0,0 +1,144 @@
+//go:build linux
+
+package sshtest
+
+import (
+	"golang.org/x/crypto/ssh"
+	"io"
+	"os"
+	"os/exec"
+	"strconv"
+	"syscall"
+	"unsafe"
+)
+
+// termFlags ssh 终端模式对应的 termios 标志位
+var termFlags = map[uint8]struct {
+	field func(t *syscall.Termios) *uint32
+	flag  uint32
+}{
+	ssh.ICRNL:  {iflag, syscall.ICRNL},
+	ssh.IXON:   {iflag, syscall.IXON},
+	ssh.ISIG:   {lflag, syscall.ISIG},
+	ssh.ICANON: {lflag, syscall.ICANON},
+	ssh.ECHO:   {lflag, syscall.ECHO},
+	ssh.ECHOE:  {lflag, syscall.ECHOE},
+	ssh.ECHOK:  {lflag, syscall.ECHOK},
+	ssh.ECHONL: {lflag, syscall.ECHONL},
+	ssh.OPOST:  {oflag, syscall.OPOST},
+	ssh.ONLCR:  {oflag, syscall.ONLCR},
+}
+
+func iflag(t *syscall.Termios) *uint32 { return &t.Iflag }
+func lflag(t *syscall.Termios) *uint32 { return &t.Lflag }
+func oflag(t *syscall.Termios) *uint32 { return &t.Oflag }
+
+func ioctl(f *os.File, req uint, arg unsafe.Pointer) error {
+	conn, err := f.SyscallConn()
+	if err != nil {
+		return err
+	}
+	var errno syscall.Errno
+	if err := conn.Control(func(fd uintptr) {
+		_, _, errno = syscall.Syscall(syscall.SYS_IOCTL, fd, uintptr(req), uintptr(arg))
+	}); err != nil {
+		return err
+	}
+	if errno != 0 {
+		return errno
+	}
+	return nil
+}
+
+// openPty 打开一对伪终端，master 由服务端读写，tty 作为命令的控制终端
+func openPty() (master, tty *os.File, err error) {
+	master, err = os.OpenFile("/dev/ptmx", os.O_RDWR|syscall.O_NOCTTY, 0)
+	if err != nil {
+		return nil, nil, err
+	}
+	var unlock int32
+	var n uint32
+	if err = ioctl(master, syscall.TIOCSPTLCK, unsafe.Pointer(&unlock)); err == nil {
+		err = ioctl(master, syscall.TIOCGPTN, unsafe.Pointer(&n))
+	}
+	if err == nil {
+		tty, err = os.OpenFile("/dev/pts/"+strconv.Itoa(int(n)), os.O_RDWR|syscall.O_NOCTTY, 0)
+	}
+	if err != nil {
+		master.Close()
+		return nil, nil, err
+	}
+	return master, tty, nil
+}
+
+func setWinsize(tty *os.File, width, height int) error {
+	ws := struct{ Row, Col, X, Y uint16 }{Row: uint16(height), Col: uint16(width)}
+	return ioctl(tty, syscall.TIOCSWINSZ, unsafe.Pointer(&ws))
+}
+
+// setModes 把客户端请求的终端模式应用到 tty，不认识的模式忽略
+func setModes(tty *os.File, modes ssh.TerminalModes) error {
+	var t syscall.Termios
+	if err := ioctl(tty, syscall.TCGETS, unsafe.Pointer(&t)); err != nil {
+		return err
+	}
+	for op, value := range modes {
+		f, ok := termFlags[op]
+		if !ok {
+			continue
+		}
+		if value != 0 {
+			*f.field(&t) |= f.flag
+		} else {
+			*f.field(&t) &^= f.flag
+		}
+	}
+	return ioctl(tty, syscall.TCSETS, unsafe.Pointer(&t))
+}
+
+// startPty 在新的会话中启动命令，tty 为控制终端，信号同样发给整个进程组
+func startPty(cmd *exec.Cmd, s *Session) (func() error, error) {
+	master, tty, err := openPty()
+	if err != nil {
+		return nil, err
+	}
+	defer tty.Close()
+	if err := setModes(tty, s.Pty.Modes); err != nil {
+		master.Close()
+		return nil, err
+	}
+	setWinsize(tty, s.Pty.Width, s.Pty.Height)
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = tty, tty, tty
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setsid: true, Setctty: true}
+	if err := cmd.Start(); err != nil {
+		master.Close()
+		return nil, err
+	}
+
+	stop := make(chan struct{})
+	go func() {
+		for {
+			select {
+			case <-stop:
+				return
+			case size := <-s.Resize:
+				setWinsize(master, size.Width, size.Height)
+			}
+		}
+	}()
+	// 和 sshd 一样，客户端关闭输入不会结束终端
+	go io.Copy(master, s.Stdin)
+	output := make(chan struct{})
+	go func() {
+		// 所有进程都关闭 tty 后读取 master 返回 EIO，这时输出已经读完
+		io.Copy(s.Stdout, master)
+		close(output)
+	}()
+	return func() error {
+		err := cmd.Wait()
+		<-output
+		close(stop)
+		master.Close()
+		return err
+	}, nil
+}
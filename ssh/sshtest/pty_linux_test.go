@@ -0,0 +1,74 @@
+package sshtest
+
+import (
+	"bytes"
+	"golang.org/x/crypto/ssh"
+	"strings"
+	"testing"
+)
+
+func TestExecHandler_Pty(t *testing.T) {
+	client, err := dialServer(t, newServer(t), "erin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	session, err := client.NewSession()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer session.Close()
+	if err := session.RequestPty("vt100", 40, 100, ssh.TerminalModes{ssh.ECHO: 0}); err != nil {
+		t.Fatal(err)
+	}
+	out, err := session.Output(`test -t 0 && test -t 1 && stty size && stty -a | grep -ow -- -echo && echo $TERM`)
+	if err != nil {
+		t.Fatalf("%v: %q", err, out)
+	}
+	// 真实的终端会把 \n 转换为 \r\n
+	if want := "40 100\r\n-echo\r\nvt100\r\n"; string(out) != want {
+		t.Errorf("output %q, want %q", out, want)
+	}
+}
+
+func TestExecHandler_PtyShell(t *testing.T) {
+	client, err := dialServer(t, newServer(t), "erin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	session, err := client.NewSession()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer session.Close()
+	if err := session.RequestPty("xterm", 24, 80, ssh.TerminalModes{}); err != nil {
+		t.Fatal(err)
+	}
+	session.Stdin = strings.NewReader("tty >/dev/null && exit 3\n")
+	var out bytes.Buffer
+	session.Stdout = &out
+	if err := session.Shell(); err != nil {
+		t.Fatal(err)
+	}
+	err = session.Wait()
+	if exitErr, ok := err.(*ssh.ExitError); !ok || exitErr.ExitStatus() != 3 {
+		t.Errorf("want exit status 3, got %v, output %q", err, out.String())
+	}
+	// 默认回显输入
+	if !strings.Contains(out.String(), "exit 3") {
+		t.Errorf("input not echoed: %q", out.String())
+	}
+}
+
+func TestParseModes(t *testing.T) {
+	encoded := string(ssh.Marshal(struct {
+		Echo  byte
+		On    uint32
+		Speed byte
+		Baud  uint32
+		End   byte
+	}{ssh.ECHO, 1, ssh.TTY_OP_OSPEED, 38400, 0}))
+	modes := parseModes(encoded)
+	if len(modes) != 2 || modes[ssh.ECHO] != 1 || modes[ssh.TTY_OP_OSPEED] != 38400 {
+		t.Errorf("modes = %v", modes)
+	}
+}
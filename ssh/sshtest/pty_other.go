@@ -0,0 +1,10 @@
+//go:build !linux
+
+package sshtest
+
+import "os/exec"
+
+// startPty 没有 pty 支持的平台仍然通过管道运行
+func startPty(cmd *exec.Cmd, s *Session) (func() error, error) {
+	return startPipes(cmd, s)
+}
@@ -0,0 +1,582 @@
+// Package sshtest 进程内的 SSH 服务端，支持 exec、带伪终端的 shell、sftp 子系统、
+// direct-tcpip 和 tcpip-forward 端口转发，用于测试和在本机替代真实主机。
+// ExecHandler 在 linux 上为申请了伪终端的 session 分配真实的 pty；其他平台没有 pty，命令仍然通过管道运行，
+// 只设置 TERM，isatty、sudo 的密码提示和行编辑和真实主机不同
+package sshtest
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/binary"
+	"fmt"
+	"github.com/pkg/sftp"
+	"golang.org/x/crypto/ssh"
+	"io"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Pty 客户端申请的伪终端及窗口大小
+type Pty struct {
+	Term   string
+	Width  int
+	Height int
+	// Modes pty-req 中的终端模式，如 ssh.ECHO，Resize 中的事件不包含
+	Modes ssh.TerminalModes
+}
+
+// Session 一次 exec 或 shell 请求，交给 Handler 处理
+type Session struct {
+	// User 登录的用户名
+	User string
+	// Cmd exec 请求的命令，shell 请求时为空
+	Cmd string
+	// Env 客户端通过 env 请求设置的环境变量，格式为 key=value
+	Env []string
+	// Dir 服务端通过 WithHome 设置的 home 目录
+	Dir string
+	// Pty 没有申请伪终端时为 nil
+	Pty *Pty
+	// Resize 窗口大小变化，Handler 处理不及时的事件会被丢弃
+	Resize <-chan Pty
+	// Signals 客户端发送的信号名，如 TERM、KILL
+	Signals <-chan string
+	Stdin   io.Reader
+	Stdout  io.Writer
+	// Stderr 申请了伪终端时和 Stdout 相同
+	Stderr io.Writer
+
+	exitSignal string
+}
+
+// KilledBy 命令被信号终止，服务端会发送 exit-signal 代替 exit-status
+func (s *Session) KilledBy(signal string) {
+	s.exitSignal = signal
+}
+
+// Handler 处理 exec 和 shell 请求，返回退出码
+type Handler func(s *Session) int
+
+// Server 监听在 127.0.0.1 的随机端口上，使用完需要 Close
+type Server struct {
+	listener net.Listener
+	config   *ssh.ServerConfig
+	hostKey  ssh.Signer
+	handler  Handler
+	sftp     bool
+	home     string
+
+	passwords map[string]string
+	keys      map[string][]ssh.PublicKey
+	password  func(user, password string) bool
+	publicKey func(user string, key ssh.PublicKey) bool
+	userCAs   []ssh.PublicKey
+	configure func(*ssh.ServerConfig)
+	keepAlive func() bool
+
+	mu     sync.Mutex
+	conns  map[*ssh.ServerConn]struct{}
+	closed bool
+	wg     sync.WaitGroup
+}
+
+type Option func(*Server)
+
+// WithPassword 允许 user 使用 password 登录，可以设置多个用户
+func WithPassword(user, password string) Option {
+	return func(s *Server) {
+		s.passwords[user] = password
+	}
+}
+
+// WithPasswordAuth 自定义密码认证
+func WithPasswordAuth(check func(user, password string) bool) Option {
+	return func(s *Server) {
+		s.password = check
+	}
+}
+
+// WithAuthorizedKey 允许 user 使用 key 对应的私钥登录
+func WithAuthorizedKey(user string, key ssh.PublicKey) Option {
+	return func(s *Server) {
+		s.keys[user] = append(s.keys[user], key)
+	}
+}
+
+// WithPublicKeyAuth 自定义公钥认证
+func WithPublicKeyAuth(check func(user string, key ssh.PublicKey) bool) Option {
+	return func(s *Server) {
+		s.publicKey = check
+	}
+}
+
+// WithUserCA 接受 ca 签发的用户证书，证书的 principals 需要包含登录的用户名
+func WithUserCA(ca ssh.PublicKey) Option {
+	return func(s *Server) {
+		s.userCAs = append(s.userCAs, ca)
+	}
+}
+
+// WithHostKey 服务端的主机密钥，可以是 ssh.NewCertSigner 生成的主机证书，默认每次随机生成 ed25519 密钥
+func WithHostKey(signer ssh.Signer) Option {
+	return func(s *Server) {
+		s.hostKey = signer
+	}
+}
+
+// WithHandler 处理 exec 和 shell 请求，默认为 ExecHandler
+func WithHandler(handler Handler) Option {
+	return func(s *Server) {
+		s.handler = handler
+	}
+}
+
+// WithSftp 是否提供 sftp 子系统，默认提供。sftp 直接访问本机文件系统，相对路径相对于当前进程的工作目录
+func WithSftp(enable bool) Option {
+	return func(s *Server) {
+		s.sftp = enable
+	}
+}
+
+// WithServerConfig 在启动前修改 ssh.ServerConfig，例如限制 KeyExchanges、Ciphers、MACs 模拟老旧设备
+func WithServerConfig(configure func(*ssh.ServerConfig)) Option {
+	return func(s *Server) {
+		s.configure = configure
+	}
+}
+
+// WithKeepAliveReply 收到 keepalive@openssh.com 请求时调用 reply，返回 false 时不回复，
+// 用来模拟网络中断时客户端的 keepalive 超时。默认和其他未知请求一样回复失败
+func WithKeepAliveReply(reply func() bool) Option {
+	return func(s *Server) {
+		s.keepAlive = reply
+	}
+}
+
+// WithHome 设置 Session.Dir，ExecHandler 在这个目录下执行命令
+func WithHome(dir string) Option {
+	return func(s *Server) {
+		s.home = dir
+	}
+}
+
+// NewServer 启动服务端。没有设置任何认证方式时不需要认证
+func NewServer(option ...Option) (*Server, error) {
+	s := &Server{
+		handler:   ExecHandler,
+		sftp:      true,
+		passwords: make(map[string]string),
+		keys:      make(map[string][]ssh.PublicKey),
+		conns:     make(map[*ssh.ServerConn]struct{}),
+	}
+	for _, opt := range option {
+		opt(s)
+	}
+	if s.hostKey == nil {
+		_, key, err := ed25519.GenerateKey(rand.Reader)
+		if err != nil {
+			return nil, err
+		}
+		if s.hostKey, err = ssh.NewSignerFromKey(key); err != nil {
+			return nil, err
+		}
+	}
+	s.config = s.serverConfig()
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, err
+	}
+	s.listener = listener
+	s.wg.Add(1)
+	go s.serve()
+	return s, nil
+}
+
+func (s *Server) serverConfig() *ssh.ServerConfig {
+	config := &ssh.ServerConfig{}
+	config.AddHostKey(s.hostKey)
+	if len(s.passwords) > 0 || s.password != nil {
+		config.PasswordCallback = func(conn ssh.ConnMetadata, password []byte) (*ssh.Permissions, error) {
+			if want, ok := s.passwords[conn.User()]; ok && want == string(password) {
+				return nil, nil
+			}
+			if s.password != nil && s.password(conn.User(), string(password)) {
+				return nil, nil
+			}
+			return nil, fmt.Errorf("password rejected for %s", conn.User())
+		}
+	}
+	if len(s.keys) > 0 || s.publicKey != nil || len(s.userCAs) > 0 {
+		checker := &ssh.CertChecker{
+			IsUserAuthority: func(auth ssh.PublicKey) bool {
+				for _, ca := range s.userCAs {
+					if string(ca.Marshal()) == string(auth.Marshal()) {
+						return true
+					}
+				}
+				return false
+			},
+		}
+		config.PublicKeyCallback = func(conn ssh.ConnMetadata, key ssh.PublicKey) (*ssh.Permissions, error) {
+			if _, ok := key.(*ssh.Certificate); ok && len(s.userCAs) > 0 {
+				return checker.Authenticate(conn, key)
+			}
+			for _, k := range s.keys[conn.User()] {
+				if string(k.Marshal()) == string(key.Marshal()) {
+					return nil, nil
+				}
+			}
+			if s.publicKey != nil && s.publicKey(conn.User(), key) {
+				return nil, nil
+			}
+			return nil, fmt.Errorf("public key rejected for %s", conn.User())
+		}
+	}
+	config.NoClientAuth = config.PasswordCallback == nil && config.PublicKeyCallback == nil
+	if s.configure != nil {
+		s.configure(config)
+	}
+	return config
+}
+
+// Addr 监听地址，格式为 127.0.0.1:port
+func (s *Server) Addr() string {
+	return s.listener.Addr().String()
+}
+
+// HostKey 服务端的主机公钥，用于测试主机密钥校验
+func (s *Server) HostKey() ssh.PublicKey {
+	return s.hostKey.PublicKey()
+}
+
+// Close 停止监听并断开所有连接
+func (s *Server) Close() error {
+	s.mu.Lock()
+	s.closed = true
+	for conn := range s.conns {
+		conn.Close()
+	}
+	s.mu.Unlock()
+	err := s.listener.Close()
+	s.wg.Wait()
+	return err
+}
+
+func (s *Server) serve() {
+	defer s.wg.Done()
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			return
+		}
+		s.wg.Add(1)
+		go s.handleConn(conn)
+	}
+}
+
+func (s *Server) handleConn(nc net.Conn) {
+	defer s.wg.Done()
+	conn, chans, reqs, err := ssh.NewServerConn(nc, s.config)
+	if err != nil {
+		nc.Close()
+		return
+	}
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		conn.Close()
+		return
+	}
+	s.conns[conn] = struct{}{}
+	s.mu.Unlock()
+	defer func() {
+		s.mu.Lock()
+		delete(s.conns, conn)
+		s.mu.Unlock()
+		conn.Close()
+	}()
+
+	go s.globalRequests(conn, reqs)
+	for newCh := range chans {
+		switch newCh.ChannelType() {
+		case "session":
+			go s.session(conn, newCh)
+		case "direct-tcpip":
+			go directTCPIP(newCh)
+		case "direct-streamlocal@openssh.com":
+			go directStreamLocal(newCh)
+		default:
+			newCh.Reject(ssh.UnknownChannelType, "unsupported channel type")
+		}
+	}
+}
+
+// session 处理 session 通道上的请求，exec/shell 开始后继续转发信号和窗口大小变化
+func (s *Server) session(conn *ssh.ServerConn, newCh ssh.NewChannel) {
+	ch, reqs, err := newCh.Accept()
+	if err != nil {
+		return
+	}
+	resize := make(chan Pty, 16)
+	signals := make(chan string, 16)
+	sess := &Session{User: conn.User(), Dir: s.home, Resize: resize, Signals: signals}
+	started := false
+	start := func(run func()) bool {
+		if started {
+			return false
+		}
+		started = true
+		go run()
+		return true
+	}
+
+	for req := range reqs {
+		ok := false
+		switch req.Type {
+		case "env":
+			var payload struct{ Name, Value string }
+			if ssh.Unmarshal(req.Payload, &payload) == nil {
+				sess.Env = append(sess.Env, payload.Name+"="+payload.Value)
+				ok = true
+			}
+		case "pty-req":
+			var payload struct {
+				Term                         string
+				Columns, Rows, Width, Height uint32
+				Modes                        string
+			}
+			if ssh.Unmarshal(req.Payload, &payload) == nil {
+				sess.Pty = &Pty{Term: payload.Term, Width: int(payload.Columns), Height: int(payload.Rows), Modes: parseModes(payload.Modes)}
+				ok = true
+			}
+		case "window-change":
+			var payload struct{ Columns, Rows, Width, Height uint32 }
+			if ssh.Unmarshal(req.Payload, &payload) == nil && sess.Pty != nil {
+				// sess.Pty 可能正在被 Handler 读取，只通过 Resize 通知新的大小
+				size := Pty{Term: sess.Pty.Term, Width: int(payload.Columns), Height: int(payload.Rows)}
+				select {
+				case resize <- size:
+				default:
+				}
+				ok = true
+			}
+		case "signal":
+			var payload struct{ Signal string }
+			if ssh.Unmarshal(req.Payload, &payload) == nil {
+				select {
+				case signals <- payload.Signal:
+				default:
+				}
+				ok = true
+			}
+		case "exec":
+			var payload struct{ Command string }
+			if ssh.Unmarshal(req.Payload, &payload) == nil {
+				sess.Cmd = payload.Command
+				ok = start(func() { s.run(ch, sess) })
+			}
+		case "shell":
+			ok = start(func() { s.run(ch, sess) })
+		case "subsystem":
+			var payload struct{ Name string }
+			if ssh.Unmarshal(req.Payload, &payload) == nil && payload.Name == "sftp" && s.sftp {
+				ok = start(func() { serveSftp(ch) })
+			}
+		}
+		if req.WantReply {
+			req.Reply(ok, nil)
+		}
+	}
+	ch.Close()
+}
+
+// parseModes 解析 RFC 4254 8 节编码的终端模式，每项为 1 字节 opcode 和 uint32 参数，以 TTY_OP_END 结束
+func parseModes(encoded string) ssh.TerminalModes {
+	modes := make(ssh.TerminalModes)
+	for b := []byte(encoded); len(b) >= 5 && b[0] != 0 && b[0] < 160; b = b[5:] {
+		modes[b[0]] = binary.BigEndian.Uint32(b[1:5])
+	}
+	return modes
+}
+
+func (s *Server) run(ch ssh.Channel, sess *Session) {
+	sess.Stdin, sess.Stdout, sess.Stderr = ch, ch, ch.Stderr()
+	if sess.Pty != nil {
+		sess.Stderr = ch
+	}
+	status := s.handler(sess)
+	if sess.exitSignal != "" {
+		ch.SendRequest("exit-signal", false, ssh.Marshal(struct {
+			Signal     string
+			CoreDumped bool
+			Error      string
+			Lang       string
+		}{Signal: sess.exitSignal}))
+	} else {
+		ch.SendRequest("exit-status", false, ssh.Marshal(struct{ Status uint32 }{uint32(status)}))
+	}
+	ch.Close()
+}
+
+func serveSftp(ch ssh.Channel) {
+	defer ch.Close()
+	server, err := sftp.NewServer(ch)
+	if err != nil {
+		return
+	}
+	server.Serve()
+	server.Close()
+}
+
+// globalRequests 处理 tcpip-forward 远程端口转发和 keepalive，连接断开时关闭所有监听
+func (s *Server) globalRequests(conn *ssh.ServerConn, reqs <-chan *ssh.Request) {
+	listeners := make(map[string]net.Listener)
+	defer func() {
+		for _, l := range listeners {
+			l.Close()
+		}
+	}()
+	for req := range reqs {
+		switch req.Type {
+		case "tcpip-forward":
+			var payload struct {
+				Addr string
+				Port uint32
+			}
+			if ssh.Unmarshal(req.Payload, &payload) != nil {
+				req.Reply(false, nil)
+				continue
+			}
+			listener, err := net.Listen("tcp", net.JoinHostPort(payload.Addr, strconv.Itoa(int(payload.Port))))
+			if err != nil {
+				req.Reply(false, nil)
+				continue
+			}
+			port := uint32(listener.Addr().(*net.TCPAddr).Port)
+			listeners[net.JoinHostPort(payload.Addr, strconv.Itoa(int(port)))] = listener
+			go forwardTCPIP(conn, listener, payload.Addr, port)
+			var reply []byte
+			if payload.Port == 0 {
+				reply = ssh.Marshal(struct{ Port uint32 }{port})
+			}
+			req.Reply(true, reply)
+		case "cancel-tcpip-forward":
+			var payload struct {
+				Addr string
+				Port uint32
+			}
+			key := ""
+			if ssh.Unmarshal(req.Payload, &payload) == nil {
+				key = net.JoinHostPort(payload.Addr, strconv.Itoa(int(payload.Port)))
+			}
+			listener, ok := listeners[key]
+			if ok {
+				listener.Close()
+				delete(listeners, key)
+			}
+			req.Reply(ok, nil)
+		case "keepalive@openssh.com":
+			if s.keepAlive == nil || s.keepAlive() {
+				req.Reply(false, nil)
+			}
+		default:
+			if req.WantReply {
+				req.Reply(false, nil)
+			}
+		}
+	}
+}
+
+func forwardTCPIP(conn *ssh.ServerConn, listener net.Listener, addr string, port uint32) {
+	for {
+		c, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		origin := c.RemoteAddr().(*net.TCPAddr)
+		payload := ssh.Marshal(struct {
+			Addr       string
+			Port       uint32
+			OriginAddr string
+			OriginPort uint32
+		}{addr, port, origin.IP.String(), uint32(origin.Port)})
+		go func() {
+			ch, reqs, err := conn.OpenChannel("forwarded-tcpip", payload)
+			if err != nil {
+				c.Close()
+				return
+			}
+			go ssh.DiscardRequests(reqs)
+			pipe(ch, c)
+		}()
+	}
+}
+
+func directTCPIP(newCh ssh.NewChannel) {
+	var payload struct {
+		Addr       string
+		Port       uint32
+		OriginAddr string
+		OriginPort uint32
+	}
+	if err := ssh.Unmarshal(newCh.ExtraData(), &payload); err != nil {
+		newCh.Reject(ssh.ConnectionFailed, err.Error())
+		return
+	}
+	dial(newCh, "tcp", net.JoinHostPort(payload.Addr, strconv.Itoa(int(payload.Port))))
+}
+
+func directStreamLocal(newCh ssh.NewChannel) {
+	var payload struct {
+		SocketPath string
+		Reserved0  string
+		Reserved1  uint32
+	}
+	if err := ssh.Unmarshal(newCh.ExtraData(), &payload); err != nil {
+		newCh.Reject(ssh.ConnectionFailed, err.Error())
+		return
+	}
+	dial(newCh, "unix", payload.SocketPath)
+}
+
+func dial(newCh ssh.NewChannel, network, address string) {
+	c, err := net.DialTimeout(network, address, 10*time.Second)
+	if err != nil {
+		newCh.Reject(ssh.ConnectionFailed, err.Error())
+		return
+	}
+	ch, reqs, err := newCh.Accept()
+	if err != nil {
+		c.Close()
+		return
+	}
+	go ssh.DiscardRequests(reqs)
+	pipe(ch, c)
+}
+
+type closeWriter interface {
+	CloseWrite() error
+}
+
+// pipe 双向复制，一个方向结束时半关闭另一端，两个方向都结束后关闭
+func pipe(a, b io.ReadWriteCloser) {
+	var wg sync.WaitGroup
+	copyHalf := func(dst, src io.ReadWriteCloser) {
+		defer wg.Done()
+		io.Copy(dst, src)
+		if cw, ok := dst.(closeWriter); ok {
+			cw.CloseWrite()
+		} else {
+			dst.Close()
+		}
+	}
+	wg.Add(2)
+	go copyHalf(a, b)
+	go copyHalf(b, a)
+	wg.Wait()
+	a.Close()
+	b.Close()
+}
@@ -0,0 +1,134 @@
+package sshtest
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"golang.org/x/crypto/ssh"
+	"testing"
+	"time"
+)
+
+func dialServer(t *testing.T, server *Server, user string, auth ...ssh.AuthMethod) (*ssh.Client, error) {
+	client, err := ssh.Dial("tcp", server.Addr(), &ssh.ClientConfig{
+		User:            user,
+		Auth:            auth,
+		HostKeyCallback: ssh.FixedHostKey(server.HostKey()),
+		Timeout:         5 * time.Second,
+	})
+	if err == nil {
+		t.Cleanup(func() { client.Close() })
+	}
+	return client, err
+}
+
+func newServer(t *testing.T, option ...Option) *Server {
+	server, err := NewServer(option...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { server.Close() })
+	return server
+}
+
+func TestServer_Auth(t *testing.T) {
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signer, err := ssh.NewSignerFromKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := newServer(t, WithPassword("alice", "pw"), WithAuthorizedKey("bob", signer.PublicKey()))
+
+	if _, err := dialServer(t, server, "alice", ssh.Password("pw")); err != nil {
+		t.Errorf("password auth: %v", err)
+	}
+	if _, err := dialServer(t, server, "alice", ssh.Password("bad")); err == nil {
+		t.Error("want wrong password rejected")
+	}
+	if _, err := dialServer(t, server, "bob", ssh.PublicKeys(signer)); err != nil {
+		t.Errorf("public key auth: %v", err)
+	}
+	if _, err := dialServer(t, server, "alice", ssh.PublicKeys(signer)); err == nil {
+		t.Error("want key of another user rejected")
+	}
+}
+
+func TestServer_Handler(t *testing.T) {
+	server := newServer(t, WithSftp(false), WithHandler(func(s *Session) int {
+		if s.Cmd == "die" {
+			s.KilledBy("TERM")
+			return 143
+		}
+		s.Stdout.Write([]byte(s.User + ":" + s.Cmd))
+		return 7
+	}))
+	client, err := dialServer(t, server, "carol")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	session, err := client.NewSession()
+	if err != nil {
+		t.Fatal(err)
+	}
+	out, err := session.Output("hello")
+	if exitErr, ok := err.(*ssh.ExitError); !ok || exitErr.ExitStatus() != 7 {
+		t.Errorf("want exit status 7, got %v", err)
+	}
+	if string(out) != "carol:hello" {
+		t.Errorf("output %q", out)
+	}
+
+	session, err = client.NewSession()
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = session.Run("die")
+	if exitErr, ok := err.(*ssh.ExitError); !ok || exitErr.Signal() != "TERM" {
+		t.Errorf("want killed by TERM, got %v", err)
+	}
+
+	session, err = client.NewSession()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer session.Close()
+	if err := session.RequestSubsystem("sftp"); err == nil {
+		t.Error("want sftp subsystem rejected")
+	}
+}
+
+func TestServer_TCPIPForward(t *testing.T) {
+	server := newServer(t)
+	client, err := dialServer(t, server, "dave")
+	if err != nil {
+		t.Fatal(err)
+	}
+	listener, err := client.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		conn.Write([]byte("forwarded"))
+		conn.Close()
+	}()
+	// 通过 direct-tcpip 连接服务端上的转发端口，数据再经 forwarded-tcpip 回到客户端
+	conn, err := client.Dial("tcp", listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	buf := make([]byte, 16)
+	n, _ := conn.Read(buf)
+	if string(buf[:n]) != "forwarded" {
+		t.Errorf("read %q", buf[:n])
+	}
+}
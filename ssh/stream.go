@@ -0,0 +1,213 @@
+package ssh
+
+import (
+	"github.com/pkg/sftp"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"sort"
+)
+
+// remoteFile 独占一个 sftp session 的远程文件，Close 时一起归还
+type remoteFile struct {
+	*sftp.File
+	session *sftpSession
+}
+
+func (f *remoteFile) Close() error {
+	err := f.File.Close()
+	if closeErr := f.session.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// Open 打开远程文件用于读取，使用完必须 Close 归还 session
+func (c *ClientType) Open(name string) (io.ReadCloser, error) {
+	session, err := c.newSftpClient(sftp.UseConcurrentReads(true))
+	if err != nil {
+		return nil, err
+	}
+	f, err := session.Open(remoteRealpath(name, session.Client))
+	if err != nil {
+		session.Close()
+		return nil, err
+	}
+	return &remoteFile{File: f, session: session}, nil
+}
+
+// Create 创建或清空远程文件用于写入，文件创建后立即设置为 mode，使用完必须 Close
+func (c *ClientType) Create(name string, mode os.FileMode) (io.WriteCloser, error) {
+	session, err := c.newSftpClient(sftp.UseConcurrentWrites(true))
+	if err != nil {
+		return nil, err
+	}
+	f, err := session.OpenFile(remoteRealpath(name, session.Client), os.O_WRONLY|os.O_CREATE|os.O_TRUNC)
+	if err != nil {
+		session.Close()
+		return nil, err
+	}
+	if err := f.Chmod(mode); err != nil {
+		f.Close()
+		session.Close()
+		return nil, err
+	}
+	return &remoteFile{File: f, session: session}, nil
+}
+
+// Upload 把 r 的内容写入远程文件 dst，不需要先写到本地临时文件
+func (c *ClientType) Upload(r io.Reader, dst string, mode os.FileMode) error {
+	w, err := c.Create(dst, mode)
+	if err != nil {
+		return err
+	}
+	if _, err := w.(*remoteFile).ReadFrom(r); err != nil {
+		w.Close()
+		return err
+	}
+	return w.Close()
+}
+
+// Download 把远程文件 src 的内容写入 w
+func (c *ClientType) Download(src string, w io.Writer) error {
+	r, err := c.Open(src)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+	_, err = r.(*remoteFile).WriteTo(w)
+	return err
+}
+
+// SftpFS 以 root 为根目录的远程只读文件系统，实现 fs.FS、fs.StatFS、fs.ReadDirFS 和 fs.ReadFileFS，
+// 可以用于 fs.WalkDir、template.ParseFS 等。所有操作共用一个 sftp session，使用完需要 Close
+type SftpFS struct {
+	root    string
+	session *sftpSession
+}
+
+// FS 打开远程的 root 目录作为 fs.FS
+func (c *ClientType) FS(root string) (*SftpFS, error) {
+	session, err := c.newSftpClient(sftp.UseConcurrentReads(true))
+	if err != nil {
+		return nil, err
+	}
+	return &SftpFS{root: remoteRealpath(root, session.Client), session: session}, nil
+}
+
+func (f *SftpFS) Close() error {
+	return f.session.Close()
+}
+
+func (f *SftpFS) path(op, name string) (string, error) {
+	if !fs.ValidPath(name) {
+		return "", &fs.PathError{Op: op, Path: name, Err: fs.ErrInvalid}
+	}
+	return path.Join(f.root, name), nil
+}
+
+func (f *SftpFS) Open(name string) (fs.File, error) {
+	p, err := f.path("open", name)
+	if err != nil {
+		return nil, err
+	}
+	info, err := f.session.Stat(p)
+	if err != nil {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: err}
+	}
+	if info.IsDir() {
+		return &sftpDir{fsys: f, name: name, info: info}, nil
+	}
+	file, err := f.session.Open(p)
+	if err != nil {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: err}
+	}
+	return file, nil
+}
+
+func (f *SftpFS) Stat(name string) (fs.FileInfo, error) {
+	p, err := f.path("stat", name)
+	if err != nil {
+		return nil, err
+	}
+	info, err := f.session.Stat(p)
+	if err != nil {
+		return nil, &fs.PathError{Op: "stat", Path: name, Err: err}
+	}
+	return info, nil
+}
+
+// ReadDir 按文件名排序
+func (f *SftpFS) ReadDir(name string) ([]fs.DirEntry, error) {
+	p, err := f.path("readdir", name)
+	if err != nil {
+		return nil, err
+	}
+	infos, err := f.session.ReadDir(p)
+	if err != nil {
+		return nil, &fs.PathError{Op: "readdir", Path: name, Err: err}
+	}
+	entries := make([]fs.DirEntry, 0, len(infos))
+	for _, info := range infos {
+		entries = append(entries, fs.FileInfoToDirEntry(info))
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].Name() < entries[j].Name()
+	})
+	return entries, nil
+}
+
+func (f *SftpFS) ReadFile(name string) ([]byte, error) {
+	file, err := f.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	return io.ReadAll(file)
+}
+
+// sftpDir SftpFS.Open 打开目录时返回，第一次 ReadDir 时读取全部目录项
+type sftpDir struct {
+	fsys    *SftpFS
+	name    string
+	info    fs.FileInfo
+	entries []fs.DirEntry
+	loaded  bool
+}
+
+func (d *sftpDir) Stat() (fs.FileInfo, error) {
+	return d.info, nil
+}
+
+func (d *sftpDir) Read([]byte) (int, error) {
+	return 0, &fs.PathError{Op: "read", Path: d.name, Err: fs.ErrInvalid}
+}
+
+func (d *sftpDir) Close() error {
+	return nil
+}
+
+func (d *sftpDir) ReadDir(n int) ([]fs.DirEntry, error) {
+	if !d.loaded {
+		entries, err := d.fsys.ReadDir(d.name)
+		if err != nil {
+			return nil, err
+		}
+		d.entries, d.loaded = entries, true
+	}
+	if n <= 0 {
+		entries := d.entries
+		d.entries = nil
+		return entries, nil
+	}
+	if len(d.entries) == 0 {
+		return nil, io.EOF
+	}
+	if n > len(d.entries) {
+		n = len(d.entries)
+	}
+	entries := d.entries[:n]
+	d.entries = d.entries[n:]
+	return entries, nil
+}
@@ -0,0 +1,58 @@
+package ssh
+
+import (
+	"io"
+	"io/fs"
+	"testing"
+	"time"
+)
+
+type fakeInfo struct {
+	name string
+	dir  bool
+}
+
+func (f fakeInfo) Name() string       { return f.name }
+func (f fakeInfo) Size() int64        { return 0 }
+func (f fakeInfo) Mode() fs.FileMode  { return 0644 }
+func (f fakeInfo) ModTime() time.Time { return time.Time{} }
+func (f fakeInfo) IsDir() bool        { return f.dir }
+func (f fakeInfo) Sys() interface{}   { return nil }
+
+func TestSftpFS_path(t *testing.T) {
+	f := &SftpFS{root: "/srv/www"}
+	if p, err := f.path("open", "templates/index.html"); err != nil || p != "/srv/www/templates/index.html" {
+		t.Errorf("path = %q, %v", p, err)
+	}
+	if p, err := f.path("open", "."); err != nil || p != "/srv/www" {
+		t.Errorf("root path = %q, %v", p, err)
+	}
+	for _, name := range []string{"../etc/passwd", "/etc/passwd", "a/./b"} {
+		if _, err := f.path("open", name); err == nil {
+			t.Errorf("path(%q) should be invalid", name)
+		}
+	}
+}
+
+func TestSftpDir_ReadDir(t *testing.T) {
+	entries := []fs.DirEntry{
+		fs.FileInfoToDirEntry(fakeInfo{name: "a"}),
+		fs.FileInfoToDirEntry(fakeInfo{name: "b", dir: true}),
+		fs.FileInfoToDirEntry(fakeInfo{name: "c"}),
+	}
+	d := &sftpDir{name: ".", entries: entries, loaded: true}
+	got, err := d.ReadDir(2)
+	if err != nil || len(got) != 2 || got[1].Name() != "b" || !got[1].IsDir() {
+		t.Fatalf("ReadDir(2) = %v, %v", got, err)
+	}
+	got, err = d.ReadDir(2)
+	if err != nil || len(got) != 1 || got[0].Name() != "c" {
+		t.Fatalf("ReadDir(2) = %v, %v", got, err)
+	}
+	if _, err = d.ReadDir(1); err != io.EOF {
+		t.Errorf("ReadDir at end = %v", err)
+	}
+	if got, err = d.ReadDir(-1); err != nil || len(got) != 0 {
+		t.Errorf("ReadDir(-1) at end = %v, %v", got, err)
+	}
+}
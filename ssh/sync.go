@@ -0,0 +1,623 @@
+package ssh
+
+import (
+	"fmt"
+	"github.com/pkg/sftp"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// syncFS Sync 两端文件系统的公共操作，路径都是对应系统上的真实路径
+type syncFS interface {
+	Stat(name string) (os.FileInfo, error)
+	Lstat(name string) (os.FileInfo, error)
+	// ReadDir 按文件名排序，不跟随符号链接
+	ReadDir(name string) ([]os.FileInfo, error)
+	Open(name string) (io.ReadCloser, error)
+	Create(name string) (io.WriteCloser, error)
+	// Mkdir 目录已存在时不返回错误
+	Mkdir(name string) error
+	// Remove 删除文件或空目录
+	Remove(name string) error
+	Chmod(name string, mode os.FileMode) error
+	Chtimes(name string, atime, mtime time.Time) error
+	Chown(name string, uid, gid int) error
+	Readlink(name string) (string, error)
+	// Symlink 创建指向 target 的符号链接 name
+	Symlink(target, name string) error
+	// Realpath 解析所有符号链接后的绝对路径
+	Realpath(name string) (string, error)
+	Join(elem ...string) string
+	SHA256(name string) (string, error)
+}
+
+type localFS struct{}
+
+func (localFS) Stat(name string) (os.FileInfo, error) {
+	return os.Stat(name)
+}
+
+func (localFS) Lstat(name string) (os.FileInfo, error) {
+	return os.Lstat(name)
+}
+
+func (localFS) ReadDir(name string) ([]os.FileInfo, error) {
+	entries, err := os.ReadDir(name)
+	if err != nil {
+		return nil, err
+	}
+	infos := make([]os.FileInfo, 0, len(entries))
+	for _, entry := range entries {
+		info, infoErr := entry.Info()
+		if infoErr != nil {
+			return nil, infoErr
+		}
+		infos = append(infos, info)
+	}
+	return infos, nil
+}
+
+func (localFS) Open(name string) (io.ReadCloser, error) {
+	return os.Open(name)
+}
+
+func (localFS) Create(name string) (io.WriteCloser, error) {
+	return os.Create(name)
+}
+
+func (localFS) Mkdir(name string) error {
+	if err := os.Mkdir(name, 0755); err != nil && !os.IsExist(err) {
+		return err
+	}
+	return nil
+}
+
+func (localFS) Remove(name string) error {
+	return os.Remove(name)
+}
+
+func (localFS) Chmod(name string, mode os.FileMode) error {
+	return os.Chmod(name, mode)
+}
+
+func (localFS) Chtimes(name string, atime, mtime time.Time) error {
+	return os.Chtimes(name, atime, mtime)
+}
+
+func (localFS) Chown(name string, uid, gid int) error {
+	return os.Chown(name, uid, gid)
+}
+
+func (localFS) Readlink(name string) (string, error) {
+	return os.Readlink(name)
+}
+
+func (localFS) Symlink(target, name string) error {
+	return os.Symlink(target, name)
+}
+
+func (localFS) Realpath(name string) (string, error) {
+	p, err := filepath.EvalSymlinks(name)
+	if err != nil {
+		return "", err
+	}
+	return filepath.Abs(p)
+}
+
+func (localFS) Join(elem ...string) string {
+	return filepath.Join(elem...)
+}
+
+func (localFS) SHA256(name string) (string, error) {
+	return localSHA256(name)
+}
+
+type remoteFS struct {
+	c    *ClientType
+	conn *sftpConn
+}
+
+func (r *remoteFS) Stat(name string) (os.FileInfo, error) {
+	return r.conn.client().Stat(name)
+}
+
+func (r *remoteFS) Lstat(name string) (os.FileInfo, error) {
+	return r.conn.client().Lstat(name)
+}
+
+func (r *remoteFS) ReadDir(name string) ([]os.FileInfo, error) {
+	infos, err := r.conn.client().ReadDir(name)
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(infos, func(i, j int) bool {
+		return infos[i].Name() < infos[j].Name()
+	})
+	return infos, nil
+}
+
+func (r *remoteFS) Open(name string) (io.ReadCloser, error) {
+	return r.conn.client().Open(name)
+}
+
+func (r *remoteFS) Create(name string) (io.WriteCloser, error) {
+	return r.conn.client().Create(name)
+}
+
+func (r *remoteFS) Mkdir(name string) error {
+	return remoteMkdir(r.conn.client(), name)
+}
+
+func (r *remoteFS) Remove(name string) error {
+	return r.conn.client().Remove(name)
+}
+
+func (r *remoteFS) Chmod(name string, mode os.FileMode) error {
+	return r.conn.client().Chmod(name, mode)
+}
+
+func (r *remoteFS) Chtimes(name string, atime, mtime time.Time) error {
+	return r.conn.client().Chtimes(name, atime, mtime)
+}
+
+func (r *remoteFS) Chown(name string, uid, gid int) error {
+	return r.conn.client().Chown(name, uid, gid)
+}
+
+func (r *remoteFS) Readlink(name string) (string, error) {
+	return r.conn.client().ReadLink(name)
+}
+
+func (r *remoteFS) Symlink(target, name string) error {
+	return r.conn.client().Symlink(target, name)
+}
+
+func (r *remoteFS) Realpath(name string) (string, error) {
+	return r.conn.client().RealPath(name)
+}
+
+func (r *remoteFS) Join(elem ...string) string {
+	return path.Join(elem...)
+}
+
+func (r *remoteFS) SHA256(name string) (string, error) {
+	return r.c.remoteSHA256(r.conn.client(), name)
+}
+
+// remoteMkdir 创建远程目录，已经存在时不返回错误
+func remoteMkdir(cli *sftp.Client, name string) error {
+	err := cli.Mkdir(name)
+	if err == nil {
+		return nil
+	}
+	if stat, statErr := cli.Stat(name); statErr == nil && stat.IsDir() {
+		return nil
+	}
+	return err
+}
+
+type syncFilter struct {
+	include bool
+	pattern string
+}
+
+type syncer struct {
+	checksum      bool
+	delete        bool
+	dryRun        bool
+	preservePerms bool
+	preserveTimes bool
+	filters       []syncFilter
+	output        io.Writer
+}
+
+type SyncOption func(*syncer)
+
+// WithChecksum 通过 SHA-256 判断文件是否变化，默认比较大小和修改时间
+func WithChecksum(checksum bool) SyncOption {
+	return func(s *syncer) {
+		s.checksum = checksum
+	}
+}
+
+// WithDelete 删除目标目录中源目录没有的文件，被过滤规则排除的文件不会被删除
+func WithDelete(del bool) SyncOption {
+	return func(s *syncer) {
+		s.delete = del
+	}
+}
+
+// WithDryRun 只输出和返回将要进行的操作，不做任何修改
+func WithDryRun(dryRun bool) SyncOption {
+	return func(s *syncer) {
+		s.dryRun = dryRun
+	}
+}
+
+// WithPreserve 是否保留文件权限和修改时间，默认都保留。不保留修改时间时，
+// 下次同步按大小和修改时间比较会认为文件都有变化
+func WithPreserve(perms, times bool) SyncOption {
+	return func(s *syncer) {
+		s.preservePerms = perms
+		s.preserveTimes = times
+	}
+}
+
+// WithInclude 同步匹配 patterns 的文件。include/exclude 规则按添加顺序匹配，第一个匹配的规则生效，
+// 没有匹配任何规则的文件默认同步；只有 include 规则时，没有匹配的文件不同步，目录仍然会遍历
+func WithInclude(patterns ...string) SyncOption {
+	return func(s *syncer) {
+		for _, p := range patterns {
+			s.filters = append(s.filters, syncFilter{include: true, pattern: p})
+		}
+	}
+}
+
+// WithExclude 不同步匹配 patterns 的文件和目录。pattern 使用 path.Match 语法，
+// 包含 / 时匹配相对源目录的路径，否则匹配文件名
+func WithExclude(patterns ...string) SyncOption {
+	return func(s *syncer) {
+		for _, p := range patterns {
+			s.filters = append(s.filters, syncFilter{pattern: p})
+		}
+	}
+}
+
+// WithSyncOutput 逐行输出每个操作：+ 新建，~ 更新，a 只更新权限和时间，- 删除，d 创建目录
+func WithSyncOutput(w io.Writer) SyncOption {
+	return func(s *syncer) {
+		s.output = w
+	}
+}
+
+// SyncReport 同步结果，路径都是相对源目录的路径
+type SyncReport struct {
+	Created []string
+	Updated []string
+	Deleted []string
+	// Skipped 不是普通文件或目录（如符号链接、设备文件）而跳过的路径
+	Skipped   []string
+	Unchanged int
+	// Bytes 需要传输的字节数
+	Bytes  int64
+	DryRun bool
+}
+
+const (
+	syncCreate = '+'
+	syncUpdate = '~'
+	syncAttrs  = 'a'
+	syncDelete = '-'
+	syncMkdir  = 'd'
+)
+
+type syncAction struct {
+	op   byte
+	rel  string
+	src  string
+	dst  string
+	info os.FileInfo
+}
+
+func matchPattern(pattern, rel string) bool {
+	if strings.Contains(pattern, "/") {
+		ok, _ := path.Match(strings.Trim(pattern, "/"), rel)
+		return ok
+	}
+	ok, _ := path.Match(pattern, path.Base(rel))
+	return ok
+}
+
+func (s *syncer) excluded(rel string, dir bool) bool {
+	includeOnly := len(s.filters) > 0
+	for _, f := range s.filters {
+		if matchPattern(f.pattern, rel) {
+			return !f.include
+		}
+		includeOnly = includeOnly && f.include
+	}
+	return includeOnly && !dir
+}
+
+// changed 判断是否需要传输，以及内容相同时是否需要更新权限和时间
+func (s *syncer) changed(srcFS, dstFS syncFS, src, dst string, srcInfo, dstInfo os.FileInfo) (bool, bool, error) {
+	if srcInfo.Size() != dstInfo.Size() {
+		return true, false, nil
+	}
+	sameTime := srcInfo.ModTime().Unix() == dstInfo.ModTime().Unix()
+	if s.checksum {
+		srcSum, err := srcFS.SHA256(src)
+		if err != nil {
+			return false, false, err
+		}
+		dstSum, err := dstFS.SHA256(dst)
+		if err != nil {
+			return false, false, err
+		}
+		if srcSum != dstSum {
+			return true, false, nil
+		}
+	} else if !sameTime {
+		return true, false, nil
+	}
+	attrs := (s.preservePerms && srcInfo.Mode().Perm() != dstInfo.Mode().Perm()) ||
+		(s.preserveTimes && !sameTime)
+	return false, attrs, nil
+}
+
+// plan 比较 src 和 dst 两个目录，dstExists 为 false 时 dst 下的所有文件都视为不存在
+func (s *syncer) plan(srcFS, dstFS syncFS, src, dst, rel string, dstExists bool, report *SyncReport) ([]syncAction, error) {
+	srcEntries, err := srcFS.ReadDir(src)
+	if err != nil {
+		return nil, err
+	}
+	var dstList []os.FileInfo
+	if dstExists {
+		if dstList, err = dstFS.ReadDir(dst); err != nil {
+			return nil, err
+		}
+	}
+	dstEntries := make(map[string]os.FileInfo, len(dstList))
+	for _, entry := range dstList {
+		dstEntries[entry.Name()] = entry
+	}
+
+	actions := make([]syncAction, 0)
+	seen := make(map[string]bool)
+	for _, info := range srcEntries {
+		name := info.Name()
+		childRel := path.Join(rel, name)
+		childSrc, childDst := srcFS.Join(src, name), dstFS.Join(dst, name)
+		if s.excluded(childRel, info.IsDir()) {
+			continue
+		}
+		seen[name] = true
+		dstInfo, exists := dstEntries[name]
+
+		switch {
+		case info.IsDir():
+			if exists && !dstInfo.IsDir() {
+				return nil, fmt.Errorf("sync %s: destination is not a directory", childRel)
+			}
+			if !exists {
+				actions = append(actions, syncAction{op: syncMkdir, rel: childRel, dst: childDst, info: info})
+			}
+			children, planErr := s.plan(srcFS, dstFS, childSrc, childDst, childRel, exists, report)
+			if planErr != nil {
+				return nil, planErr
+			}
+			actions = append(actions, children...)
+			actions = append(actions, syncAction{op: syncAttrs, rel: childRel, dst: childDst, info: info})
+		case info.Mode().IsRegular():
+			action := syncAction{op: syncCreate, rel: childRel, src: childSrc, dst: childDst, info: info}
+			if exists {
+				if !dstInfo.Mode().IsRegular() {
+					return nil, fmt.Errorf("sync %s: destination is not a regular file", childRel)
+				}
+				transfer, attrs, changeErr := s.changed(srcFS, dstFS, childSrc, childDst, info, dstInfo)
+				if changeErr != nil {
+					return nil, changeErr
+				}
+				switch {
+				case transfer:
+					action.op = syncUpdate
+				case attrs:
+					action.op = syncAttrs
+				default:
+					report.Unchanged++
+					continue
+				}
+			}
+			actions = append(actions, action)
+		default:
+			report.Skipped = append(report.Skipped, childRel)
+		}
+	}
+
+	if s.delete {
+		for _, info := range dstList {
+			name := info.Name()
+			childRel := path.Join(rel, name)
+			if seen[name] || s.excluded(childRel, info.IsDir()) {
+				continue
+			}
+			actions = append(actions, syncAction{op: syncDelete, rel: childRel, dst: dstFS.Join(dst, name), info: info})
+		}
+	}
+	return actions, nil
+}
+
+func removeAll(fsys syncFS, name string, info os.FileInfo) error {
+	if info.IsDir() {
+		entries, err := fsys.ReadDir(name)
+		if err != nil {
+			return err
+		}
+		for _, entry := range entries {
+			if err := removeAll(fsys, fsys.Join(name, entry.Name()), entry); err != nil {
+				return err
+			}
+		}
+	}
+	return fsys.Remove(name)
+}
+
+func (s *syncer) setAttrs(dstFS syncFS, action syncAction) error {
+	if s.preservePerms {
+		if err := dstFS.Chmod(action.dst, action.info.Mode().Perm()); err != nil {
+			return err
+		}
+	}
+	if s.preserveTimes {
+		if err := dstFS.Chtimes(action.dst, action.info.ModTime(), action.info.ModTime()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *syncer) copyFile(srcFS, dstFS syncFS, action syncAction, p fileProgress) error {
+	srcFile, err := srcFS.Open(action.src)
+	if err != nil {
+		return err
+	}
+	defer srcFile.Close()
+	dstFile, err := dstFS.Create(action.dst)
+	if err != nil {
+		return err
+	}
+	defer dstFile.Close()
+
+	p.start(action.info.Size(), 0)
+	if _, err := io.Copy(io.MultiWriter(dstFile, p), srcFile); err != nil {
+		return err
+	}
+	if err := dstFile.Close(); err != nil {
+		return err
+	}
+	return s.setAttrs(dstFS, action)
+}
+
+func (s *syncer) apply(srcFS, dstFS syncFS, action syncAction, p fileProgress) error {
+	switch action.op {
+	case syncMkdir:
+		return dstFS.Mkdir(action.dst)
+	case syncCreate, syncUpdate:
+		return p.done(s.copyFile(srcFS, dstFS, action, p))
+	case syncAttrs:
+		return s.setAttrs(dstFS, action)
+	case syncDelete:
+		return removeAll(dstFS, action.dst, action.info)
+	}
+	return nil
+}
+
+func (c *ClientType) sync(srcFS, dstFS syncFS, src, dst string, option []SyncOption) (*SyncReport, error) {
+	s := &syncer{preservePerms: true, preserveTimes: true}
+	for _, opt := range option {
+		opt(s)
+	}
+	report := &SyncReport{DryRun: s.dryRun}
+
+	srcInfo, err := srcFS.Stat(src)
+	if err != nil {
+		return report, err
+	}
+	dstInfo, dstErr := dstFS.Stat(dst)
+	dstExists := dstErr == nil
+	if dstErr != nil && !os.IsNotExist(dstErr) {
+		return report, dstErr
+	}
+
+	var actions []syncAction
+	if srcInfo.IsDir() {
+		if dstExists && !dstInfo.IsDir() {
+			return report, fmt.Errorf("sync %s: destination is not a directory", dst)
+		}
+		if !dstExists {
+			actions = append(actions, syncAction{op: syncMkdir, rel: ".", dst: dst, info: srcInfo})
+		}
+		children, planErr := s.plan(srcFS, dstFS, src, dst, "", dstExists, report)
+		if planErr != nil {
+			return report, planErr
+		}
+		actions = append(actions, children...)
+		actions = append(actions, syncAction{op: syncAttrs, rel: ".", dst: dst, info: srcInfo})
+	} else {
+		// 源是单个文件时，dst 为目录则同步到目录下的同名文件
+		if dstExists && dstInfo.IsDir() {
+			dst = dstFS.Join(dst, srcInfo.Name())
+			dstInfo, dstErr = dstFS.Stat(dst)
+			dstExists = dstErr == nil
+		}
+		action := syncAction{op: syncCreate, rel: srcInfo.Name(), src: src, dst: dst, info: srcInfo}
+		unchanged := false
+		if dstExists {
+			transfer, attrs, changeErr := s.changed(srcFS, dstFS, src, dst, srcInfo, dstInfo)
+			if changeErr != nil {
+				return report, changeErr
+			}
+			switch {
+			case transfer:
+				action.op = syncUpdate
+			case attrs:
+				action.op = syncAttrs
+			default:
+				unchanged = true
+				report.Unchanged++
+			}
+		}
+		if !unchanged {
+			actions = append(actions, action)
+		}
+	}
+
+	for _, action := range actions {
+		switch action.op {
+		case syncCreate:
+			report.Created = append(report.Created, action.rel)
+			report.Bytes += action.info.Size()
+		case syncUpdate:
+			report.Updated = append(report.Updated, action.rel)
+			report.Bytes += action.info.Size()
+		case syncDelete:
+			report.Deleted = append(report.Deleted, action.rel)
+		}
+	}
+	if s.output != nil {
+		for _, action := range actions {
+			// 目录的权限和时间每次都会重新设置，不输出
+			if action.op == syncAttrs && action.info.IsDir() {
+				continue
+			}
+			rel := action.rel
+			if action.info.IsDir() {
+				rel += "/"
+			}
+			fmt.Fprintf(s.output, "%c %s\n", action.op, rel)
+		}
+	}
+	if s.dryRun {
+		return report, nil
+	}
+
+	progress := c.progress()
+	progress.Start(path.Base(filepath.ToSlash(src)), len(report.Created)+len(report.Updated), report.Bytes)
+	for _, action := range actions {
+		if err := s.apply(srcFS, dstFS, action, fileProgress{Progress: progress, name: action.rel}); err != nil {
+			err = fmt.Errorf("sync %s error: %w", action.rel, err)
+			progress.Finish(err)
+			return report, err
+		}
+	}
+	progress.Finish(nil)
+	return report, nil
+}
+
+// Sync 把本地的 src 同步到远程的 dst，只传输有变化的文件，类似 rsync -a src/ dst。
+// src 是目录时同步目录下的内容，dst 不存在时自动创建
+func (c *ClientType) Sync(src, dst string, option ...SyncOption) (*SyncReport, error) {
+	sftpClient, sftpErr := c.newSftpConn()
+	if sftpErr != nil {
+		return nil, sftpErr
+	}
+	defer sftpClient.Close()
+	remote := &remoteFS{c: c, conn: sftpClient}
+	return c.sync(localFS{}, remote, localRealPath(src), remoteRealpath(dst, sftpClient.client()), option)
+}
+
+// SyncGet 把远程的 src 同步到本地的 dst，选项同 Sync
+func (c *ClientType) SyncGet(src, dst string, option ...SyncOption) (*SyncReport, error) {
+	sftpClient, sftpErr := c.newSftpConn()
+	if sftpErr != nil {
+		return nil, sftpErr
+	}
+	defer sftpClient.Close()
+	remote := &remoteFS{c: c, conn: sftpClient}
+	return c.sync(remote, localFS{}, remoteRealpath(src, sftpClient.client()), localRealPath(dst), option)
+}
@@ -0,0 +1,148 @@
+package ssh
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func writeTree(t *testing.T, root string, files map[string]string) {
+	for name, content := range files {
+		p := filepath.Join(root, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(p, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestMatchPattern(t *testing.T) {
+	cases := []struct {
+		pattern, rel string
+		want         bool
+	}{
+		{"*.log", "a/b/c.log", true},
+		{"*.log", "a/b/c.txt", false},
+		{"a/*.log", "a/c.log", true},
+		{"/a/*.log", "a/c.log", true},
+		{"a/*.log", "b/a/c.log", false},
+		{"node_modules", "web/node_modules", true},
+	}
+	for _, c := range cases {
+		if got := matchPattern(c.pattern, c.rel); got != c.want {
+			t.Errorf("matchPattern(%q, %q) = %v", c.pattern, c.rel, got)
+		}
+	}
+}
+
+func TestSyncer_Excluded(t *testing.T) {
+	s := &syncer{}
+	WithInclude("keep.log")(s)
+	WithExclude("*.log")(s)
+	if s.excluded("a/keep.log", false) || !s.excluded("a/drop.log", false) || s.excluded("a/b.txt", false) {
+		t.Error("exclude rules mismatch")
+	}
+
+	s = &syncer{}
+	WithInclude("*.go")(s)
+	if s.excluded("main.go", false) || !s.excluded("README.md", false) || s.excluded("pkg", true) {
+		t.Error("include-only rules mismatch")
+	}
+}
+
+func TestClientType_sync(t *testing.T) {
+	src, dst := t.TempDir(), filepath.Join(t.TempDir(), "dst")
+	writeTree(t, src, map[string]string{
+		"a.txt":       "a",
+		"dir/b.txt":   "b",
+		"dir/c.log":   "c",
+		"keep/d.txt":  "d",
+		"empty/.keep": "",
+	})
+	old := time.Now().Add(-time.Hour).Truncate(time.Second)
+	os.Chtimes(filepath.Join(src, "a.txt"), old, old)
+	os.Chmod(filepath.Join(src, "dir", "b.txt"), 0600)
+
+	c := &ClientType{}
+	report, err := c.sync(localFS{}, localFS{}, src, dst, []SyncOption{WithExclude("*.log")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"a.txt", "dir/b.txt", "empty/.keep", "keep/d.txt"}
+	if !reflect.DeepEqual(report.Created, want) || report.Bytes != 3 {
+		t.Errorf("created = %v, bytes = %d", report.Created, report.Bytes)
+	}
+	if _, err := os.Stat(filepath.Join(dst, "dir", "c.log")); !os.IsNotExist(err) {
+		t.Error("excluded file should not be synced")
+	}
+	if stat, _ := os.Stat(filepath.Join(dst, "a.txt")); !stat.ModTime().Equal(old) {
+		t.Errorf("mtime = %v, want %v", stat.ModTime(), old)
+	}
+	if stat, _ := os.Stat(filepath.Join(dst, "dir", "b.txt")); stat.Mode().Perm() != 0600 {
+		t.Errorf("mode = %v", stat.Mode())
+	}
+
+	// 第二次同步没有变化
+	report, err = c.sync(localFS{}, localFS{}, src, dst, []SyncOption{WithExclude("*.log")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(report.Created)+len(report.Updated) != 0 || report.Unchanged != 4 {
+		t.Errorf("second sync = %+v", report)
+	}
+
+	// 修改、新增、删除后 dry-run
+	writeTree(t, src, map[string]string{"a.txt": "aa", "new.txt": "n"})
+	os.RemoveAll(filepath.Join(src, "keep"))
+	writeTree(t, dst, map[string]string{"extra.log": "x"})
+	var out bytes.Buffer
+	opts := []SyncOption{WithExclude("*.log"), WithDelete(true), WithSyncOutput(&out)}
+	report, err = c.sync(localFS{}, localFS{}, src, dst, append(opts, WithDryRun(true)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := out.String(); got != "~ a.txt\n+ new.txt\n- keep/\n" {
+		t.Errorf("dry-run output = %q", got)
+	}
+	if _, err := os.Stat(filepath.Join(dst, "keep")); err != nil {
+		t.Error("dry-run should not delete")
+	}
+
+	if _, err = c.sync(localFS{}, localFS{}, src, dst, opts); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Join(dst, "keep")); !os.IsNotExist(err) {
+		t.Error("extraneous directory should be deleted")
+	}
+	if _, err := os.Stat(filepath.Join(dst, "extra.log")); err != nil {
+		t.Error("excluded file should not be deleted")
+	}
+	if b, _ := os.ReadFile(filepath.Join(dst, "a.txt")); string(b) != "aa" {
+		t.Errorf("a.txt = %q", b)
+	}
+}
+
+func TestClientType_sync_Checksum(t *testing.T) {
+	src, dst := t.TempDir(), t.TempDir()
+	writeTree(t, src, map[string]string{"a.txt": "new"})
+	writeTree(t, dst, map[string]string{"a.txt": "old"})
+	now := time.Now().Truncate(time.Second)
+	os.Chtimes(filepath.Join(src, "a.txt"), now, now)
+	os.Chtimes(filepath.Join(dst, "a.txt"), now, now)
+
+	c := &ClientType{}
+	report, err := c.sync(localFS{}, localFS{}, src, dst, nil)
+	if err != nil || report.Unchanged != 1 {
+		t.Fatalf("size/mtime sync = %+v, %v", report, err)
+	}
+	report, err = c.sync(localFS{}, localFS{}, src, dst, []SyncOption{WithChecksum(true)})
+	if err != nil || strings.Join(report.Updated, ",") != "a.txt" {
+		t.Fatalf("checksum sync = %+v, %v", report, err)
+	}
+}
@@ -1,14 +1,13 @@
 package ssh
 
 import (
+	"context"
+	"errors"
 	"fmt"
-	"github.com/kr/fs"
 	"github.com/pkg/sftp"
 	"golang.org/x/crypto/ssh"
 	terminal "golang.org/x/term"
-	"gopkg.in/cheggaaa/pb.v1"
 	"io"
-	"net"
 	"os"
 	"path"
 	"path/filepath"
@@ -20,90 +19,78 @@ import (
 type Option func(*ClientType)
 
 type ClientType struct {
-	session *ssh.Session
-	client  *ssh.Client
-	pb      bool
+	// mu 保护 client，断线重连时会被替换
+	mu     sync.RWMutex
+	client *ssh.Client
+	// dial 建立新的连接，用于断线重连
+	dial func() (*ssh.Client, error)
+	// newProgress 为每次传输创建进度回调，nil 表示不报告进度
+	newProgress func() Progress
+	// noSftp 为 1 时服务端没有 sftp 子系统，文件传输使用 SCP
+	noSftp int32
+	// record Login 的录像设置，nil 表示不录像
+	record *recordConfig
+	// sessions 限制同时打开的 session 数量，nil 表示不限制
+	sessions chan struct{}
+	// killGrace RunContext 取消后从 TERM 到 KILL 的等待时间
+	killGrace time.Duration
+	// jump 跳板链的最后一跳，Close 时释放
+	jump *bastion
+
+	keepAlive keepAliveConfig
+	reconnect reconnectConfig
+	onState   func(state ConnState, err error)
+	state     ConnState
+	closed    chan struct{}
+	closeOnce sync.Once
+	// failed 重连失败、监控退出后关闭，failErr 为最后一次重连的错误
+	failed  chan struct{}
+	failErr error
+
+	tunnelMu sync.Mutex
+	tunnels  map[*Tunnel]struct{}
 }
 
+// NewClient 连接 conf.Address，配置了 JumpHosts 时依次通过跳板机连接
 func NewClient(conf *AuthConfig, option ...Option) (Client, error) {
-	clientCfg, cfgErr := authConfig(conf)
-	if cfgErr != nil {
-		return nil, cfgErr
-	}
+	tp := &ClientType{killGrace: DefaultKillGrace, closed: make(chan struct{}), failed: make(chan struct{})}
+	tp.dial = func() (*ssh.Client, error) {
+		clientCfg, release, cfgErr := authConfig(conf)
+		defer release()
+		if cfgErr != nil {
+			return nil, cfgErr
+		}
+		hop, err := jumpPool.acquire(conf.JumpHosts)
+		if err != nil {
+			return nil, err
+		}
 
-	cli, err := ssh.Dial(conf.Network, conf.Address, clientCfg)
-	if err != nil {
-		return nil, fmt.Errorf("connect error: %w", err)
+		conn, err := dialVia(hop, &conf.NetworkConfig, clientCfg)
+		if err != nil {
+			jumpPool.release(hop)
+			return nil, fmt.Errorf("connect error: %w", err)
+		}
+		cli, err := handshake(conn, conf.Address, clientCfg)
+		if err != nil {
+			jumpPool.release(hop)
+			return nil, fmt.Errorf("connect error: %w", err)
+		}
+		tp.setJump(hop)
+		return cli, nil
 	}
-	session, getSessionErr := cli.NewSession()
-	if getSessionErr != nil {
-		return nil, getSessionErr
+	cli, err := tp.dial()
+	if err != nil {
+		return nil, err
 	}
-	tp := &ClientType{client: cli, session: session}
+	tp.client = cli
+	WithMaxSessions(DefaultMaxSessions)(tp)
 	for _, opt := range option {
 		opt(tp)
 	}
+	tp.startMonitor()
 	return tp, nil
 }
 
-func authConfig(conf *AuthConfig) (*ssh.ClientConfig, error) {
-	auth := make([]ssh.AuthMethod, 0)
-	if conf.Password != "" {
-		auth = append(auth, ssh.Password(conf.Password))
-	} else {
-		if conf.PrivateKey == "" {
-			conf.PrivateKey = "~/.ssh/id_rsa"
-		}
-		var (
-			content []byte
-			readErr error
-		)
-		_, err := os.Stat(conf.PrivateKey)
-		if os.IsExist(err) {
-			content, readErr = os.ReadFile(conf.PrivateKey)
-			if readErr != nil {
-				return nil, fmt.Errorf("open private key %s error: %w", conf.PrivateKey, readErr)
-			}
-		} else {
-			content = []byte(conf.PrivateKey)
-		}
-		signer, parseErr := ssh.ParsePrivateKey(content)
-		if parseErr != nil {
-			return nil, fmt.Errorf("parse private key %s error: %w", conf.PrivateKey, parseErr)
-		}
-		auth = append(auth, ssh.PublicKeys(signer))
-	}
-
-	return &ssh.ClientConfig{
-		User:            conf.Username,
-		Auth:            auth,
-		HostKeyCallback: ssh.InsecureIgnoreHostKey(),
-		Timeout:         time.Duration(conf.ConnectTimeout) * time.Second,
-	}, nil
-}
-
-func totalSize(paths string) int64 {
-	var Ret int64
-	stat, _ := os.Stat(paths)
-	switch {
-	case stat.IsDir():
-		if err := filepath.Walk(paths, func(p string, info os.FileInfo, err error) error {
-			if info.IsDir() {
-				return nil
-			} else {
-				s, _ := os.Stat(p)
-				Ret = Ret + s.Size()
-				return nil
-			}
-		}); err != nil {
-			return 0
-		}
-		return Ret
-	default:
-		return stat.Size()
-	}
-}
-
 func localRealPath(ph string) string {
 	sl := strings.Split(ph, "/")
 	if sl[0] == "~" {
@@ -130,22 +117,12 @@ func remoteRealpath(ph string, c *sftp.Client) string {
 	return ph
 }
 
-func (c *ClientType) progressBar(title string, total int64) *pb.ProgressBar {
-	bar := pb.New64(total)
-	bar.SetUnits(pb.U_BYTES)
-	bar.ShowSpeed = true
-	bar.ShowTimeLeft = true
-	bar.ShowPercent = true
-	bar.Prefix(title)
-	return bar
-}
-
-func (c *ClientType) closeSession() error {
-	return c.session.Close()
-}
-
 func (c *ClientType) interactiveSession() error {
-	defer c.closeSession()
+	session, sessionErr := c.newSession()
+	if sessionErr != nil {
+		return sessionErr
+	}
+	defer c.closeSession(session)
 
 	fd := int(os.Stdin.Fd())
 	state, err := terminal.MakeRaw(fd)
@@ -164,34 +141,57 @@ func (c *ClientType) interactiveSession() error {
 		termType = "linux"
 	}
 
-	if requestPtyErr := c.session.RequestPty(termType, termHeight, termWidth, ssh.TerminalModes{}); requestPtyErr != nil {
-		return fmt.Errorf("c.session.RequestPty error: %w", requestPtyErr)
+	if requestPtyErr := session.RequestPty(termType, termHeight, termWidth, ssh.TerminalModes{}); requestPtyErr != nil {
+		return fmt.Errorf("session.RequestPty error: %w", requestPtyErr)
+	}
+	recorder, closeRecording, recordErr := c.startRecording(termWidth, termHeight, termType)
+	if recordErr != nil {
+		return recordErr
 	}
+	defer closeRecording()
+	resized := func(width, height int) {}
+	if recorder != nil {
+		resized = recorder.Resize
+	}
+
 	changeSizeErr := make(chan error)
+	done := make(chan struct{})
 	go func() {
 		for changeErr := range changeSizeErr {
 			fmt.Fprintf(os.Stderr, "updateTerminalSize err: %v", changeErr)
 		}
 	}()
-	go c.updateTerminalSize(fd, termWidth, termHeight, changeSizeErr)
-	defer close(changeSizeErr)
+	go func() {
+		c.updateTerminalSize(session, fd, termWidth, termHeight, changeSizeErr, done, resized)
+		close(changeSizeErr)
+	}()
+	defer close(done)
 
-	//c.stdin, err = c.session.StdinPipe()
+	//c.stdin, err = session.StdinPipe()
 	//if err != nil {
 	//	return err
 	//}
-	//c.stdout, err = c.session.StdoutPipe()
+	//c.stdout, err = session.StdoutPipe()
 	//if err != nil {
 	//	return err
 	//}
-	//c.stderr, err = c.session.StderrPipe()
+	//c.stderr, err = session.StderrPipe()
 	//if err != nil {
 	//	return err
 	//}
-	c.session.Stdout = os.Stdout
-	c.session.Stderr = os.Stderr
-	c.session.Stdin = os.Stdin
-	//go io.Copy(os.Stderr, c.session.Stderr)
+	session.Stdout = os.Stdout
+	session.Stderr = os.Stderr
+	session.Stdin = os.Stdin
+	if recorder != nil {
+		// pty 下 stderr 和 stdout 合并，共用一个 Output 保证字符不被拆开
+		output := recorder.Output()
+		session.Stdout = io.MultiWriter(os.Stdout, output)
+		session.Stderr = io.MultiWriter(os.Stderr, output)
+		if c.record.input {
+			session.Stdin = io.TeeReader(os.Stdin, recorder.Input())
+		}
+	}
+	//go io.Copy(os.Stderr, session.Stderr)
 	//go io.Copy(os.Stdout, c.stdout)
 	//go func() {
 	//	buf := make([]byte, 128)
@@ -212,10 +212,10 @@ func (c *ClientType) interactiveSession() error {
 	//	}
 	//}()
 
-	if err = c.session.Shell(); err != nil {
+	if err = session.Shell(); err != nil {
 		return err
 	}
-	return c.session.Wait()
+	return session.Wait()
 }
 
 func (c *ClientType) Login() error {
@@ -223,323 +223,184 @@ func (c *ClientType) Login() error {
 }
 
 func (c *ClientType) Run(cmd string, stdout, stderr io.Writer) error {
-	// close session
-	defer c.closeSession()
-	c.session.Stdout = stdout
-	c.session.Stderr = stderr
-	if err := c.session.Run(cmd); err != nil {
-		return err
-	}
-	return nil
+	return c.RunContext(context.Background(), cmd, stdout, stderr)
 }
 
-func (c *ClientType) PushFile(src string, dst string) error {
-	sftpClient, sftpErr := sftp.NewClient(c.client)
-	if sftpErr != nil {
-		return sftpErr
-	}
-	defer sftpClient.Close()
-	RealSrc := localRealPath(src)
-	RealDst := remoteRealpath(dst, sftpClient)
-	srcFile, openErr := os.Open(RealSrc)
-	if openErr != nil {
-		return openErr
+// PushDir 上传目录，src 目录本身会上传到 dst 下。文件并发传输，
+// 单个文件失败不影响其他文件，全部结束后返回汇总的 *TransferError
+func (c *ClientType) PushDir(src string, dst string, option ...TransferOption) error {
+	opt := newTransfer(option)
+	sftpClient, sftpErr := c.newSftpConn(opt.sftpOptions()...)
+	if errors.Is(sftpErr, ErrSftpUnavailable) {
+		return c.scpPush(src, dst, opt)
 	}
-	defer srcFile.Close()
-
-	dstFile, sftpCreateErr := sftpClient.Create(RealDst)
-	if sftpCreateErr != nil {
-		return sftpCreateErr
-	}
-	defer dstFile.Close()
-	SrcStat, err := srcFile.Stat()
-	if err != nil {
-		return err
-	}
-	var reader io.Reader = srcFile
-	if c.pb {
-		title := path.Base(RealSrc)
-		total := SrcStat.Size()
-		bar := c.progressBar(title, total)
-		bar.Start()
-		reader = bar.NewProxyReader(srcFile)
-		defer bar.Finish()
-	}
-
-	_, err = io.Copy(dstFile, reader)
-	return err
-}
-
-func (c *ClientType) GetFile(src string, dst string) error {
-	sftpClient, sftpErr := sftp.NewClient(c.client)
 	if sftpErr != nil {
 		return sftpErr
 	}
 	defer sftpClient.Close()
-	RealSrc := remoteRealpath(src, sftpClient)
-	RealDst := localRealPath(dst)
-
-	srcFile, sftpOpenErr := sftpClient.Open(RealSrc)
-	if sftpOpenErr != nil {
-		return sftpOpenErr
-	}
-	defer srcFile.Close()
-	SrcStat, err := srcFile.Stat()
-	if err != nil {
-		return err
-	}
-	dstFile, dstCreateErr := os.Create(RealDst)
-	if dstCreateErr != nil {
-		return dstCreateErr
-	}
-	defer dstFile.Close()
-
-	var writer io.Writer = dstFile
-	if c.pb {
-		title := path.Base(RealSrc)
-		total := SrcStat.Size()
-		bar := c.progressBar(title, total)
-		bar.Start()
-		defer bar.Finish()
-		writer = io.MultiWriter(bar, dstFile)
-	}
-	_, err = srcFile.WriteTo(writer)
-	return err
-}
-
-func (c *ClientType) PushDir(src string, dst string) error {
-	sftpClient, sftpErr := sftp.NewClient(c.client)
-	if sftpErr != nil {
-		return sftpErr
-	}
-	defer sftpClient.Close()
-	RealSrc := localRealPath(src)
-	RealDst := remoteRealpath(dst, sftpClient)
-
-	root, dir := path.Split(RealSrc)
-	if err := os.Chdir(root); err != nil {
-		return err
-	}
-	var bar *pb.ProgressBar
-	if c.pb {
-		total := totalSize(RealSrc)
-		title := path.Base(RealSrc)
-		bar = c.progressBar(title, total)
-		bar.Start()
-		defer bar.Finish()
-	}
-
-	var wg sync.WaitGroup
-	return filepath.Walk(dir, func(p string, info os.FileInfo, err error) error {
-		DstPath := path.Join(RealDst, p)
-		switch {
-		case info.IsDir():
-			if e := sftpClient.Mkdir(DstPath); e != nil {
-				return e
-			}
-		default:
-			wg.Add(1)
-			go func(w *sync.WaitGroup, b *pb.ProgressBar, SrcFile string, DstFile string) {
-				defer w.Done()
-				s, _ := os.Open(SrcFile)
-				defer s.Close()
-				d, _ := sftpClient.Create(DstFile)
-				defer d.Close()
-				i, _ := io.Copy(d, s)
-				if b != nil {
-					b.Add64(i)
-				}
-			}(&wg, bar, p, DstPath)
-		}
-		wg.Wait()
-		return err
+	RealSrc := filepath.Clean(localRealPath(src))
+	RealDst := remoteRealpath(dst, sftpClient.client())
+	remote := &remoteFS{c: c, conn: sftpClient}
+	return c.transferDir(localFS{}, remote, RealSrc, RealDst, opt, func(job fileJob, p fileProgress) error {
+		return c.pushOne(sftpClient, job.src, job.dst, opt, p)
 	})
 }
 
-func (c *ClientType) GetDir(src string, dst string) error {
-	sftpClient, sftpErr := sftp.NewClient(c.client)
+// GetDir 下载目录，src 目录本身会下载到 dst 下，错误处理同 PushDir
+func (c *ClientType) GetDir(src string, dst string, option ...TransferOption) error {
+	opt := newTransfer(option)
+	sftpClient, sftpErr := c.newSftpConn(opt.sftpOptions()...)
+	if errors.Is(sftpErr, ErrSftpUnavailable) {
+		return c.scpGet(src, dst, opt)
+	}
 	if sftpErr != nil {
 		return sftpErr
 	}
 	defer sftpClient.Close()
-
-	RealSrc := remoteRealpath(src, sftpClient)
+	RealSrc := path.Clean(remoteRealpath(src, sftpClient.client()))
 	RealDst := localRealPath(dst)
-	walker := sftpClient.Walk(RealSrc)
-	var bar *pb.ProgressBar
-	if c.pb {
-		//获取远程目录的大小
-		size := func(c *sftp.Client) int64 {
-			var ret int64
-			TotalWalk := c.Walk(RealSrc)
-			for TotalWalk.Step() {
-				stat := TotalWalk.Stat()
-				if !stat.IsDir() {
-					ret += stat.Size()
-				}
-			}
-			return ret
-		}(sftpClient)
-		title := path.Base(RealSrc)
-		bar = c.progressBar(title, size)
-		bar.Start()
-		defer bar.Finish()
-	}
-
-	//同步远程目录到本地
-	var wg sync.WaitGroup
-	base := path.Dir(RealSrc)
-	wg.Add(1)
-	go func(w *fs.Walker, c *sftp.Client, g *sync.WaitGroup, b *pb.ProgressBar) {
-		for w.Step() {
-			prefix := strings.TrimPrefix(w.Path(), base)
-			p := path.Join(RealDst, prefix)
-			stats := w.Stat()
-			switch {
-			case walker.Err() != nil:
-				panic(walker.Err())
-			case stats.IsDir():
-				os.Mkdir(p, 0755)
-			default:
-				files, _ := c.Open(w.Path())
-				ds, errs := os.Create(p)
-				if errs != nil {
-					panic(errs)
-				}
-				//io.Copy(ds,file)
-				i, e := io.Copy(ds, files)
-				if e != nil {
-					fmt.Println(e)
-				}
-				ds.Close()
-				files.Close()
-				if b != nil {
-					b.Add64(i)
-				}
-			}
-		}
-		g.Done()
-	}(walker, sftpClient, &wg, bar)
-	wg.Wait()
-	return nil
+	remote := &remoteFS{c: c, conn: sftpClient}
+	return c.transferDir(remote, localFS{}, RealSrc, RealDst, opt, func(job fileJob, p fileProgress) error {
+		return c.getOne(sftpClient, job.src, job.dst, opt, p)
+	})
 }
 
-func (c *ClientType) Get(src, dst string) error {
-
-	sftpCli, err := sftp.NewClient(c.client)
+// Get 下载文件或目录，服务端没有 sftp 子系统时使用 SCP
+func (c *ClientType) Get(src, dst string, option ...TransferOption) error {
+	sftpCli, err := c.newSftpClient()
+	if errors.Is(err, ErrSftpUnavailable) {
+		return c.scpGet(src, dst, newTransfer(option))
+	}
 	if err != nil {
 		return err
 	}
-	defer sftpCli.Close()
-	RealSrc := remoteRealpath(src, sftpCli)
+	RealSrc := remoteRealpath(src, sftpCli.Client)
 	RealDst := localRealPath(dst)
 	state, statErr := sftpCli.Stat(RealSrc)
+	// GetDir/GetFile 会重新打开 sftp，先归还 session 避免达到上限时死锁
+	sftpCli.Close()
 	if statErr != nil {
 		return statErr
 	}
 	if state.IsDir() {
-		return c.GetDir(RealSrc, RealDst)
+		return c.GetDir(RealSrc, RealDst, option...)
 	} else {
-		dstState, _ := os.Stat(RealDst)
-		if dstState.IsDir() {
-			return c.GetFile(RealSrc, filepath.Join(RealDst, filepath.Base(src)))
+		dstState, err := os.Stat(RealDst)
+		// 目标不存在时作为文件路径
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+		if dstState != nil && dstState.IsDir() {
+			return c.GetFile(RealSrc, filepath.Join(RealDst, filepath.Base(src)), option...)
 		} else {
-			return c.GetFile(RealSrc, RealDst)
+			return c.GetFile(RealSrc, RealDst, option...)
 		}
 	}
 }
 
-func (c *ClientType) Push(src, dst string) error {
-
+// Push 上传文件或目录，服务端没有 sftp 子系统时使用 SCP
+func (c *ClientType) Push(src, dst string, option ...TransferOption) error {
 	RealSrc := localRealPath(src)
 	SrcState, statErr := os.Stat(RealSrc)
 	if statErr != nil {
-		panic(statErr)
+		return statErr
 	}
 	if SrcState.IsDir() {
-		return c.PushDir(RealSrc, dst)
+		return c.PushDir(RealSrc, dst, option...)
 	} else {
-		sftpCli, err := sftp.NewClient(c.client)
+		sftpCli, err := c.newSftpClient()
+		if errors.Is(err, ErrSftpUnavailable) {
+			return c.scpPush(RealSrc, dst, newTransfer(option))
+		}
 		if err != nil {
 			return err
 		}
-		defer sftpCli.Close()
-		RealDst := remoteRealpath(dst, sftpCli)
-		dstErr, err := sftpCli.Stat(RealDst)
-		if err != nil {
-			panic(err)
+		RealDst := remoteRealpath(dst, sftpCli.Client)
+		dstState, err := sftpCli.Stat(RealDst)
+		sftpCli.Close()
+		// 目标不存在时作为文件路径
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
 		}
-		if dstErr.IsDir() {
-			return c.PushFile(RealSrc, filepath.Join(RealDst, filepath.Base(RealSrc)))
+		if dstState != nil && dstState.IsDir() {
+			return c.PushFile(RealSrc, path.Join(RealDst, filepath.Base(RealSrc)), option...)
 		} else {
-			return c.PushFile(RealSrc, RealDst)
+			return c.PushFile(RealSrc, RealDst, option...)
 		}
 	}
 }
 
+// TunnelStart 本地端口转发，阻塞直到隧道停止，不阻塞的用法见 StartTunnel
 func (c *ClientType) TunnelStart(Local, Remote NetworkConfig) error {
-	listener, err := net.Listen(Local.Network, Local.Address)
+	t, err := c.StartTunnel(context.Background(), Local, Remote)
 	if err != nil {
 		return err
 	}
-	defer listener.Close()
-
-	for {
-		conn, err := listener.Accept()
-		if err != nil {
-			return err
-		}
-		c.forward(conn, Remote)
-	}
+	return t.Wait()
 }
 
-func (c *ClientType) forward(localConn net.Conn, remote NetworkConfig) {
-	remoteConn, err := c.client.Dial(remote.Network, remote.Address)
-	if err != nil {
-		return
-	}
-
-	copyConn := func(writer, reader net.Conn) {
-		defer writer.Close()
-		defer reader.Close()
-
-		_, err := io.Copy(writer, reader)
-		if err != nil {
-			return
-		}
-	}
-	go copyConn(localConn, remoteConn)
-	go copyConn(remoteConn, localConn)
+func (c *ClientType) Close() error {
+	c.closeOnce.Do(func() {
+		close(c.closed)
+	})
+	c.setState(StateClosed, nil)
+	c.closeTunnels()
+	err := c.conn().Close()
+	c.setJump(nil)
+	return err
 }
 
-func (c *ClientType) Close() error {
-	c.session.Close()
-	return c.client.Close()
+// setJump 替换使用的跳板链，释放之前的，重连时通过新的跳板链连接
+func (c *ClientType) setJump(hop *bastion) {
+	c.mu.Lock()
+	old := c.jump
+	c.jump = hop
+	c.mu.Unlock()
+	jumpPool.release(old)
 }
 
+// Proxy 通过当前连接访问 auth，返回的客户端不拥有当前连接，需要各自 Close。
+// 需要多级跳板或在多个客户端之间共享跳板机时使用 AuthConfig.JumpHosts
 func (c *ClientType) Proxy(auth *AuthConfig) (Client, error) {
-	conn, connErr := c.client.Dial(auth.Network, auth.Address)
-	if connErr != nil {
-		return nil, connErr
-	}
-	proxyCfg, cfgErr := authConfig(auth)
-	if cfgErr != nil {
-		return nil, cfgErr
+	// 重连时通过跳板机当前的连接重新建立
+	dial := func() (*ssh.Client, error) {
+		conn, connErr := c.conn().Dial(auth.Network, auth.Address)
+		if connErr != nil {
+			return nil, connErr
+		}
+		proxyCfg, release, cfgErr := authConfig(auth)
+		defer release()
+		if cfgErr != nil {
+			conn.Close()
+			return nil, cfgErr
+		}
+		return handshake(conn, auth.Address, proxyCfg)
 	}
-	ncc, cs, reqs, err := ssh.NewClientConn(conn, auth.Address, proxyCfg)
+	client, err := dial()
 	if err != nil {
 		return nil, err
 	}
-	client := ssh.NewClient(ncc, cs, reqs)
-	session, sessionErr := client.NewSession()
-	if sessionErr != nil {
-		return nil, sessionErr
-	}
-	return &ClientType{client: client, session: session, pb: c.pb}, nil
+	child := &ClientType{
+		client:      client,
+		dial:        dial,
+		newProgress: c.newProgress,
+		sessions:    c.newSessionLimit(),
+		killGrace:   c.killGrace,
+		keepAlive:   c.keepAlive,
+		reconnect:   c.reconnect,
+		closed:      make(chan struct{}),
+		failed:      make(chan struct{}),
+	}
+	child.startMonitor()
+	return child, nil
 }
 
+// WithProgressBar 在终端显示传输进度条，每次传输使用一个新的进度条
 func WithProgressBar(show bool) Option {
 	return func(c *ClientType) {
-		c.pb = show
+		c.newProgress = nil
+		if show {
+			c.newProgress = NewBarProgress
+		}
 	}
 }
@@ -1,19 +1,31 @@
 package ssh
 
 import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"github.com/Lvzhenqian/library/ssh/sshtest"
+	"golang.org/x/crypto/ssh"
+	"io"
 	"log"
+	"net"
 	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strings"
 	"testing"
+	"time"
 )
 
 var (
 	auth = &AuthConfig{
-		Username:   "root",
-		Password:   "charles",
-		PrivateKey: "",
+		Username: "root",
+		Password: "charles",
 		NetworkConfig: NetworkConfig{
 			Network:        "tcp",
-			Address:        "10.4.15.141:22",
+			Address:        "127.0.0.1:22",
 			ConnectTimeout: 2,
 		},
 	}
@@ -21,97 +33,296 @@ var (
 	stderr = os.Stderr
 )
 
-func TestClientType_Login(t *testing.T) {
-	cli, newClientErr := NewClient(auth)
-	if newClientErr != nil {
-		t.Errorf("new client error: %v", newClientErr)
-		return
+// newTestServer 启动进程内的 SSH 服务端，返回使用密码登录并固定主机公钥的配置
+func newTestServer(t *testing.T, option ...sshtest.Option) *AuthConfig {
+	server, err := sshtest.NewServer(append([]sshtest.Option{sshtest.WithPassword("tester", "secret")}, option...)...)
+	if err != nil {
+		t.Fatal(err)
 	}
-	defer cli.Close()
-	if err := cli.Login(); err != nil {
+	t.Cleanup(func() { server.Close() })
+	return &AuthConfig{
+		Username: "tester",
+		Password: "secret",
+		HostKey: HostKeyPolicy{
+			Mode:         HostKeyFingerprint,
+			Fingerprints: []string{ssh.FingerprintSHA256(server.HostKey())},
+		},
+		NetworkConfig: NetworkConfig{
+			Network:        "tcp",
+			Address:        server.Addr(),
+			ConnectTimeout: 5,
+		},
+	}
+}
+
+func newTestClient(t *testing.T, conf *AuthConfig, option ...Option) Client {
+	cli, err := NewClient(conf, option...)
+	if err != nil {
+		t.Fatalf("new client error: %v", err)
+	}
+	t.Cleanup(func() { cli.Close() })
+	return cli
+}
+
+func TestClientType_WrongPassword(t *testing.T) {
+	conf := newTestServer(t)
+	conf.Password = "wrong"
+	if cli, err := NewClient(conf); err == nil {
+		cli.Close()
+		t.Fatal("want authentication error")
+	}
+}
+
+func TestClientType_Certificate(t *testing.T) {
+	userCA, hostCA := newTestCA(t), newTestCA(t)
+	hostKey := newTestCA(t)
+	hostCert, err := ssh.NewCertSigner(signTestCert(t, hostCA, hostKey.PublicKey(), ssh.HostCert, "127.0.0.1"), hostKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	server, err := sshtest.NewServer(sshtest.WithUserCA(userCA.PublicKey()), sshtest.WithHostKey(hostCert))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.Close()
+
+	key := writeTestKey(t, "")
+	content, _ := os.ReadFile(key)
+	signer, err := ssh.ParsePrivateKey(content)
+	if err != nil {
+		t.Fatal(err)
+	}
+	certFile := filepath.Join(t.TempDir(), "user-cert.pub")
+	cert := signTestCert(t, userCA, signer.PublicKey(), ssh.UserCert, "tester")
+	if err := os.WriteFile(certFile, ssh.MarshalAuthorizedKey(cert), 0644); err != nil {
 		t.Fatal(err)
 	}
+	conf := &AuthConfig{
+		Username:    "tester",
+		PrivateKey:  key,
+		Certificate: certFile,
+		HostKey: HostKeyPolicy{
+			Mode:   HostKeyCertificate,
+			CAKeys: []string{string(ssh.MarshalAuthorizedKey(hostCA.PublicKey()))},
+		},
+		NetworkConfig: NetworkConfig{Network: "tcp", Address: server.Addr(), ConnectTimeout: 5},
+	}
+	cli := newTestClient(t, conf)
+	if err := cli.Run("true", io.Discard, io.Discard); err != nil {
+		t.Fatal(err)
+	}
+
+	conf.Username = "other"
+	if cli, err := NewClient(conf); err == nil {
+		cli.Close()
+		t.Error("want certificate rejected for a principal it was not issued to")
+	}
+	conf.Username = "tester"
+	conf.HostKey.CAKeys = []string{string(ssh.MarshalAuthorizedKey(userCA.PublicKey()))}
+	// 握手错误没有用 %w 包装 HostKeyCallback 的错误，只能检查错误信息
+	if _, err := NewClient(conf); err == nil || !strings.Contains(err.Error(), "untrusted CA") {
+		t.Errorf("want untrusted host CA error, got %v", err)
+	}
 }
 
 func TestClientType_Run(t *testing.T) {
-	cli, newClientErr := NewClient(auth)
-	if newClientErr != nil {
-		t.Errorf("new client error: %v", newClientErr)
-		return
+	cli := newTestClient(t, newTestServer(t))
+	var out, errOut bytes.Buffer
+	if err := cli.Run("echo hello; echo oops >&2", &out, &errOut); err != nil {
+		t.Fatal(err)
 	}
-	defer cli.Close()
-	if err := cli.Run("hostname", stdout, stderr); err != nil {
+	if out.String() != "hello\n" || errOut.String() != "oops\n" {
+		t.Errorf("stdout %q, stderr %q", out.String(), errOut.String())
+	}
+
+	err := cli.Run("exit 3", io.Discard, io.Discard)
+	var runErr *RunError
+	if !errors.As(err, &runErr) || runErr.ExitStatus != 3 {
+		t.Errorf("want exit status 3, got %v", err)
+	}
+}
+
+func TestClientType_Exec(t *testing.T) {
+	home := t.TempDir()
+	cli := newTestClient(t, newTestServer(t, sshtest.WithHome(home)))
+	result, err := cli.Exec(context.Background(), `echo "$GREETING"; pwd; cat`,
+		WithEnv("GREETING", "hi"), WithStdin(strings.NewReader("from stdin")))
+	if err != nil {
 		t.Fatal(err)
 	}
+	want := "hi\n" + home + "\nfrom stdin"
+	if got := string(result.Stdout); got != want {
+		t.Errorf("stdout %q, want %q", got, want)
+	}
 }
 
-func TestClientType_Push(t *testing.T) {
-	cli, Newerr := NewClient(auth, WithProgressBar(true))
-	if Newerr != nil {
-		t.Errorf("new client error: %v", Newerr)
-		return
+func TestClientType_RunContext(t *testing.T) {
+	cli := newTestClient(t, newTestServer(t))
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	err := cli.RunContext(ctx, "sleep 10", io.Discard, io.Discard)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("want deadline exceeded, got %v", err)
 	}
-	defer cli.Close()
-	if err := cli.Push("~/Downloads/jdk-8u241-linux-x64.tar.gz", "/tmp"); err != nil {
-		t.Error(err)
+	var runErr *RunError
+	if !errors.As(err, &runErr) || !runErr.Timeout || runErr.Signal != "TERM" {
+		t.Errorf("want timeout killed by TERM, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("command not terminated, took %s", elapsed)
 	}
 }
 
-func TestClientType_Get(t *testing.T) {
-	cli, Newerr := NewClient(auth)
+func testPushGet(t *testing.T, cli Client) {
+	local, remote := t.TempDir(), t.TempDir()
+	writeTree(t, local, map[string]string{
+		"a.txt":       "hello",
+		"dir/b.txt":   "world",
+		"dir/c/d.txt": strings.Repeat("x", 100*1024),
+	})
 
-	if Newerr != nil {
-		t.Errorf("new client error: %v", Newerr)
-		return
+	if err := cli.Push(filepath.Join(local, "a.txt"), remote); err != nil {
+		t.Fatal(err)
 	}
-	defer cli.Close()
-	if err := cli.Get("/tmp/a.txt", "/Users/charles/Downloads"); err != nil {
-		t.Error(err)
+	if err := cli.Push(filepath.Join(local, "dir"), remote); err != nil {
+		t.Fatal(err)
+	}
+	// 目标不存在时作为文件路径
+	if err := cli.Push(filepath.Join(local, "a.txt"), filepath.Join(remote, "new.txt")); err != nil {
+		t.Fatal(err)
+	}
+	if err := cli.Push(filepath.Join(local, "missing"), remote); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("push missing source: want not exist error, got %v", err)
+	}
+	for name, want := range map[string]string{"a.txt": "hello", "new.txt": "hello", "dir/b.txt": "world"} {
+		if b, err := os.ReadFile(filepath.Join(remote, name)); err != nil || string(b) != want {
+			t.Errorf("pushed %s: %q, %v", name, b, err)
+		}
+	}
+
+	back := t.TempDir()
+	if err := cli.Get(filepath.Join(remote, "a.txt"), back); err != nil {
+		t.Fatal(err)
+	}
+	if err := cli.Get(filepath.Join(remote, "dir"), back); err != nil {
+		t.Fatal(err)
+	}
+	if err := cli.Get(filepath.Join(remote, "a.txt"), filepath.Join(back, "new.txt")); err != nil {
+		t.Fatal(err)
+	}
+	if b, err := os.ReadFile(filepath.Join(back, "new.txt")); err != nil || string(b) != "hello" {
+		t.Errorf("get to a new file: %q, %v", b, err)
+	}
+	for _, name := range []string{"a.txt", "dir/b.txt", "dir/c/d.txt"} {
+		want, _ := os.ReadFile(filepath.Join(local, name))
+		if b, err := os.ReadFile(filepath.Join(back, name)); err != nil || !bytes.Equal(b, want) {
+			t.Errorf("got %s: %d bytes, %v", name, len(b), err)
+		}
 	}
 }
 
-func TestClientType_TunnelStart(t *testing.T) {
-	LocalConfig := NetworkConfig{
-		Network: "tcp",
-		Address: "127.0.0.1:6666",
+func TestClientType_PushGet(t *testing.T) {
+	testPushGet(t, newTestClient(t, newTestServer(t)))
+}
+
+func TestClientType_PushGetSCP(t *testing.T) {
+	if _, err := exec.LookPath("scp"); err != nil {
+		t.Skip("scp not installed")
 	}
-	RemoteConfig := NetworkConfig{
-		Network: "tcp",
-		Address: "10.1.12.46:22",
+	testPushGet(t, newTestClient(t, newTestServer(t, sshtest.WithSftp(false))))
+}
+
+func echoLine(t *testing.T, addr string) {
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
 	}
-	cli, Newerr := NewClient(auth)
-	if Newerr != nil {
-		t.Errorf("new client error: %v", Newerr)
-		return
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	if _, err := io.WriteString(conn, "ping\n"); err != nil {
+		t.Fatal(err)
 	}
-	if err := cli.TunnelStart(LocalConfig, RemoteConfig); err != nil {
-		t.Errorf("start ssh tunnel error: %v", err)
+	line, err := bufio.NewReader(conn).ReadString('\n')
+	if err != nil || line != "ping\n" {
+		t.Errorf("echo %q, %v", line, err)
 	}
 }
 
-func TestSshClient_Proxy(t *testing.T) {
-	cli, Newerr := NewClient(auth)
-	if Newerr != nil {
-		t.Errorf("new client error: %v", Newerr)
-		return
+func TestClientType_StartTunnel(t *testing.T) {
+	cli := newTestClient(t, newTestServer(t))
+	target := echoServer(t)
+	tunnel, err := cli.StartTunnel(context.Background(),
+		NetworkConfig{Network: "tcp", Address: "127.0.0.1:0"},
+		NetworkConfig{Network: "tcp", Address: target})
+	if err != nil {
+		t.Fatal(err)
 	}
-	defer cli.Close()
-	secondClient, secErr := cli.Proxy(&AuthConfig{
-		Username:   "root",
-		Password:   "123456",
-		PrivateKey: "",
-		NetworkConfig: NetworkConfig{
-			Network:        "tcp",
-			Address:        "10.1.12.46:22",
-			ConnectTimeout: 5,
-		},
-	})
-	if secErr != nil {
-		t.Errorf("proxy second clien error: %v", secErr)
-		return
+	defer tunnel.Close()
+	echoLine(t, tunnel.Addr().String())
+}
+
+func TestClientType_RemoteForward(t *testing.T) {
+	cli := newTestClient(t, newTestServer(t))
+	target := echoServer(t)
+	tunnel, err := cli.RemoteForward(
+		NetworkConfig{Network: "tcp", Address: "127.0.0.1:0"},
+		NetworkConfig{Network: "tcp", Address: target})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tunnel.Close()
+	echoLine(t, tunnel.Addr().String())
+}
+
+func TestClientType_Proxy(t *testing.T) {
+	cli := newTestClient(t, newTestServer(t))
+	second, err := cli.Proxy(newTestServer(t, sshtest.WithHandler(func(s *sshtest.Session) int {
+		io.WriteString(s.Stdout, "second\n")
+		return 0
+	})))
+	if err != nil {
+		t.Fatalf("proxy second client error: %v", err)
+	}
+	defer second.Close()
+	var out bytes.Buffer
+	if err := second.Run("hostname", &out, io.Discard); err != nil {
+		t.Fatal(err)
+	}
+	if out.String() != "second\n" {
+		t.Errorf("stdout %q", out.String())
+	}
+}
+
+func TestClientType_Expect(t *testing.T) {
+	cli := newTestClient(t, newTestServer(t, sshtest.WithHandler(func(s *sshtest.Session) int {
+		if s.Pty == nil {
+			return 1
+		}
+		io.WriteString(s.Stdout, "login: ")
+		name, _ := bufio.NewReader(s.Stdin).ReadString('\n')
+		io.WriteString(s.Stdout, "welcome "+strings.TrimSpace(name)+"\r\n")
+		return 0
+	})))
+	e, err := cli.Expect("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer e.Close()
+	if _, err := e.Expect(regexp.MustCompile(`login: `)); err != nil {
+		t.Fatal(err)
+	}
+	e.SendLine("tester")
+	m, err := e.Expect(regexp.MustCompile(`welcome (\w+)`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.Groups[1] != "tester" {
+		t.Errorf("match %q", m.Groups)
+	}
+	if err := e.Wait(); err != nil {
+		t.Error(err)
 	}
-	defer secondClient.Close()
-	secondClient.Run("hostname", stdout, stderr)
 }
 
 func ExampleClientType_Run() {
@@ -0,0 +1,619 @@
+package ssh
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"github.com/pkg/sftp"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// DefaultTransferConcurrency 目录传输时默认同时传输的文件数
+const DefaultTransferConcurrency = 4
+
+// partSuffix 原子写入时临时文件的后缀，临时文件和目标文件在同一目录，断点续传时会复用
+const partSuffix = ".part"
+
+// ChecksumError 传输完成后两端的 SHA-256 不一致
+type ChecksumError struct {
+	Path   string
+	Local  string
+	Remote string
+}
+
+func (e *ChecksumError) Error() string {
+	return fmt.Sprintf("sha256 mismatch for %s: local %s, remote %s", e.Path, e.Local, e.Remote)
+}
+
+// FileError 目录传输中单个文件的错误，Path 为相对传输目录父目录的路径
+type FileError struct {
+	Path string
+	Err  error
+}
+
+func (e *FileError) Error() string {
+	return e.Path + ": " + e.Err.Error()
+}
+
+func (e *FileError) Unwrap() error {
+	return e.Err
+}
+
+// TransferError 目录传输结束后汇总所有失败的文件，没有失败的文件仍然会传输完成
+type TransferError struct {
+	Failed []*FileError
+	// Total 文件和目录的总数
+	Total int
+}
+
+func (e *TransferError) Error() string {
+	msg := make([]string, 0, len(e.Failed))
+	for _, f := range e.Failed {
+		msg = append(msg, f.Error())
+	}
+	return fmt.Sprintf("%d/%d files failed:\n%s", len(e.Failed), e.Total, strings.Join(msg, "\n"))
+}
+
+// SymlinkMode 目录传输时符号链接的处理方式
+type SymlinkMode int
+
+const (
+	// SymlinkFollow 传输链接指向的内容，指向目录时递归传输，出现循环时返回错误
+	SymlinkFollow SymlinkMode = iota
+	// SymlinkPreserve 在目标端创建指向相同路径的符号链接
+	SymlinkPreserve
+	// SymlinkSkip 跳过符号链接
+	SymlinkSkip
+)
+
+// SkippedFile 传输时跳过的文件
+type SkippedFile struct {
+	Path   string
+	Reason string
+}
+
+// TransferReport 目录传输的统计，路径同 FileError
+type TransferReport struct {
+	Files    int
+	Dirs     int
+	Symlinks int
+	// Bytes 需要传输的文件大小之和
+	Bytes   int64
+	Skipped []SkippedFile
+}
+
+type transfer struct {
+	resume        bool
+	verify        bool
+	atomic        bool
+	maxRetries    int
+	backoff       time.Duration
+	concurrency   int
+	symlinks      SymlinkMode
+	preserveMode  bool
+	preserveTimes bool
+	preserveOwner bool
+	strictSpecial bool
+	report        *TransferReport
+	ctx           context.Context
+}
+
+type TransferOption func(*transfer)
+
+// WithResume 断点续传，从目标文件（原子写入时为临时文件）已有的大小继续传输。
+// 目标文件比源文件大时重新传输；目标文件内容是否和源文件一致需要配合 WithVerify 检查
+func WithResume(resume bool) TransferOption {
+	return func(t *transfer) {
+		t.resume = resume
+	}
+}
+
+// WithVerify 传输完成后比较两端的 SHA-256，远程优先使用 sha256sum 命令，其次是 sftp 的 check-file 扩展，
+// 都不可用（或没有空闲的 session 配额）时通过 sftp 把文件读回来计算，大文件的流量会翻倍。
+// 不一致时返回 *ChecksumError，配置了重试时从头重新传输
+func WithVerify(verify bool) TransferOption {
+	return func(t *transfer) {
+		t.verify = verify
+	}
+}
+
+// WithAtomic 先写入同目录下的 .文件名.part 临时文件，传输（和校验）完成后再重命名为目标文件，
+// 传输中断时目标文件保持不变
+func WithAtomic(atomic bool) TransferOption {
+	return func(t *transfer) {
+		t.atomic = atomic
+	}
+}
+
+// WithRetry 传输失败后最多重试 maxRetries 次，第 n 次重试前等待 n*backoff，最长 1 分钟。
+// 校验失败以外的错误重试前会重新打开 sftp 会话，配合 WithReconnect 和 WithResume 可以在连接断开后续传。
+// 源文件不存在或没有权限时不重试
+func WithRetry(maxRetries int, backoff time.Duration) TransferOption {
+	return func(t *transfer) {
+		if backoff <= 0 {
+			backoff = time.Second
+		}
+		t.maxRetries = maxRetries
+		t.backoff = backoff
+	}
+}
+
+// WithConcurrency 目录传输时同时传输的文件数
+func WithConcurrency(n int) TransferOption {
+	return func(t *transfer) {
+		if n > 0 {
+			t.concurrency = n
+		}
+	}
+}
+
+// WithSymlinks 目录中符号链接的处理方式，默认 SymlinkFollow
+func WithSymlinks(mode SymlinkMode) TransferOption {
+	return func(t *transfer) {
+		t.symlinks = mode
+	}
+}
+
+// WithPreserveMode 保留权限位，包括 setuid/setgid/sticky
+func WithPreserveMode(preserve bool) TransferOption {
+	return func(t *transfer) {
+		t.preserveMode = preserve
+	}
+}
+
+// WithPreserveTimes 保留修改时间
+func WithPreserveTimes(preserve bool) TransferOption {
+	return func(t *transfer) {
+		t.preserveTimes = preserve
+	}
+}
+
+// WithPreserveOwner 保留 uid/gid，只有目标端是 root 时才能修改，没有权限时忽略
+func WithPreserveOwner(preserve bool) TransferOption {
+	return func(t *transfer) {
+		t.preserveOwner = preserve
+	}
+}
+
+// WithSpecialFiles 遇到 socket、设备、管道等特殊文件时的处理，默认跳过并记录到 TransferReport，
+// strict 为 true 时作为失败的文件返回
+func WithSpecialFiles(strict bool) TransferOption {
+	return func(t *transfer) {
+		t.strictSpecial = strict
+	}
+}
+
+// WithTransferReport 传输结束后把统计和跳过的文件写入 report
+func WithTransferReport(report *TransferReport) TransferOption {
+	return func(t *transfer) {
+		t.report = report
+	}
+}
+
+// WithTransferContext ctx 结束后不再开始新的文件和重试，已经开始的文件传输完成后返回 ctx.Err()
+func WithTransferContext(ctx context.Context) TransferOption {
+	return func(t *transfer) {
+		t.ctx = ctx
+	}
+}
+
+func newTransfer(option []TransferOption) *transfer {
+	t := &transfer{concurrency: DefaultTransferConcurrency, ctx: context.Background()}
+	for _, opt := range option {
+		opt(t)
+	}
+	return t
+}
+
+// sftpOptions 单个大文件使用并发读写。并发写入中断后文件中间可能有空洞，断点续传时只能顺序写入
+func (t *transfer) sftpOptions() []sftp.ClientOption {
+	return []sftp.ClientOption{sftp.UseConcurrentReads(true), sftp.UseConcurrentWrites(!t.resume)}
+}
+
+// retry 按重试策略调用 fn，restart 为 true 表示上一次校验失败，需要放弃已传输的内容
+func (t *transfer) retry(fn func(restart bool) error) error {
+	restart := false
+	for attempt := 0; ; attempt++ {
+		if attempt > 0 {
+			backoff := time.Duration(attempt) * t.backoff
+			if backoff > maxReconnectBackoff {
+				backoff = maxReconnectBackoff
+			}
+			timer := time.NewTimer(backoff)
+			select {
+			case <-timer.C:
+			case <-t.ctx.Done():
+				timer.Stop()
+			}
+		}
+		if err := t.ctx.Err(); err != nil {
+			return err
+		}
+		err := fn(restart)
+		if err == nil || attempt >= t.maxRetries || !retryable(err) {
+			return err
+		}
+		var checksumErr *ChecksumError
+		restart = errors.As(err, &checksumErr)
+	}
+}
+
+// retryConn 同 retry，上一次不是校验失败时先换一个新的 sftp 客户端：连接断开或重连后，
+// 在原来的客户端上重试只会得到同样的错误
+func (t *transfer) retryConn(conn *sftpConn, fn func(cli *sftp.Client, restart bool) error) error {
+	cli, attempted := conn.client(), false
+	return t.retry(func(restart bool) error {
+		if attempted && !restart {
+			var err error
+			if cli, err = conn.reopen(cli); err != nil {
+				return err
+			}
+		}
+		attempted = true
+		return fn(cli, restart)
+	})
+}
+
+func retryable(err error) bool {
+	return !errors.Is(err, os.ErrNotExist) && !errors.Is(err, os.ErrPermission)
+}
+
+// target 实际写入的文件，remote 表示 dst 是远程路径
+func (t *transfer) target(dst string, remote bool) string {
+	if !t.atomic {
+		return dst
+	}
+	if remote {
+		return path.Join(path.Dir(dst), "."+path.Base(dst)+partSuffix)
+	}
+	return filepath.Join(filepath.Dir(dst), "."+filepath.Base(dst)+partSuffix)
+}
+
+// offset 续传的起始位置，existing 为目标文件已有的大小，-1 表示不存在
+func (t *transfer) offset(existing, total int64, restart bool) int64 {
+	if !t.resume || restart || existing < 0 || existing > total {
+		return 0
+	}
+	return existing
+}
+
+func fileSHA256(r io.Reader) (string, error) {
+	h := sha256.New()
+	if _, err := io.Copy(h, r); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+func localSHA256(name string) (string, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	return fileSHA256(f)
+}
+
+// parseSHA256Sum 解析 sha256sum/shasum 的输出
+func parseSHA256Sum(out []byte) (string, bool) {
+	fields := strings.Fields(string(out))
+	if len(fields) == 0 || len(fields[0]) != sha256.Size*2 {
+		return "", false
+	}
+	if _, err := hex.DecodeString(fields[0]); err != nil {
+		return "", false
+	}
+	return strings.ToLower(fields[0]), true
+}
+
+// remoteSHA256 优先在远程执行 sha256sum/shasum，其次使用 sftp 的 check-file 扩展，都不可用时通过 sftp
+// 读取整个文件计算，这时校验产生的流量和传输文件本身一样多。
+// 调用方持有 cli 占用的 session，执行命令不能等待配额，否则 WithMaxSessions(1) 时会死锁
+func (c *ClientType) remoteSHA256(cli *sftp.Client, name string) (string, error) {
+	if sum, ok := c.sha256sum(cli, name); ok {
+		return sum, nil
+	}
+	f, err := cli.Open(name)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	return fileSHA256(f)
+}
+
+// sha256sum 有空闲的 session 配额时在远程计算 SHA-256，没有配额、命令和 check-file 扩展都不可用时返回 false
+func (c *ClientType) sha256sum(cli *sftp.Client, name string) (string, bool) {
+	if !c.tryAcquireSession() {
+		return "", false
+	}
+	defer c.releaseSession()
+	for _, cmd := range []string{"sha256sum", "shasum -a 256"} {
+		session, err := c.conn().NewSession()
+		if err != nil {
+			return "", false
+		}
+		out, err := session.Output(cmd + " -- " + shellQuote(name))
+		session.Close()
+		if sum, ok := parseSHA256Sum(out); ok && err == nil {
+			return sum, true
+		}
+	}
+	if _, ok := cli.HasExtension("check-file"); !ok {
+		return "", false
+	}
+	sum, err := c.checkFile(name)
+	return sum, err == nil
+}
+
+// checkFile 在单独的 sftp 子系统中发送 check-file-name 请求，cli 正在传输时不能复用它的通道
+func (c *ClientType) checkFile(name string) (string, error) {
+	session, err := c.conn().NewSession()
+	if err != nil {
+		return "", err
+	}
+	defer session.Close()
+	if err := session.RequestSubsystem("sftp"); err != nil {
+		return "", err
+	}
+	w, err := session.StdinPipe()
+	if err != nil {
+		return "", err
+	}
+	r, err := session.StdoutPipe()
+	if err != nil {
+		return "", err
+	}
+	return sftpCheckFile(struct {
+		io.Reader
+		io.Writer
+	}{r, w}, name)
+}
+
+// remoteRename 覆盖已存在的目标文件，服务端不支持 posix-rename 时先删除再重命名
+func remoteRename(cli *sftp.Client, oldname, newname string) error {
+	if err := cli.PosixRename(oldname, newname); err == nil {
+		return nil
+	}
+	if err := cli.Remove(newname); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	return cli.Rename(oldname, newname)
+}
+
+// pushFile 传输一次，dst 为远程的真实路径，返回实际写入的文件
+func (c *ClientType) pushFile(cli *sftp.Client, src, dst string, opt *transfer, restart bool, p fileProgress) (string, error) {
+	target := opt.target(dst, true)
+	srcFile, openErr := os.Open(src)
+	if openErr != nil {
+		return "", openErr
+	}
+	defer srcFile.Close()
+	SrcStat, err := srcFile.Stat()
+	if err != nil {
+		return "", err
+	}
+
+	existing := int64(-1)
+	if stat, statErr := cli.Stat(target); statErr == nil && stat.Mode().IsRegular() {
+		existing = stat.Size()
+	}
+	offset := opt.offset(existing, SrcStat.Size(), restart)
+	flags := os.O_WRONLY | os.O_CREATE | os.O_TRUNC
+	if offset > 0 {
+		flags = os.O_WRONLY | os.O_CREATE
+	}
+	dstFile, sftpCreateErr := cli.OpenFile(target, flags)
+	if sftpCreateErr != nil {
+		return "", sftpCreateErr
+	}
+	defer dstFile.Close()
+	if _, err := dstFile.Seek(offset, io.SeekStart); err != nil {
+		return "", err
+	}
+	if _, err := srcFile.Seek(offset, io.SeekStart); err != nil {
+		return "", err
+	}
+
+	p.start(SrcStat.Size(), offset)
+	if _, err := dstFile.ReadFrom(io.TeeReader(srcFile, p)); err != nil {
+		return "", err
+	}
+	return target, dstFile.Close()
+}
+
+// getFile 传输一次，src 为远程的真实路径，返回实际写入的本地文件
+func (c *ClientType) getFile(cli *sftp.Client, src, dst string, opt *transfer, restart bool, p fileProgress) (string, error) {
+	target := opt.target(dst, false)
+	srcFile, sftpOpenErr := cli.Open(src)
+	if sftpOpenErr != nil {
+		return "", sftpOpenErr
+	}
+	defer srcFile.Close()
+	SrcStat, err := srcFile.Stat()
+	if err != nil {
+		return "", err
+	}
+
+	existing := int64(-1)
+	if stat, statErr := os.Stat(target); statErr == nil && stat.Mode().IsRegular() {
+		existing = stat.Size()
+	}
+	offset := opt.offset(existing, SrcStat.Size(), restart)
+	flags := os.O_WRONLY | os.O_CREATE | os.O_TRUNC
+	if offset > 0 {
+		flags = os.O_WRONLY | os.O_CREATE
+	}
+	dstFile, dstCreateErr := os.OpenFile(target, flags, 0666)
+	if dstCreateErr != nil {
+		return "", dstCreateErr
+	}
+	defer dstFile.Close()
+	if _, err := dstFile.Seek(offset, io.SeekStart); err != nil {
+		return "", err
+	}
+	if _, err := srcFile.Seek(offset, io.SeekStart); err != nil {
+		return "", err
+	}
+
+	p.start(SrcStat.Size(), offset)
+	if _, err := srcFile.WriteTo(io.MultiWriter(dstFile, p)); err != nil {
+		return "", err
+	}
+	return target, dstFile.Close()
+}
+
+// pushOne 按重试策略上传一个文件，dst 为远程的真实路径
+func (c *ClientType) pushOne(conn *sftpConn, src, dst string, opt *transfer, p fileProgress) error {
+	return p.done(opt.retryConn(conn, func(cli *sftp.Client, restart bool) error {
+		target, err := c.pushFile(cli, src, dst, opt, restart, p)
+		if err != nil {
+			return err
+		}
+		if opt.verify {
+			local, hashErr := localSHA256(src)
+			if hashErr != nil {
+				return hashErr
+			}
+			remote, hashErr := c.remoteSHA256(cli, target)
+			if hashErr != nil {
+				return hashErr
+			}
+			if local != remote {
+				return &ChecksumError{Path: dst, Local: local, Remote: remote}
+			}
+		}
+		if target != dst {
+			return remoteRename(cli, target, dst)
+		}
+		return nil
+	}))
+}
+
+// getOne 按重试策略下载一个文件，src 为远程的真实路径
+func (c *ClientType) getOne(conn *sftpConn, src, dst string, opt *transfer, p fileProgress) error {
+	return p.done(opt.retryConn(conn, func(cli *sftp.Client, restart bool) error {
+		target, err := c.getFile(cli, src, dst, opt, restart, p)
+		if err != nil {
+			return err
+		}
+		if opt.verify {
+			local, hashErr := localSHA256(target)
+			if hashErr != nil {
+				return hashErr
+			}
+			remote, hashErr := c.remoteSHA256(cli, src)
+			if hashErr != nil {
+				return hashErr
+			}
+			if local != remote {
+				return &ChecksumError{Path: dst, Local: local, Remote: remote}
+			}
+		}
+		if target != dst {
+			return os.Rename(target, dst)
+		}
+		return nil
+	}))
+}
+
+// PushFile 上传单个文件，默认每次从头覆盖写入，可以通过 TransferOption 开启断点续传、校验、原子写入和重试
+func (c *ClientType) PushFile(src string, dst string, option ...TransferOption) error {
+	opt := newTransfer(option)
+	sftpClient, sftpErr := c.newSftpConn(opt.sftpOptions()...)
+	if errors.Is(sftpErr, ErrSftpUnavailable) {
+		return c.scpPush(src, dst, opt)
+	}
+	if sftpErr != nil {
+		return sftpErr
+	}
+	defer sftpClient.Close()
+	RealSrc := localRealPath(src)
+	RealDst := remoteRealpath(dst, sftpClient.client())
+
+	SrcStat, err := os.Stat(RealSrc)
+	if err != nil {
+		return err
+	}
+	progress, name := c.progress(), path.Base(RealSrc)
+	progress.Start(name, 1, SrcStat.Size())
+	err = c.pushOne(sftpClient, RealSrc, RealDst, opt, fileProgress{Progress: progress, name: name})
+	progress.Finish(err)
+	if err != nil || !opt.preserveMode && !opt.preserveTimes && !opt.preserveOwner {
+		return err
+	}
+	return opt.setAttrs(&remoteFS{c: c, conn: sftpClient}, RealDst, SrcStat)
+}
+
+// GetFile 下载单个文件，TransferOption 同 PushFile
+func (c *ClientType) GetFile(src string, dst string, option ...TransferOption) error {
+	opt := newTransfer(option)
+	sftpClient, sftpErr := c.newSftpConn(opt.sftpOptions()...)
+	if errors.Is(sftpErr, ErrSftpUnavailable) {
+		return c.scpGet(src, dst, opt)
+	}
+	if sftpErr != nil {
+		return sftpErr
+	}
+	defer sftpClient.Close()
+	RealSrc := remoteRealpath(src, sftpClient.client())
+	RealDst := localRealPath(dst)
+
+	SrcStat, err := sftpClient.client().Stat(RealSrc)
+	if err != nil {
+		return err
+	}
+	progress, name := c.progress(), path.Base(RealSrc)
+	progress.Start(name, 1, SrcStat.Size())
+	err = c.getOne(sftpClient, RealSrc, RealDst, opt, fileProgress{Progress: progress, name: name})
+	progress.Finish(err)
+	if err != nil || !opt.preserveMode && !opt.preserveTimes && !opt.preserveOwner {
+		return err
+	}
+	return opt.setAttrs(localFS{}, RealDst, SrcStat)
+}
+
+type fileJob struct {
+	src  string
+	dst  string
+	rel  string
+	info os.FileInfo
+}
+
+// transferFiles 使用 concurrency 个 goroutine 并发传输，返回失败的文件
+func (opt *transfer) transferFiles(jobs []fileJob, copyFile func(job fileJob) error) []*FileError {
+	results := runGroup(len(jobs), opt.concurrency, func(i int) *FileError {
+		if copyErr := copyFile(jobs[i]); copyErr != nil {
+			return &FileError{Path: jobs[i].rel, Err: copyErr}
+		}
+		return nil
+	}, func(i int, err error) *FileError {
+		return &FileError{Path: jobs[i].rel, Err: err}
+	})
+
+	failed := make([]*FileError, 0)
+	for _, r := range results {
+		if r != nil {
+			failed = append(failed, r)
+		}
+	}
+	return failed
+}
+
+// newTransferError 没有失败的文件时返回 nil
+func newTransferError(failed []*FileError, total int) error {
+	if len(failed) == 0 {
+		return nil
+	}
+	sort.Slice(failed, func(i, j int) bool {
+		return failed[i].Path < failed[j].Path
+	})
+	return &TransferError{Failed: failed, Total: total}
+}
@@ -0,0 +1,269 @@
+package ssh
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestTransfer_Target(t *testing.T) {
+	opt := newTransfer(nil)
+	if got := opt.target("/data/a.tar", true); got != "/data/a.tar" {
+		t.Errorf("target = %q", got)
+	}
+	opt = newTransfer([]TransferOption{WithAtomic(true)})
+	if got := opt.target("/data/a.tar", true); got != "/data/.a.tar.part" {
+		t.Errorf("remote target = %q", got)
+	}
+	if got := opt.target("a.tar", true); got != ".a.tar.part" {
+		t.Errorf("relative target = %q", got)
+	}
+	if got := opt.target(filepath.Join("dir", "a.tar"), false); got != filepath.Join("dir", ".a.tar.part") {
+		t.Errorf("local target = %q", got)
+	}
+}
+
+func TestTransfer_Offset(t *testing.T) {
+	opt := newTransfer([]TransferOption{WithResume(true)})
+	cases := []struct {
+		existing, total int64
+		restart         bool
+		want            int64
+	}{
+		{-1, 100, false, 0},
+		{40, 100, false, 40},
+		{100, 100, false, 100},
+		{120, 100, false, 0},
+		{40, 100, true, 0},
+	}
+	for _, c := range cases {
+		if got := opt.offset(c.existing, c.total, c.restart); got != c.want {
+			t.Errorf("offset(%d, %d, %v) = %d, want %d", c.existing, c.total, c.restart, got, c.want)
+		}
+	}
+	if got := newTransfer(nil).offset(40, 100, false); got != 0 {
+		t.Errorf("offset without resume = %d", got)
+	}
+}
+
+func TestTransfer_Retry(t *testing.T) {
+	opt := newTransfer([]TransferOption{WithRetry(3, time.Millisecond)})
+
+	var restarts []bool
+	err := opt.retry(func(restart bool) error {
+		restarts = append(restarts, restart)
+		switch len(restarts) {
+		case 1:
+			return &ChecksumError{Path: "a"}
+		case 2:
+			return errors.New("connection lost")
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(restarts) != 3 || restarts[0] || !restarts[1] || restarts[2] {
+		t.Errorf("restarts = %v", restarts)
+	}
+
+	attempts := 0
+	err = opt.retry(func(bool) error {
+		attempts++
+		return os.ErrNotExist
+	})
+	if !errors.Is(err, os.ErrNotExist) || attempts != 1 {
+		t.Errorf("not exist: attempts = %d, err = %v", attempts, err)
+	}
+
+	attempts = 0
+	opt.retry(func(bool) error {
+		attempts++
+		return errors.New("connection lost")
+	})
+	if attempts != 4 {
+		t.Errorf("attempts = %d, want 4", attempts)
+	}
+}
+
+func TestParseSHA256Sum(t *testing.T) {
+	const sum = "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"
+	if got, ok := parseSHA256Sum([]byte(sum + "  /tmp/empty\n")); !ok || got != sum {
+		t.Errorf("parse = %q, %v", got, ok)
+	}
+	for _, out := range []string{"", "sha256sum: /tmp/x: No such file or directory", "zz" + sum[2:] + "  x"} {
+		if _, ok := parseSHA256Sum([]byte(out)); ok {
+			t.Errorf("parse(%q) should fail", out)
+		}
+	}
+}
+
+func TestLocalSHA256(t *testing.T) {
+	name := filepath.Join(t.TempDir(), "empty")
+	if err := os.WriteFile(name, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	got, err := localSHA256(name)
+	if err != nil || got != "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855" {
+		t.Errorf("localSHA256 = %q, %v", got, err)
+	}
+}
+
+func TestTransfer_TransferFiles(t *testing.T) {
+	opt := newTransfer([]TransferOption{WithConcurrency(3)})
+	jobs := make([]fileJob, 0)
+	for i := 0; i < 20; i++ {
+		jobs = append(jobs, fileJob{rel: fmt.Sprintf("dir/%02d", i)})
+	}
+	walkErr := &FileError{Path: "dir/unreadable", Err: os.ErrPermission}
+
+	var running, peak int64
+	failed := opt.transferFiles(jobs, func(job fileJob) error {
+		n := atomic.AddInt64(&running, 1)
+		defer atomic.AddInt64(&running, -1)
+		for {
+			p := atomic.LoadInt64(&peak)
+			if n <= p || atomic.CompareAndSwapInt64(&peak, p, n) {
+				break
+			}
+		}
+		time.Sleep(10 * time.Millisecond)
+		if strings.HasSuffix(job.rel, "5") {
+			return errors.New("copy failed")
+		}
+		return nil
+	})
+	if peak > 3 {
+		t.Errorf("peak concurrency = %d", peak)
+	}
+	err := newTransferError(append(failed, walkErr), len(jobs)+1)
+
+	var transferErr *TransferError
+	if !errors.As(err, &transferErr) {
+		t.Fatalf("err = %v", err)
+	}
+	got := make([]string, 0)
+	for _, f := range transferErr.Failed {
+		got = append(got, f.Path)
+	}
+	if strings.Join(got, ",") != "dir/05,dir/15,dir/unreadable" || transferErr.Total != 21 {
+		t.Errorf("failed = %v, total = %d", got, transferErr.Total)
+	}
+	if !errors.Is(transferErr.Failed[2], os.ErrPermission) {
+		t.Error("FileError should unwrap")
+	}
+
+	failed = opt.transferFiles(jobs, func(fileJob) error { return nil })
+	if err := newTransferError(failed, len(jobs)); err != nil {
+		t.Errorf("err = %v", err)
+	}
+}
+
+func TestClientType_VerifyMaxSessions(t *testing.T) {
+	cli := newTestClient(t, newTestServer(t), WithMaxSessions(1))
+	dir := t.TempDir()
+	src := filepath.Join(dir, "a.txt")
+	if err := os.WriteFile(src, []byte("hello"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	done := make(chan error, 1)
+	go func() {
+		if err := cli.Push(src, filepath.Join(dir, "b.txt"), WithVerify(true)); err != nil {
+			done <- err
+			return
+		}
+		done <- cli.Get(filepath.Join(dir, "b.txt"), t.TempDir(), WithVerify(true))
+	}()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("verify with a single session deadlocked")
+	}
+}
+
+// dropProgress 传输到 after 字节时断开一次底层连接，记录每次 FileStart 的 offset
+type dropProgress struct {
+	nopProgress
+	after   int64
+	written int64
+	dropped int32
+	mu      sync.Mutex
+	conn    net.Conn
+	offsets []int64
+}
+
+func (p *dropProgress) dial(ctx context.Context, network, address string) (net.Conn, error) {
+	conn, err := (&net.Dialer{}).DialContext(ctx, network, address)
+	p.mu.Lock()
+	p.conn = conn
+	p.mu.Unlock()
+	return conn, err
+}
+
+func (p *dropProgress) FileStart(_ string, _, offset int64) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.offsets = append(p.offsets, offset)
+}
+
+func (p *dropProgress) Add(_ string, n int64) {
+	if atomic.AddInt64(&p.written, n) >= p.after && atomic.CompareAndSwapInt32(&p.dropped, 0, 1) {
+		p.mu.Lock()
+		p.conn.Close()
+		p.mu.Unlock()
+	}
+}
+
+func TestClientType_RetryAfterDrop(t *testing.T) {
+	progress := &dropProgress{after: 1 << 20}
+	conf := newTestServer(t)
+	conf.Dial = progress.dial
+	cli := newTestClient(t, conf, WithReconnect(0, 10*time.Millisecond), WithProgress(progress))
+
+	dir := t.TempDir()
+	data := make([]byte, 4<<20)
+	if _, err := rand.Read(data); err != nil {
+		t.Fatal(err)
+	}
+	src := filepath.Join(dir, "src.bin")
+	if err := os.WriteFile(src, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	option := []TransferOption{WithRetry(10, 20*time.Millisecond), WithResume(true), WithAtomic(true), WithVerify(true)}
+	for _, name := range []string{"push", "get"} {
+		atomic.StoreInt64(&progress.written, 0)
+		atomic.StoreInt32(&progress.dropped, 0)
+		progress.offsets = nil
+		dst := filepath.Join(dir, name+".bin")
+		var err error
+		if name == "push" {
+			err = cli.Push(src, dst, option...)
+		} else {
+			err = cli.Get(src, dst, option...)
+		}
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if got, err := os.ReadFile(dst); err != nil || !bytes.Equal(got, data) {
+			t.Fatalf("%s: content mismatch, %v", name, err)
+		}
+		// 第一次从头开始，断开后在新连接上从已写入的位置续传
+		if atomic.LoadInt32(&progress.dropped) != 1 || len(progress.offsets) < 2 || progress.offsets[len(progress.offsets)-1] == 0 {
+			t.Errorf("%s: offsets %v, want a resumed retry", name, progress.offsets)
+		}
+	}
+}
@@ -0,0 +1,424 @@
+package ssh
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// DefaultTunnelEventBuffer 隧道事件 channel 的默认缓冲大小
+const DefaultTunnelEventBuffer = 64
+
+type TunnelEventType int
+
+const (
+	// ConnOpened 新连接已经接通目标地址
+	ConnOpened TunnelEventType = iota
+	// ConnClosed 连接结束，Conn 中是最终的字节数
+	ConnClosed
+	// ConnError 连接目标地址或握手失败
+	ConnError
+	// TunnelStopped 隧道停止，Err 为停止原因，调用 Close 停止时为 nil
+	TunnelStopped
+)
+
+func (t TunnelEventType) String() string {
+	switch t {
+	case ConnOpened:
+		return "opened"
+	case ConnClosed:
+		return "closed"
+	case ConnError:
+		return "error"
+	case TunnelStopped:
+		return "stopped"
+	default:
+		return fmt.Sprintf("TunnelEventType(%d)", int(t))
+	}
+}
+
+// ConnStats 一个转发连接的信息
+type ConnStats struct {
+	ID uint64
+	// Source 发起连接的一端
+	Source net.Addr
+	// Target 转发的目标地址
+	Target string
+	// Sent 从 Source 发往 Target 的字节数
+	Sent int64
+	// Received 从 Target 返回给 Source 的字节数
+	Received int64
+	Start    time.Time
+}
+
+type TunnelEvent struct {
+	Type TunnelEventType
+	Conn ConnStats
+	Err  error
+}
+
+type tunnelConn struct {
+	id       uint64
+	source   net.Conn
+	target   atomic.Value
+	sent     int64
+	received int64
+	start    time.Time
+}
+
+func (tc *tunnelConn) stats() ConnStats {
+	target, _ := tc.target.Load().(string)
+	return ConnStats{
+		ID:       tc.id,
+		Source:   tc.source.RemoteAddr(),
+		Target:   target,
+		Sent:     atomic.LoadInt64(&tc.sent),
+		Received: atomic.LoadInt64(&tc.received),
+		Start:    tc.start,
+	}
+}
+
+type tunnelConfig struct {
+	maxConns    int
+	eventBuffer int
+}
+
+type TunnelOption func(*tunnelConfig)
+
+// WithMaxConns 同时转发的最大连接数，达到上限后新连接在 accept 队列中等待，小于等于 0 表示不限制
+func WithMaxConns(n int) TunnelOption {
+	return func(c *tunnelConfig) {
+		c.maxConns = n
+	}
+}
+
+// WithEventBuffer 事件 channel 的缓冲大小，缓冲满时新的事件会被丢弃，不会阻塞转发
+func WithEventBuffer(n int) TunnelOption {
+	return func(c *tunnelConfig) {
+		if n >= 0 {
+			c.eventBuffer = n
+		}
+	}
+}
+
+// tunnelDialer 为接入的连接建立到目标的连接，返回目标连接和目标地址
+type tunnelDialer func(conn net.Conn) (net.Conn, string, error)
+
+// Tunnel 一个正在运行的端口转发，Close 停止监听并断开所有转发中的连接
+type Tunnel struct {
+	mu       sync.Mutex
+	listener net.Listener
+	// relisten 监听失效（如断线重连）时重新监听，为 nil 表示不重试
+	relisten func() (net.Listener, error)
+	dial     tunnelDialer
+	conns    map[*tunnelConn]struct{}
+	nextID   uint64
+	active   int64
+	sent     int64
+	received int64
+	wg       sync.WaitGroup
+	stopped  bool
+	// slots 限制同时转发的连接数，为 nil 表示不限制
+	slots  chan struct{}
+	events chan TunnelEvent
+
+	done      chan struct{}
+	closeOnce sync.Once
+	err       error
+}
+
+func newTunnel(ctx context.Context, listener net.Listener, relisten func() (net.Listener, error), dial tunnelDialer, option ...TunnelOption) *Tunnel {
+	conf := &tunnelConfig{eventBuffer: DefaultTunnelEventBuffer}
+	for _, opt := range option {
+		opt(conf)
+	}
+	t := &Tunnel{
+		listener: listener,
+		relisten: relisten,
+		dial:     dial,
+		conns:    make(map[*tunnelConn]struct{}),
+		events:   make(chan TunnelEvent, conf.eventBuffer),
+		done:     make(chan struct{}),
+	}
+	if conf.maxConns > 0 {
+		t.slots = make(chan struct{}, conf.maxConns)
+	}
+	go t.serve()
+	if ctx.Done() != nil {
+		go func() {
+			select {
+			case <-ctx.Done():
+				t.stop(ctx.Err())
+			case <-t.done:
+			}
+		}()
+	}
+	return t
+}
+
+// Addr 监听地址，远程转发时为远程服务器上的地址
+func (t *Tunnel) Addr() net.Addr {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.listener.Addr()
+}
+
+// Active 正在转发的连接数
+func (t *Tunnel) Active() int {
+	return int(atomic.LoadInt64(&t.active))
+}
+
+// Bytes 所有连接累计发送和接收的字节数，包括正在转发的连接
+func (t *Tunnel) Bytes() (sent, received int64) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	sent, received = t.sent, t.received
+	for tc := range t.conns {
+		sent += atomic.LoadInt64(&tc.sent)
+		received += atomic.LoadInt64(&tc.received)
+	}
+	return sent, received
+}
+
+// Conns 正在转发的连接
+func (t *Tunnel) Conns() []ConnStats {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	ret := make([]ConnStats, 0, len(t.conns))
+	for tc := range t.conns {
+		ret = append(ret, tc.stats())
+	}
+	return ret
+}
+
+// Events 连接打开、关闭、出错和隧道停止的事件，隧道停止后关闭。
+// 没有及时读取时，缓冲满后的事件会被丢弃
+func (t *Tunnel) Events() <-chan TunnelEvent {
+	return t.events
+}
+
+// Done 隧道停止后关闭
+func (t *Tunnel) Done() <-chan struct{} {
+	return t.done
+}
+
+// Wait 阻塞直到隧道停止，调用 Close 停止时返回 nil
+func (t *Tunnel) Wait() error {
+	<-t.done
+	return t.err
+}
+
+// Close 停止监听并关闭所有转发中的连接
+func (t *Tunnel) Close() error {
+	return t.stop(nil)
+}
+
+func (t *Tunnel) emit(event TunnelEvent) {
+	select {
+	case t.events <- event:
+	default:
+	}
+}
+
+func (t *Tunnel) stop(err error) error {
+	var closeErr error
+	t.closeOnce.Do(func() {
+		t.mu.Lock()
+		t.stopped = true
+		t.err = err
+		closeErr = t.listener.Close()
+		for tc := range t.conns {
+			tc.source.Close()
+		}
+		t.mu.Unlock()
+		t.wg.Wait()
+		t.emit(TunnelEvent{Type: TunnelStopped, Err: err})
+		close(t.events)
+		close(t.done)
+	})
+	return closeErr
+}
+
+func (t *Tunnel) isStopped() bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.stopped
+}
+
+func (t *Tunnel) serve() {
+	for {
+		if t.slots != nil {
+			select {
+			case t.slots <- struct{}{}:
+			case <-t.done:
+				return
+			}
+		}
+		t.mu.Lock()
+		listener := t.listener
+		t.mu.Unlock()
+
+		conn, err := listener.Accept()
+		if err != nil {
+			t.release()
+			if t.isStopped() {
+				return
+			}
+			if t.relisten != nil {
+				l, listenErr := t.relisten()
+				if listenErr == nil {
+					t.mu.Lock()
+					t.listener = l
+					t.mu.Unlock()
+					continue
+				}
+				err = listenErr
+			}
+			go t.stop(err)
+			return
+		}
+		tc := t.track(conn)
+		if tc == nil {
+			conn.Close()
+			t.release()
+			return
+		}
+		go t.forward(tc)
+	}
+}
+
+func (t *Tunnel) release() {
+	if t.slots != nil {
+		<-t.slots
+	}
+}
+
+// track 记录连接，Close 时统一关闭
+func (t *Tunnel) track(conn net.Conn) *tunnelConn {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.stopped {
+		return nil
+	}
+	t.nextID++
+	tc := &tunnelConn{id: t.nextID, source: conn, start: time.Now()}
+	t.conns[tc] = struct{}{}
+	t.wg.Add(1)
+	atomic.AddInt64(&t.active, 1)
+	return tc
+}
+
+func (t *Tunnel) untrack(tc *tunnelConn) {
+	t.mu.Lock()
+	delete(t.conns, tc)
+	t.sent += atomic.LoadInt64(&tc.sent)
+	t.received += atomic.LoadInt64(&tc.received)
+	t.mu.Unlock()
+	tc.source.Close()
+	atomic.AddInt64(&t.active, -1)
+	t.release()
+	t.wg.Done()
+}
+
+func (t *Tunnel) forward(tc *tunnelConn) {
+	defer t.untrack(tc)
+	target, addr, err := t.dial(tc.source)
+	tc.target.Store(addr)
+	if err != nil {
+		t.emit(TunnelEvent{Type: ConnError, Conn: tc.stats(), Err: err})
+		return
+	}
+	t.emit(TunnelEvent{Type: ConnOpened, Conn: tc.stats()})
+	pipe(tc.source, target, &tc.sent, &tc.received)
+	t.emit(TunnelEvent{Type: ConnClosed, Conn: tc.stats()})
+}
+
+type closeWriter interface {
+	CloseWrite() error
+}
+
+// countWriter 统计写入的字节数
+type countWriter struct {
+	w     io.Writer
+	count *int64
+}
+
+func (c *countWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	atomic.AddInt64(c.count, int64(n))
+	return n, err
+}
+
+// pipe 双向复制数据。一个方向读到 EOF 后只关闭对端的写方向（half-close），
+// 另一个方向仍然可以继续返回数据，两个方向都结束后关闭两端
+func pipe(source, target net.Conn, sent, received *int64) {
+	var wg sync.WaitGroup
+	copyHalf := func(dst, src net.Conn, count *int64) {
+		defer wg.Done()
+		_, err := io.Copy(&countWriter{w: dst, count: count}, src)
+		if cw, ok := dst.(closeWriter); ok && err == nil {
+			cw.CloseWrite()
+			return
+		}
+		// 不支持 half-close 或复制出错时直接关闭两端
+		source.Close()
+		target.Close()
+	}
+	wg.Add(2)
+	go copyHalf(target, source, sent)
+	go copyHalf(source, target, received)
+	wg.Wait()
+	source.Close()
+	target.Close()
+}
+
+// StartTunnel 本地端口转发（ssh -L），在本地监听 Local，把连接转发到远程的 Remote，立即返回。
+// ctx 结束、调用 Tunnel.Close 或客户端 Close 时隧道停止
+func (c *ClientType) StartTunnel(ctx context.Context, Local, Remote NetworkConfig, option ...TunnelOption) (*Tunnel, error) {
+	listener, err := net.Listen(Local.Network, Local.Address)
+	if err != nil {
+		return nil, err
+	}
+	t := newTunnel(ctx, listener, nil, func(net.Conn) (net.Conn, string, error) {
+		remoteConn, dialErr := c.conn().Dial(Remote.Network, Remote.Address)
+		if dialErr != nil {
+			return nil, Remote.Address, fmt.Errorf("dial %s error: %w", Remote.Address, dialErr)
+		}
+		return remoteConn, Remote.Address, nil
+	}, option...)
+	c.addTunnel(t)
+	return t, nil
+}
+
+// addTunnel 记录通过当前客户端建立的隧道，客户端 Close 时一起关闭
+func (c *ClientType) addTunnel(t *Tunnel) {
+	c.tunnelMu.Lock()
+	if c.tunnels == nil {
+		c.tunnels = make(map[*Tunnel]struct{})
+	}
+	c.tunnels[t] = struct{}{}
+	c.tunnelMu.Unlock()
+
+	go func() {
+		<-t.Done()
+		c.tunnelMu.Lock()
+		delete(c.tunnels, t)
+		c.tunnelMu.Unlock()
+	}()
+}
+
+func (c *ClientType) closeTunnels() {
+	c.tunnelMu.Lock()
+	tunnels := make([]*Tunnel, 0, len(c.tunnels))
+	for t := range c.tunnels {
+		tunnels = append(tunnels, t)
+	}
+	c.tunnelMu.Unlock()
+	for _, t := range tunnels {
+		t.Close()
+	}
+}
@@ -0,0 +1,199 @@
+package ssh
+
+import (
+	"errors"
+	"fmt"
+	"github.com/pkg/sftp"
+	"os"
+	"path"
+	"path/filepath"
+)
+
+// fileOwner 文件的 uid/gid，远程文件从 sftp 的属性中获取
+func fileOwner(info os.FileInfo) (int, int, bool) {
+	if stat, ok := info.Sys().(*sftp.FileStat); ok {
+		return int(stat.UID), int(stat.GID), true
+	}
+	return sysOwner(info)
+}
+
+// specialType 特殊文件的类型说明
+func specialType(mode os.FileMode) string {
+	switch {
+	case mode&os.ModeSocket != 0:
+		return "socket"
+	case mode&os.ModeNamedPipe != 0:
+		return "named pipe"
+	case mode&os.ModeCharDevice != 0:
+		return "character device"
+	case mode&os.ModeDevice != 0:
+		return "device"
+	default:
+		return "irregular file"
+	}
+}
+
+// setAttrs 按选项设置属性，先修改属主，避免 chown 清除 setuid/setgid
+func (t *transfer) setAttrs(dstFS syncFS, dst string, info os.FileInfo) error {
+	if t.preserveOwner {
+		if uid, gid, ok := fileOwner(info); ok {
+			if err := dstFS.Chown(dst, uid, gid); err != nil && !errors.Is(err, os.ErrPermission) {
+				return err
+			}
+		}
+	}
+	if t.preserveMode {
+		if err := dstFS.Chmod(dst, info.Mode()&(os.ModePerm|os.ModeSetuid|os.ModeSetgid|os.ModeSticky)); err != nil {
+			return err
+		}
+	}
+	if t.preserveTimes {
+		if err := dstFS.Chtimes(dst, info.ModTime(), info.ModTime()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// dirWalker 遍历源目录，按遍历顺序在目标端创建目录和符号链接，收集需要传输的文件
+type dirWalker struct {
+	srcFS  syncFS
+	dstFS  syncFS
+	opt    *transfer
+	report *TransferReport
+	jobs   []fileJob
+	dirs   []fileJob
+	failed []*FileError
+}
+
+func (w *dirWalker) fail(rel string, err error) {
+	w.failed = append(w.failed, &FileError{Path: rel, Err: err})
+}
+
+func (w *dirWalker) skip(rel, reason string) {
+	w.report.Skipped = append(w.report.Skipped, SkippedFile{Path: rel, Reason: reason})
+}
+
+// walk ancestors 为当前路径上所有目录解析后的真实路径，用于跟随符号链接时检查循环
+func (w *dirWalker) walk(src, dst, rel string, info os.FileInfo, ancestors []string) {
+	mode := info.Mode()
+	switch {
+	case mode&os.ModeSymlink != 0:
+		w.symlink(src, dst, rel, ancestors)
+	case mode.IsDir():
+		w.dir(src, dst, rel, info, ancestors)
+	case mode.IsRegular():
+		w.jobs = append(w.jobs, fileJob{src: src, dst: dst, rel: rel, info: info})
+		w.report.Files++
+		w.report.Bytes += info.Size()
+	case w.opt.strictSpecial:
+		w.fail(rel, fmt.Errorf("unsupported %s", specialType(mode)))
+	default:
+		w.skip(rel, specialType(mode))
+	}
+}
+
+func (w *dirWalker) symlink(src, dst, rel string, ancestors []string) {
+	switch w.opt.symlinks {
+	case SymlinkSkip:
+		w.skip(rel, "symlink")
+	case SymlinkPreserve:
+		target, err := w.srcFS.Readlink(src)
+		if err != nil {
+			w.fail(rel, err)
+			return
+		}
+		// 目标端已有同名的文件或链接时先删除
+		if existing, statErr := w.dstFS.Lstat(dst); statErr == nil && !existing.IsDir() {
+			w.dstFS.Remove(dst)
+		}
+		if err := w.dstFS.Symlink(target, dst); err != nil {
+			w.fail(rel, err)
+			return
+		}
+		w.report.Symlinks++
+	default:
+		info, err := w.srcFS.Stat(src)
+		if err != nil {
+			w.fail(rel, err)
+			return
+		}
+		if info.IsDir() {
+			real, realErr := w.srcFS.Realpath(src)
+			if realErr != nil {
+				w.fail(rel, realErr)
+				return
+			}
+			for _, a := range ancestors {
+				if a == real {
+					w.fail(rel, fmt.Errorf("symlink loop: %s", real))
+					return
+				}
+			}
+		}
+		w.walk(src, dst, rel, info, ancestors)
+	}
+}
+
+func (w *dirWalker) dir(src, dst, rel string, info os.FileInfo, ancestors []string) {
+	if err := w.dstFS.Mkdir(dst); err != nil {
+		w.fail(rel, err)
+		return
+	}
+	w.dirs = append(w.dirs, fileJob{src: src, dst: dst, rel: rel, info: info})
+	w.report.Dirs++
+	if w.opt.symlinks == SymlinkFollow {
+		real, err := w.srcFS.Realpath(src)
+		if err != nil {
+			w.fail(rel, err)
+			return
+		}
+		ancestors = append(ancestors[:len(ancestors):len(ancestors)], real)
+	}
+
+	entries, err := w.srcFS.ReadDir(src)
+	if err != nil {
+		w.fail(rel, err)
+		return
+	}
+	for _, entry := range entries {
+		name := entry.Name()
+		w.walk(w.srcFS.Join(src, name), w.dstFS.Join(dst, name), path.Join(rel, name), entry, ancestors)
+	}
+}
+
+// transferDir 把 src 目录本身传输到 dst 下：先遍历创建目录和符号链接，再并发传输文件，
+// 最后从最深的目录开始设置目录属性（写入文件会改变目录的修改时间）
+func (c *ClientType) transferDir(srcFS, dstFS syncFS, src, dst string, opt *transfer, copyFile func(job fileJob, p fileProgress) error) error {
+	report := opt.report
+	if report == nil {
+		report = &TransferReport{}
+	}
+	*report = TransferReport{}
+
+	info, err := srcFS.Stat(src)
+	if err != nil {
+		return err
+	}
+	name := path.Base(filepath.ToSlash(src))
+	w := &dirWalker{srcFS: srcFS, dstFS: dstFS, opt: opt, report: report}
+	w.walk(src, dstFS.Join(dst, name), name, info, nil)
+
+	progress := c.progress()
+	progress.Start(name, report.Files, report.Bytes)
+	failed := append(w.failed, opt.transferFiles(w.jobs, func(job fileJob) error {
+		if copyErr := copyFile(job, fileProgress{Progress: progress, name: job.rel}); copyErr != nil {
+			return copyErr
+		}
+		return opt.setAttrs(dstFS, job.dst, job.info)
+	})...)
+	for i := len(w.dirs) - 1; i >= 0; i-- {
+		dir := w.dirs[i]
+		if attrErr := opt.setAttrs(dstFS, dir.dst, dir.info); attrErr != nil {
+			failed = append(failed, &FileError{Path: dir.rel, Err: attrErr})
+		}
+	}
+	err = newTransferError(failed, report.Files+report.Dirs+report.Symlinks+len(w.failed))
+	progress.Finish(err)
+	return err
+}
@@ -0,0 +1,151 @@
+package ssh
+
+import (
+	"errors"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+)
+
+func localCopy(job fileJob, p fileProgress) error {
+	src, err := os.Open(job.src)
+	if err != nil {
+		return p.done(err)
+	}
+	defer src.Close()
+	dst, err := os.Create(job.dst)
+	if err != nil {
+		return p.done(err)
+	}
+	defer dst.Close()
+	p.start(job.info.Size(), 0)
+	_, err = io.Copy(io.MultiWriter(dst, p), src)
+	return p.done(err)
+}
+
+func newLinkTree(t *testing.T) string {
+	root := filepath.Join(t.TempDir(), "src")
+	writeTree(t, root, map[string]string{"a.txt": "a", "sub/b.txt": "b"})
+	if err := os.Symlink("a.txt", filepath.Join(root, "link.txt")); err != nil {
+		t.Skip("symlink not supported:", err)
+	}
+	os.Symlink("sub", filepath.Join(root, "linkdir"))
+	return root
+}
+
+func TestClientType_transferDir_Symlinks(t *testing.T) {
+	c := &ClientType{}
+	src := newLinkTree(t)
+
+	dst := t.TempDir()
+	report := &TransferReport{}
+	opt := newTransfer([]TransferOption{WithSymlinks(SymlinkPreserve), WithTransferReport(report)})
+	if err := c.transferDir(localFS{}, localFS{}, src, dst, opt, localCopy); err != nil {
+		t.Fatal(err)
+	}
+	if target, err := os.Readlink(filepath.Join(dst, "src", "link.txt")); err != nil || target != "a.txt" {
+		t.Errorf("link.txt -> %q, %v", target, err)
+	}
+	if report.Files != 2 || report.Dirs != 2 || report.Symlinks != 2 {
+		t.Errorf("report = %+v", report)
+	}
+
+	dst = t.TempDir()
+	opt = newTransfer([]TransferOption{WithTransferReport(report)})
+	if err := c.transferDir(localFS{}, localFS{}, src, dst, opt, localCopy); err != nil {
+		t.Fatal(err)
+	}
+	if b, err := os.ReadFile(filepath.Join(dst, "src", "linkdir", "b.txt")); err != nil || string(b) != "b" {
+		t.Errorf("followed linkdir/b.txt = %q, %v", b, err)
+	}
+	if info, _ := os.Lstat(filepath.Join(dst, "src", "link.txt")); info == nil || !info.Mode().IsRegular() {
+		t.Error("followed link.txt should be a regular file")
+	}
+
+	dst = t.TempDir()
+	opt = newTransfer([]TransferOption{WithSymlinks(SymlinkSkip), WithTransferReport(report)})
+	if err := c.transferDir(localFS{}, localFS{}, src, dst, opt, localCopy); err != nil {
+		t.Fatal(err)
+	}
+	skipped := make([]string, 0)
+	for _, s := range report.Skipped {
+		skipped = append(skipped, s.Path+":"+s.Reason)
+	}
+	sort.Strings(skipped)
+	if strings.Join(skipped, ",") != "src/link.txt:symlink,src/linkdir:symlink" {
+		t.Errorf("skipped = %v", skipped)
+	}
+}
+
+func TestClientType_transferDir_SymlinkLoop(t *testing.T) {
+	c := &ClientType{}
+	src := filepath.Join(t.TempDir(), "src")
+	writeTree(t, src, map[string]string{"sub/a.txt": "a"})
+	if err := os.Symlink("..", filepath.Join(src, "sub", "up")); err != nil {
+		t.Skip("symlink not supported:", err)
+	}
+
+	err := c.transferDir(localFS{}, localFS{}, src, t.TempDir(), newTransfer(nil), localCopy)
+	var transferErr *TransferError
+	if !errors.As(err, &transferErr) || len(transferErr.Failed) != 1 || transferErr.Failed[0].Path != "src/sub/up" {
+		t.Fatalf("err = %v", err)
+	}
+}
+
+func TestClientType_transferDir_Attrs(t *testing.T) {
+	c := &ClientType{}
+	src := filepath.Join(t.TempDir(), "src")
+	writeTree(t, src, map[string]string{"bin/run.sh": "#!/bin/sh"})
+	os.Chmod(filepath.Join(src, "bin", "run.sh"), 0750)
+	old := time.Now().Add(-48 * time.Hour).Truncate(time.Second)
+	os.Chtimes(filepath.Join(src, "bin", "run.sh"), old, old)
+	os.Chtimes(filepath.Join(src, "bin"), old, old)
+
+	dst := t.TempDir()
+	opt := newTransfer([]TransferOption{WithPreserveMode(true), WithPreserveTimes(true), WithPreserveOwner(true)})
+	if err := c.transferDir(localFS{}, localFS{}, src, dst, opt, localCopy); err != nil {
+		t.Fatal(err)
+	}
+	info, err := os.Stat(filepath.Join(dst, "src", "bin", "run.sh"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode().Perm() != 0750 || !info.ModTime().Equal(old) {
+		t.Errorf("run.sh mode = %v, mtime = %v", info.Mode(), info.ModTime())
+	}
+	if dir, _ := os.Stat(filepath.Join(dst, "src", "bin")); !dir.ModTime().Equal(old) {
+		t.Errorf("bin mtime = %v", dir.ModTime())
+	}
+}
+
+func TestClientType_transferDir_Special(t *testing.T) {
+	c := &ClientType{}
+	src := filepath.Join(t.TempDir(), "src")
+	writeTree(t, src, map[string]string{"a.txt": "a"})
+	listener, err := net.Listen("unix", filepath.Join(src, "app.sock"))
+	if err != nil {
+		t.Skip("unix socket not supported:", err)
+	}
+	defer listener.Close()
+
+	report := &TransferReport{}
+	opt := newTransfer([]TransferOption{WithTransferReport(report)})
+	if err := c.transferDir(localFS{}, localFS{}, src, t.TempDir(), opt, localCopy); err != nil {
+		t.Fatal(err)
+	}
+	if len(report.Skipped) != 1 || report.Skipped[0] != (SkippedFile{Path: "src/app.sock", Reason: "socket"}) {
+		t.Errorf("skipped = %v", report.Skipped)
+	}
+
+	opt = newTransfer([]TransferOption{WithSpecialFiles(true)})
+	err = c.transferDir(localFS{}, localFS{}, src, t.TempDir(), opt, localCopy)
+	var transferErr *TransferError
+	if !errors.As(err, &transferErr) || transferErr.Failed[0].Path != "src/app.sock" {
+		t.Errorf("err = %v", err)
+	}
+}